@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/logger"
+)
+
+type APIConfig struct {
+	listen  string
+	token   string
+	control bool
+}
+
+func dumpAPIConfig(cfg *APIConfig) {
+	httplog.Debug("API Configuration:")
+	httplog.Debug("  API_LISTEN=%s", cfg.listen)
+	if cfg.token != "" {
+		httplog.Debug("  API_TOKEN=(set)")
+	}
+	httplog.Debug("  API_CONTROL=%v", cfg.control)
+}
+
+func (cfg *APIConfig) Enabled() bool {
+	return cfg.listen != ""
+}
+
+// APIServer serves the state of the workers as JSON and accepts control
+// actions when API_CONTROL is enabled:
+//
+//	GET  /api/status            RouterInfo, assignments, sockets, neighbors, reconcile, workers
+//	POST /api/solicit           send a Router Solicitation now
+//	POST /api/reconcile         reconcile RouterOS now
+//	POST /api/neighbors/flush   flush the neighbor cache
+//	POST /api/log/level         change LOG_LEVEL until the next reload
+type APIServer struct {
+	cfg *APIConfig
+	rac *RAClient
+	ndc *NDClient // nil when NDP_MODE=off
+	sup *Supervisor
+	ha  *HAElector // nil when HA_MODE=off
+}
+
+type APIStatus struct {
+	RouterInfo    *APIRouterInfo   `json:"router_info"`
+	Assignments   []APIAssignment  `json:"assignments"`
+	Sockets       []SocketStatus   `json:"sockets"`
+	Neighbors     []NeighborEntry  `json:"neighbors"`
+	LastReconcile *ReconcileResult `json:"last_reconcile"`
+	Workers       []WorkerStatus   `json:"workers"`
+	LogLevel      string           `json:"log_level"`
+	HA            *HAStatus        `json:"ha,omitempty"`
+}
+
+type APIRouterInfo struct {
+	Prefix        string    `json:"prefix"`
+	Gateway       string    `json:"gateway"`
+	Received      time.Time `json:"received"`
+	ValidLifetime int64     `json:"valid_lifetime"` // seconds. -1 if infinite
+	Provisional   bool      `json:"provisional"`    // restored from RA_STATE_FILE
+}
+
+// APIAssignment is a configured FlexibleIP and what it currently resolves to
+type APIAssignment struct {
+	Kind     string `json:"kind"` // setting the assignment comes from
+	Spec     string `json:"spec"`
+	Target   string `json:"target,omitempty"` // interface, pool or address list
+	Resolved string `json:"resolved,omitempty"`
+	Error    string `json:"error,omitempty"` // e.g. the subnet does not fit in the prefix
+}
+
+func NewAPIServer(cfg *APIConfig, rac *RAClient, ndc *NDClient, sup *Supervisor, ha *HAElector) *APIServer {
+	return &APIServer{
+		cfg: cfg,
+		rac: rac,
+		ndc: ndc,
+		sup: sup,
+		ha:  ha,
+	}
+}
+
+func (s *APIServer) Status() *APIStatus {
+	status := &APIStatus{
+		Assignments:   s.Assignments(),
+		Sockets:       []SocketStatus{},
+		Neighbors:     []NeighborEntry{},
+		LastReconcile: s.rac.LastReconcile(),
+		Workers:       s.sup.Status(),
+		LogLevel:      llog.Levels().String(),
+	}
+	if s.ha != nil {
+		status.HA = s.ha.Status()
+	}
+	if rinfo := s.rac.RouterInfo(); rinfo != nil {
+		status.RouterInfo = &APIRouterInfo{
+			Prefix:        rinfo.prefix.String(),
+			Gateway:       rinfo.gateway.String(),
+			Received:      rinfo.received,
+			ValidLifetime: lifetimeToSeconds(rinfo.validLifetime),
+			Provisional:   rinfo.provisional,
+		}
+	}
+	if sock := s.rac.Socket(); sock != nil {
+		status.Sockets = append(status.Sockets, *sock)
+	}
+	if s.ndc != nil {
+		status.Sockets = append(status.Sockets, s.ndc.Sockets()...)
+		status.Neighbors = s.ndc.Neighbors()
+	}
+	return status
+}
+
+// Assignments resolves every FlexibleIP of the RA and ND configuration
+func (s *APIServer) Assignments() []APIAssignment {
+	assignments := []APIAssignment{}
+	add := func(kind string, fip FlexibleIP, target string) {
+		a := APIAssignment{Kind: kind, Spec: fip.String(), Target: target}
+		if ip, err := s.rac.resolveFIP(fip); err == nil {
+			a.Resolved = ip.String()
+		} else if s.rac.RouterInfo() != nil {
+			a.Error = err.Error()
+		}
+		assignments = append(assignments, a)
+	}
+
+	racfg := s.rac.config()
+	addIP := func(kind string, a ROSIPAssign) {
+		assignment := APIAssignment{Kind: kind, Spec: a.key(), Target: a.ifname}
+		if ip, err := s.rac.resolveIPAssign(racfg, a); err == nil {
+			assignment.Resolved = ip.String()
+		} else if s.rac.RouterInfo() != nil {
+			assignment.Error = err.Error()
+		}
+		assignments = append(assignments, assignment)
+	}
+	for _, a := range racfg.rosExtIPs {
+		addIP("RA_ROS_EXTERNAL_IPS", a)
+	}
+	for _, a := range racfg.rosIntIPs {
+		addIP("RA_ROS_INTERNAL_IPS", a)
+	}
+	for _, a := range racfg.rosPools {
+		add("RA_ROS_POOLS", a.ip, a.poolname)
+	}
+	for _, a := range racfg.rosLists {
+		add("RA_ROS_ADDRESS_LISTS", a.ip, a.list)
+	}
+	if s.ndc != nil {
+		ndcfg := s.ndc.config()
+		for _, fip := range ndcfg.prefixes {
+			add("NDP_PREFIXES", fip, "")
+		}
+		for _, fip := range ndcfg.excludes {
+			add("NDP_EXCLUDE_IPS", fip, "")
+		}
+	}
+	return assignments
+}
+
+func (s *APIServer) authorized(r *http.Request) bool {
+	if s.cfg.token == "" {
+		return true
+	}
+	expected := "Bearer " + s.cfg.token
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// handle wraps an endpoint with the method and token checks
+func (s *APIServer) handle(method string, control bool, f func(w http.ResponseWriter, r *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSONError(w, http.StatusMethodNotAllowed, "use %s", method)
+			return
+		}
+		if !s.authorized(r) {
+			writeJSONError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		if control && !s.cfg.control {
+			writeJSONError(w, http.StatusForbidden, "control actions are disabled (API_CONTROL=0)")
+			return
+		}
+		if control {
+			httplog.Info("API: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		}
+		f(w, r)
+	}
+}
+
+func (s *APIServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/status", s.handle(http.MethodGet, false, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Status())
+	}))
+	mux.Handle("/api/solicit", s.handle(http.MethodPost, true, func(w http.ResponseWriter, r *http.Request) {
+		if err := s.rac.Resolicit(); err != nil {
+			writeJSONError(w, http.StatusConflict, "%s", err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"result": "Router Solicitation sent"})
+	}))
+	mux.Handle("/api/reconcile", s.handle(http.MethodPost, true, func(w http.ResponseWriter, r *http.Request) {
+		if s.rac.config().mode != "ros" {
+			writeJSONError(w, http.StatusConflict, "RA_MODE is not ros")
+			return
+		}
+		// as reconcile does, a dry run is allowed on standby
+		if s.ha != nil && !s.ha.IsActive() && s.rac.ros.DryRunMode() == "off" {
+			writeJSONError(w, http.StatusConflict, "this instance is standby")
+			return
+		}
+		if s.rac.RouterInfo() == nil {
+			writeJSONError(w, http.StatusConflict, "no router advertisement has been received yet")
+			return
+		}
+		s.rac.reconcile(r.Context(), "api")
+		writeJSON(w, http.StatusOK, s.rac.LastReconcile())
+	}))
+	mux.Handle("/api/neighbors/flush", s.handle(http.MethodPost, true, func(w http.ResponseWriter, r *http.Request) {
+		if s.ndc == nil {
+			writeJSONError(w, http.StatusConflict, "NDP_MODE is off")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"flushed": s.ndc.FlushNeighbors()})
+	}))
+	mux.Handle("/api/log/level", s.handle(http.MethodPost, true, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid request: %s", err)
+			return
+		}
+		levels, err := logger.ParseLevels(req.Level)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		for name := range levels.Subsystems {
+			if !isLogSubsystem(name) {
+				writeJSONError(w, http.StatusBadRequest, "unknown subsystem '%s'", name)
+				return
+			}
+		}
+		llog.SetLevels(levels)
+		httplog.Info("Changed LOG_LEVEL to %s", levels)
+		writeJSON(w, http.StatusOK, map[string]string{"log_level": levels.String()})
+	}))
+	return mux
+}
+
+// Work serves until ctx is done
+func (s *APIServer) Work(ctx context.Context) error {
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	l, err := net.Listen("tcp", s.cfg.listen)
+	if err != nil {
+		return err
+	}
+	httplog.Info("Serving the API on http://%s/api/", l.Addr())
+	if s.cfg.token == "" {
+		httplog.Warning("API_TOKEN is not set. Anyone who can reach %s can use the API", l.Addr())
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(l)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPI(t *testing.T) {
+	fake := NewFakeRouter()
+	fake.AddNeighbor(net.ParseIP("2001:db8:1::1234"), net.HardwareAddr{0xb8, 0x27, 0xeb, 0xf6, 0x0f, 0x8f}, "reachable")
+	eip, _ := ParseROSIPAssign("ra-prefix::1/128@vlanflets", "")
+	rac := NewRAClient(&RAConfig{mode: "ros", rosGC: "off", rosExtIPs: []ROSIPAssign{eip}}, fake)
+	prefix, _ := ParseFlexibleIP("ra-prefix")
+	ndc := NewNDClient(&NDConfig{mode: "proxy-ros", prefixes: []FlexibleIP{prefix}, cacheTTL: time.Minute}, rac, fake)
+
+	// the neighbor cache answers without asking RouterOS again
+	target := net.ParseIP("2001:db8:1::1234")
+	if mac, err := ndc.Lookup(context.Background(), target); err != nil || mac == nil {
+		t.Fatalf("Lookup returned %s, %v", mac, err)
+	}
+	// forget the neighbor on the router
+	fake.tables["/ipv6/neighbor"] = nil
+	if mac, err := ndc.Lookup(context.Background(), target); err != nil || mac.String() != "b8:27:eb:f6:0f:8f" {
+		t.Fatalf("cached Lookup returned %s, %v", mac, err)
+	}
+
+	cfg := &APIConfig{token: "secret"}
+	srv := httptest.NewServer(NewAPIServer(cfg, rac, ndc, NewSupervisor(), nil).Handler())
+	defer srv.Close()
+	request := func(method string, path string, token string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %s", method, path, err)
+		}
+		defer res.Body.Close()
+		var body map[string]interface{}
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		return res.StatusCode, body
+	}
+
+	if code, _ := request("GET", "/api/status", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong token returned %d", code)
+	}
+	code, status := request("GET", "/api/status", "secret")
+	if code != http.StatusOK || status["router_info"] != nil || len(status["neighbors"].([]interface{})) != 1 {
+		t.Fatalf("unexpected status %d: %+v", code, status)
+	}
+
+	// read-only by default
+	if code, _ := request("POST", "/api/neighbors/flush", "secret"); code != http.StatusForbidden {
+		t.Fatalf("control action returned %d while API_CONTROL=0", code)
+	}
+	cfg.control = true
+	if code, _ := request("POST", "/api/reconcile", "secret"); code != http.StatusConflict {
+		t.Fatalf("reconcile before solicitation returned %d", code)
+	}
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	rac.setRouterInfo(&RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")})
+	if code, res := request("POST", "/api/reconcile", "secret"); code != http.StatusOK || res["trigger"] != "api" || res["changes"].(float64) != 1 {
+		t.Fatalf("unexpected reconcile result %d: %+v", code, res)
+	}
+	_, status = request("GET", "/api/status", "secret")
+	assignment := status["assignments"].([]interface{})[0].(map[string]interface{})
+	if assignment["resolved"] != "2001:db8:1::1/128" || assignment["target"] != "vlanflets" {
+		t.Fatalf("unexpected assignment: %+v", assignment)
+	}
+	if code, res := request("POST", "/api/neighbors/flush", "secret"); code != http.StatusOK || res["flushed"].(float64) != 1 {
+		t.Fatalf("unexpected flush result %d: %+v", code, res)
+	}
+	if mac, _ := ndc.Lookup(context.Background(), target); mac != nil {
+		t.Fatalf("flushed neighbor was served from the cache")
+	}
+	if code, _ := request("POST", "/api/solicit", "secret"); code != http.StatusConflict {
+		t.Fatalf("solicit without a socket returned %d", code)
+	}
+	if code, _ := request("GET", "/api/solicit", "secret"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET of a control action returned %d", code)
+	}
+
+	// reconcile is refused on a standby instance unless it is a dry run
+	standby := httptest.NewServer(NewAPIServer(cfg, rac, ndc, NewSupervisor(), NewHAElector(&HAConfig{node: "b"})).Handler())
+	defer standby.Close()
+	reconcile := func() (int, map[string]interface{}) {
+		req, _ := http.NewRequest("POST", standby.URL+"/api/reconcile", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST /api/reconcile failed: %s", err)
+		}
+		defer res.Body.Close()
+		var body map[string]interface{}
+		_ = json.NewDecoder(res.Body).Decode(&body)
+		return res.StatusCode, body
+	}
+	if code, body := reconcile(); code != http.StatusConflict || body["error"] != "this instance is standby" {
+		t.Fatalf("reconcile on standby returned %d: %+v", code, body)
+	}
+	fake.SetDryRun("observe")
+	if code, body := reconcile(); code != http.StatusOK || body["trigger"] != "api" {
+		t.Fatalf("dry-run reconcile on standby returned %d: %+v", code, body)
+	}
+}
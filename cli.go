@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/logger"
+)
+
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands []command
+
+func init() {
+	// initialized here since cmdHelp refers to commands
+	commands = []command{
+		{"run", "", "run the RA/ND workers (default)", cmdRun},
+		{"check-config", "", "validate the configuration and print it", cmdCheckConfig},
+		{"solicit", "[interface]", "send a Router Solicitation and print the received RA", cmdSolicit},
+		{"ros-test", "", "connect to RouterOS and verify the permissions of ROS_USER", cmdROSTest},
+		{"lookup", "<ip>", "look up the MAC address of <ip> as the ND proxy does", cmdLookup},
+		{"plan", "", "print the changes to be made on RouterOS for the current RA and exit", cmdPlan},
+		{"help", "", "show this help", cmdHelp},
+	}
+}
+
+// runCommand runs the subcommand given by args[0] ("run" if omitted)
+func runCommand(args []string) {
+	name := "run"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "-h" || name == "--help" {
+		name = "help"
+	}
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(args); err != nil {
+			llog.Fatal("%s", err)
+		}
+		llog.Close()
+		return
+	}
+	fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", name)
+	printUsage(os.Stderr)
+	os.Exit(2)
+}
+
+func printUsage(w *os.File) {
+	fmt.Fprintf(w, "Usage: %s [command] [args]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-24s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.usage)
+	}
+	fmt.Fprintf(w, "\nThe configuration is read from the environment variables and CONFIG_FILE.\n")
+}
+
+func cmdHelp(args []string) error {
+	printUsage(os.Stdout)
+	return nil
+}
+
+// appConfig is the configuration of all workers
+type appConfig struct {
+	ra        *RAConfig
+	nd        *NDConfig
+	ndNeedROS bool
+	hook      *HookConfig
+	ddns      *DDNSConfig
+	metrics   *MetricsConfig
+	api       *APIConfig
+	ha        *HAConfig
+}
+
+func (cfg *appConfig) needROS() bool {
+	return cfg.ra.mode == "ros" || cfg.ndNeedROS
+}
+
+// loadAppConfig loads and dumps the configuration of all workers.
+// loadConfigFile must be called beforehand.
+func loadAppConfig() (*appConfig, error) {
+	var cfg appConfig
+	var err error
+
+	cfg.ra, err = loadRAConfig()
+	if err != nil {
+		return nil, err
+	}
+	dumpRAConfig(cfg.ra)
+
+	cfg.nd, cfg.ndNeedROS, err = loadNDConfig(cfg.ra)
+	if err != nil {
+		return nil, err
+	}
+	dumpNDConfig(cfg.nd)
+
+	cfg.hook, err = loadHookConfig()
+	if err != nil {
+		return nil, err
+	}
+	dumpHookConfig(cfg.hook)
+
+	cfg.ddns, err = loadDDNSConfig(cfg.ra)
+	if err != nil {
+		return nil, err
+	}
+	dumpDDNSConfig(cfg.ddns)
+
+	cfg.metrics, err = loadMetricsConfig()
+	if err != nil {
+		return nil, err
+	}
+	dumpMetricsConfig(cfg.metrics)
+
+	cfg.api, err = loadAPIConfig()
+	if err != nil {
+		return nil, err
+	}
+	dumpAPIConfig(cfg.api)
+
+	cfg.ha, err = loadHAConfig()
+	if err != nil {
+		return nil, err
+	}
+	dumpHAConfig(cfg.ha)
+
+	return &cfg, nil
+}
+
+func connectROS() (*ROSClient, error) {
+	roscfg, err := loadROSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return connectROSWith(roscfg)
+}
+
+// connectROSWith is connectROS with a configuration adjusted by the caller
+func connectROSWith(roscfg ROSConnectConfig) (*ROSClient, error) {
+	if roscfg.useTLS && roscfg.tlsCA == "" && roscfg.tlsFingerprint == "" {
+		llog.Warning("RouterOS certificate is not verified. Set ROS_TLS_CA or ROS_TLS_FINGERPRINT to prevent MITM attacks")
+	}
+	rosc, err := NewROSClient(roscfg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize RouterOS API: %s", err)
+	}
+	return rosc, nil
+}
+
+// commandContext is canceled by SIGTERM/SIGINT
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+}
+
+func cmdCheckConfig(args []string) error {
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	if getenv("LOG_LEVEL") == "" {
+		// show the dumps
+		llog.SetLevel(logger.DEBUG)
+		dumpLogConfig(currentLogConfig)
+	}
+	cfg, err := loadAppConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.needROS() {
+		if _, err := loadROSConfig(); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Configuration is valid")
+	return nil
+}
+
+func cmdSolicit(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: solicit [interface]")
+	}
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	racfg, err := loadRAConfig()
+	if err != nil {
+		return err
+	}
+	if racfg.mode == "off" {
+		return fmt.Errorf("RA_MODE is off")
+	}
+	if len(args) == 1 {
+		racfg.extIfs = []string{args[0]}
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	rac := NewRAClient(racfg, nil)
+	defer rac.Close()
+	if err := rac.initSock(); err != nil {
+		return fmt.Errorf("raInitSock failed: %s", err)
+	}
+	if err := rac.soilicit(ctx); err != nil {
+		return fmt.Errorf("raSolicit failed: %s", err)
+	}
+	rinfo := rac.RouterInfo()
+	fmt.Printf("interface: %s\n", rac.extSock.netif.Name)
+	fmt.Printf("prefix:    %s\n", rinfo.prefix.String())
+	fmt.Printf("gateway:   %s\n", rinfo.gateway.String())
+	return nil
+}
+
+func cmdROSTest(args []string) error {
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	ros, err := connectROS()
+	if err != nil {
+		return err
+	}
+	defer ros.Close()
+
+	ctx, stop := commandContext()
+	defer stop()
+	r, err := ros.CheckPermissions(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("identity: %s\n", r.Identity)
+	fmt.Printf("version:  %s\n", r.Version)
+	fmt.Printf("user:     %s (group %s)\n", r.User, r.Group)
+	fmt.Printf("policy:   %s\n", strings.Join(r.Policies, ","))
+	if len(r.Missing) > 0 {
+		return fmt.Errorf("group %s lacks the policies: %s", r.Group, strings.Join(r.Missing, ","))
+	}
+	fmt.Println("All required policies are granted")
+	return nil
+}
+
+func cmdLookup(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: lookup <ip>")
+	}
+	ip := net.ParseIP(args[0])
+	if ip == nil {
+		return fmt.Errorf("invalid ip '%s'", args[0])
+	}
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	racfg, err := loadRAConfig()
+	if err != nil {
+		return err
+	}
+	ndcfg, ndNeedROS, err := loadNDConfig(racfg)
+	if err != nil {
+		return err
+	}
+	if ndcfg.mode == "off" {
+		return fmt.Errorf("NDP_MODE is off")
+	}
+	var ros RouterBackend
+	if ndNeedROS {
+		rosc, err := connectROS()
+		if err != nil {
+			return err
+		}
+		defer rosc.Close()
+		ros = rosc
+	}
+
+	ctx, stop := commandContext()
+	defer stop()
+	ndc := NewNDClient(ndcfg, nil, ros)
+	defer ndc.Close()
+	if err := ndc.InitInternalSockets(); err != nil {
+		return err
+	}
+	hwaddr, err := ndc.Lookup(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if hwaddr == nil {
+		return fmt.Errorf("%s did not respond (NDP_MODE=%s)", ip, ndcfg.mode)
+	}
+	fmt.Printf("%s is at %s (NDP_MODE=%s)\n", ip, hwaddr, ndcfg.mode)
+	return nil
+}
+
+func cmdPlan(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("plan takes no arguments")
+	}
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	racfg, err := loadRAConfig()
+	if err != nil {
+		return err
+	}
+	if racfg.mode == "off" {
+		return fmt.Errorf("RA_MODE is off")
+	}
+	// only the RA worker is needed to make the plan
+	roscfg, err := loadROSConfig()
+	if err != nil {
+		return err
+	}
+	roscfg.dryRun = "exit"
+	ros, err := connectROSWith(roscfg)
+	if err != nil {
+		return err
+	}
+	defer ros.Close()
+
+	ctx, stop := commandContext()
+	defer stop()
+	rac := NewRAClient(racfg, ros)
+	defer rac.Close()
+	if err := rac.workInternal(ctx); err != errPlanCompleted {
+		return err
+	}
+	return nil
+}
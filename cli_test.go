@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/rosapitest"
+)
+
+func TestCLI(t *testing.T) {
+	// runs a command capturing its stdout
+	run := func(name string, args ...string) (string, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := os.Stdout
+		os.Stdout = w
+		var cmdErr error
+		found := false
+		for _, cmd := range commands {
+			if cmd.name == name {
+				cmdErr = cmd.run(args)
+				found = true
+			}
+		}
+		os.Stdout = stdout
+		w.Close()
+		out, _ := io.ReadAll(r)
+		if !found {
+			t.Fatalf("unknown command %s", name)
+		}
+		return string(out), cmdErr
+	}
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("LOG_LEVEL", "INFO")
+
+	// help lists every command
+	out, err := run("help")
+	if err != nil {
+		t.Fatalf("help failed: %s", err)
+	}
+	for _, cmd := range commands {
+		if !strings.Contains(out, "  "+cmd.name) {
+			t.Fatalf("help does not list %s:\n%s", cmd.name, out)
+		}
+	}
+
+	// wrong arguments are rejected before loading the configuration
+	for _, args := range [][]string{
+		{"run", "extra"},
+		{"solicit", "eth0", "eth1"},
+		{"lookup"},
+		{"lookup", "not-an-ip"},
+		{"plan", "extra"},
+	} {
+		if _, err := run(args[0], args[1:]...); err == nil {
+			t.Fatalf("%v was accepted", args)
+		}
+	}
+
+	// check-config
+	t.Setenv("RA_MODE", "off")
+	t.Setenv("NDP_MODE", "off")
+	if out, err := run("check-config"); err != nil || !strings.Contains(out, "Configuration is valid") {
+		t.Fatalf("check-config returned %q, %v", out, err)
+	}
+	t.Setenv("NDP_MODE", "bogus")
+	if _, err := run("check-config"); err == nil {
+		t.Fatalf("check-config accepted an invalid NDP_MODE")
+	}
+	t.Setenv("NDP_MODE", "off")
+	t.Setenv("RA_MODE", "ros")
+	t.Setenv("RA_ROS_EXTERNAL_INTERFACE", "vlanflets")
+	t.Setenv("ROS_HOST", "")
+	if _, err := run("check-config"); err == nil {
+		t.Fatalf("check-config accepted RA_MODE=ros without ROS_HOST")
+	}
+
+	// plan and solicit need RA
+	t.Setenv("RA_MODE", "off")
+	for _, name := range []string{"plan", "solicit"} {
+		if _, err := run(name); err == nil || !strings.Contains(err.Error(), "RA_MODE is off") {
+			t.Fatalf("%s with RA_MODE=off returned %v", name, err)
+		}
+	}
+	// and lookup needs ND
+	if _, err := run("lookup", "2001:db8::1"); err == nil || !strings.Contains(err.Error(), "NDP_MODE is off") {
+		t.Fatalf("lookup with NDP_MODE=off returned %v", err)
+	}
+
+	// ros-test reports the policies of ROS_USER
+	for _, policy := range []string{"read,write,api,test", "read,api,!write,!test"} {
+		srv, err := rosapitest.NewServer("fletsv6", "password")
+		if err != nil {
+			t.Fatalf("rosapitest.NewServer failed: %s", err)
+		}
+		defer srv.Close()
+		srv.Insert("/system/identity", map[string]string{"name": "MikroTik"})
+		srv.Insert("/user", map[string]string{"name": "fletsv6", "group": "fletsv6"})
+		srv.Insert("/user/group", map[string]string{"name": "fletsv6", "policy": policy})
+		t.Setenv("ROS_HOST", srv.Host())
+		t.Setenv("ROS_PORT", strconv.Itoa(srv.Port()))
+		t.Setenv("ROS_USER", "fletsv6")
+		t.Setenv("ROS_PASSWORD", "password")
+		out, err := run("ros-test")
+		if !strings.Contains(out, "identity: MikroTik") {
+			t.Fatalf("ros-test printed %q", out)
+		}
+		switch {
+		case policy == "read,write,api,test" && (err != nil || !strings.Contains(out, "All required policies are granted")):
+			t.Fatalf("ros-test returned %q, %v", out, err)
+		case policy != "read,write,api,test" && (err == nil || !strings.Contains(err.Error(), "write,test")):
+			t.Fatalf("ros-test did not report the missing policies: %v", err)
+		}
+	}
+
+	// ROS_PASSWORD is wrong
+	t.Setenv("ROS_PASSWORD", "wrong")
+	if _, err := run("ros-test"); err == nil {
+		t.Fatalf("ros-test succeeded with a wrong password")
+	}
+}
@@ -8,6 +8,9 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/logger"
+	"github.com/miekg/dns"
 )
 
 type Config struct {
@@ -69,29 +72,139 @@ func loadRouterInfo(cfg *Config) error {
 func loadROSConfig() (ROSConnectConfig, error) {
 	var cfg ROSConnectConfig
 
-	cfg.host = os.Getenv("ROS_HOST")
+	cfg.host = getenv("ROS_HOST")
 	if cfg.host == "" {
 		return cfg, fmt.Errorf("you must specify the routerboard api endpoint as ROS_HOST")
 	}
-	port := os.Getenv("ROS_PORT")
+	port := getenv("ROS_PORT")
 	if port != "" {
 		portnum, err := strconv.Atoi(port)
-		if err != nil {
-			cfg.port = portnum
+		if err != nil || portnum <= 0 || portnum > 65535 {
+			return cfg, fmt.Errorf("invalid ROS_PORT '%s'", port)
 		}
+		cfg.port = portnum
 	}
-	cfg.username = os.Getenv("ROS_USER")
-	if cfg.host == "" {
+	cfg.username = getenv("ROS_USER")
+	if cfg.username == "" {
 		cfg.username = "admin"
 	}
-	cfg.password = os.Getenv("ROS_PASSWORD")
-	useTLS := os.Getenv("ROS_USETLS")
+	cfg.password = getenv("ROS_PASSWORD")
+	useTLS := getenv("ROS_USETLS")
 	if useTLS == "1" {
 		cfg.useTLS = true
 	}
 
+	cfg.dryRun = getenv("ROS_DRY_RUN")
+	if cfg.dryRun == "" {
+		cfg.dryRun = "off"
+	}
+	if cfg.dryRun != "off" && cfg.dryRun != "exit" && cfg.dryRun != "observe" {
+		return cfg, fmt.Errorf("invalid ROS_DRY_RUN '%s'", cfg.dryRun)
+	}
+
+	cfg.tlsCA = getenv("ROS_TLS_CA")
+	cfg.tlsFingerprint = getenv("ROS_TLS_FINGERPRINT")
+	cfg.tlsServerName = getenv("ROS_TLS_SERVERNAME")
+	cfg.tlsCert = getenv("ROS_TLS_CERT")
+	cfg.tlsKey = getenv("ROS_TLS_KEY")
+	if !cfg.useTLS {
+		for _, name := range []string{"ROS_TLS_CA", "ROS_TLS_FINGERPRINT", "ROS_TLS_SERVERNAME", "ROS_TLS_CERT", "ROS_TLS_KEY"} {
+			if getenv(name) != "" {
+				return cfg, fmt.Errorf("%s is specified but ROS_USETLS is not 1", name)
+			}
+		}
+	}
+	if cfg.useTLS {
+		tlsConfig, err := newROSTLSConfig(cfg)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.tlsConfig = tlsConfig
+	}
+
+	for _, t := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"ROS_READ_TIMEOUT", &cfg.readTimeout},
+		{"ROS_WRITE_TIMEOUT", &cfg.writeTimeout},
+		{"ROS_PING_TIMEOUT", &cfg.pingTimeout},
+	} {
+		str := getenv(t.name)
+		if str == "" {
+			continue
+		}
+		ms, err := strconv.Atoi(str)
+		if err != nil || ms <= 0 {
+			return cfg, fmt.Errorf("invalid %s '%s'", t.name, str)
+		}
+		*t.value = time.Millisecond * time.Duration(ms)
+	}
+	cfg.readRetries = 2
+	if retries := getenv("ROS_READ_RETRIES"); retries != "" {
+		n, err := strconv.Atoi(retries)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid ROS_READ_RETRIES '%s'", retries)
+		}
+		cfg.readRetries = n
+	}
+
+	cfg.poolMin, cfg.poolMax = 1, 4
+	for _, n := range []struct {
+		name  string
+		value *int
+		min   int
+	}{
+		{"ROS_POOL_MIN", &cfg.poolMin, 0},
+		{"ROS_POOL_MAX", &cfg.poolMax, 1},
+	} {
+		str := getenv(n.name)
+		if str == "" {
+			continue
+		}
+		v, err := strconv.Atoi(str)
+		if err != nil || v < n.min {
+			return cfg, fmt.Errorf("invalid %s '%s'", n.name, str)
+		}
+		*n.value = v
+	}
+	if cfg.poolMin > cfg.poolMax {
+		return cfg, fmt.Errorf("ROS_POOL_MIN (%d) must not exceed ROS_POOL_MAX (%d)", cfg.poolMin, cfg.poolMax)
+	}
+	for _, t := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"ROS_POOL_IDLE_TIMEOUT", &cfg.poolIdleTimeout},
+		{"ROS_POOL_HEALTH_INTERVAL", &cfg.poolHealthInterval},
+	} {
+		str := getenv(t.name)
+		if str == "" {
+			continue
+		}
+		sec, err := strconv.Atoi(str)
+		if err != nil || sec <= 0 {
+			return cfg, fmt.Errorf("invalid %s '%s'", t.name, str)
+		}
+		*t.value = time.Second * time.Duration(sec)
+	}
+
+	cfg.protocol = getenv("ROS_PROTOCOL")
+	if cfg.protocol == "" {
+		cfg.protocol = "api"
+	}
+	if cfg.protocol != "api" && cfg.protocol != "rest" {
+		return cfg, fmt.Errorf("invalid ROS_PROTOCOL '%s'", cfg.protocol)
+	}
+
 	if cfg.port == 0 {
-		if cfg.useTLS {
+		if cfg.protocol == "rest" {
+			if cfg.useTLS {
+				cfg.port = 443
+			} else {
+				cfg.port = 80
+			}
+		} else if cfg.useTLS {
 			cfg.port = 8729
 		} else {
 			cfg.port = 8728
@@ -104,7 +217,7 @@ func loadROSConfig() (ROSConnectConfig, error) {
 func loadRAConfig() (*RAConfig, error) {
 	var cfg RAConfig
 
-	mode := os.Getenv("RA_MODE")
+	mode := getenv("RA_MODE")
 	if mode == "" {
 		mode = "ros"
 	}
@@ -117,12 +230,12 @@ func loadRAConfig() (*RAConfig, error) {
 		return &cfg, nil
 	}
 
-	extIfs := os.Getenv("RA_EXTERNAL_INTERFACES")
+	extIfs := getenv("RA_EXTERNAL_INTERFACES")
 	if extIfs == "" {
 		extIfs = "eth0"
 	}
 	cfg.extIfs = strings.Split(extIfs, ",")
-	timeoutStr := os.Getenv("RA_TIMEOUT")
+	timeoutStr := getenv("RA_TIMEOUT")
 	if timeoutStr == "" {
 		timeoutStr = "5000"
 	}
@@ -131,10 +244,11 @@ func loadRAConfig() (*RAConfig, error) {
 		return nil, fmt.Errorf("invalid RA_TIMEOUT: %s", err)
 	}
 	cfg.timeout = time.Millisecond * time.Duration(timeout)
+	cfg.stateFile = getenv("RA_STATE_FILE")
 
 	if mode == "ros" {
-		cfg.rosExtIf = os.Getenv("RA_ROS_EXTERNAL_INTERFACE")
-		extIpStrs := strings.Split(os.Getenv("RA_ROS_EXTERNAL_IPS"), ",")
+		cfg.rosExtIf = getenv("RA_ROS_EXTERNAL_INTERFACE")
+		extIpStrs := strings.Split(getenv("RA_ROS_EXTERNAL_IPS"), ",")
 		for _, eipstr := range extIpStrs {
 			if eipstr == "" {
 				continue
@@ -145,7 +259,7 @@ func loadRAConfig() (*RAConfig, error) {
 			}
 			cfg.rosExtIPs = append(cfg.rosExtIPs, eip)
 		}
-		intIpStrs := strings.Split(os.Getenv("RA_ROS_INTERNAL_IPS"), ",")
+		intIpStrs := strings.Split(getenv("RA_ROS_INTERNAL_IPS"), ",")
 		for _, iipstr := range intIpStrs {
 			if iipstr == "" {
 				continue
@@ -156,7 +270,15 @@ func loadRAConfig() (*RAConfig, error) {
 			}
 			cfg.rosIntIPs = append(cfg.rosIntIPs, iip)
 		}
-		poolStr := os.Getenv("RA_ROS_POOLS")
+		cfg.stablePrivacySecret = getenv("RA_ROS_STABLE_PRIVACY_SECRET")
+		if cfg.stablePrivacySecret == "" {
+			for _, a := range append(append([]ROSIPAssign{}, cfg.rosExtIPs...), cfg.rosIntIPs...) {
+				if a.stablePrivacy {
+					return nil, fmt.Errorf("you must specify RA_ROS_STABLE_PRIVACY_SECRET to use stable-privacy (%s@%s)", a.ip, a.ifname)
+				}
+			}
+		}
+		poolStr := getenv("RA_ROS_POOLS")
 		if poolStr == "" {
 			poolStr = "ra-prefix@fletsv6-pool/64"
 		}
@@ -173,6 +295,40 @@ func loadRAConfig() (*RAConfig, error) {
 				cfg.rosPools = append(cfg.rosPools, pool)
 			}
 		}
+		listStrs := strings.Split(getenv("RA_ROS_ADDRESS_LISTS"), ",")
+		for _, liststr := range listStrs {
+			if liststr == "" {
+				continue
+			}
+			al, err := ParseROSAddressListAssign(liststr)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ROS address list: %s", err)
+			}
+			cfg.rosLists = append(cfg.rosLists, al)
+		}
+		cfg.rosGC = getenv("RA_ROS_GC")
+		if cfg.rosGC == "" {
+			cfg.rosGC = "dry-run"
+		}
+		if cfg.rosGC != "off" && cfg.rosGC != "dry-run" && cfg.rosGC != "on" {
+			return nil, fmt.Errorf("invalid RA_ROS_GC '%s'", cfg.rosGC)
+		}
+		intervalStr := getenv("RA_ROS_RECONCILE_INTERVAL")
+		if intervalStr == "" {
+			intervalStr = "300"
+		}
+		interval, err := strconv.Atoi(intervalStr)
+		if err != nil || interval < 0 {
+			return nil, fmt.Errorf("RA_ROS_RECONCILE_INTERVAL is not a valid integer")
+		}
+		cfg.reconcileInterval = time.Second * time.Duration(interval)
+		cfg.cleanupOnExit = getenv("CLEANUP_ON_EXIT")
+		if cfg.cleanupOnExit == "" {
+			cfg.cleanupOnExit = "off"
+		}
+		if cfg.cleanupOnExit != "off" && cfg.cleanupOnExit != "remove" && cfg.cleanupOnExit != "disable" {
+			return nil, fmt.Errorf("invalid CLEANUP_ON_EXIT '%s'", cfg.cleanupOnExit)
+		}
 	}
 
 	return &cfg, nil
@@ -183,7 +339,7 @@ func loadNDConfig(racfg *RAConfig) (*NDConfig, bool, error) {
 	cfg := &NDConfig{}
 	needROS := false
 
-	cfg.mode = os.Getenv("NDP_MODE")
+	cfg.mode = getenv("NDP_MODE")
 	if cfg.mode == "" {
 		cfg.mode = "proxy-ros"
 	}
@@ -202,7 +358,7 @@ func loadNDConfig(racfg *RAConfig) (*NDConfig, bool, error) {
 		needROS = true
 	}
 
-	prefixes := os.Getenv("NDP_PREFIXES")
+	prefixes := getenv("NDP_PREFIXES")
 	if prefixes == "" {
 		prefixes = "ra-prefix"
 	}
@@ -217,7 +373,7 @@ func loadNDConfig(racfg *RAConfig) (*NDConfig, bool, error) {
 		cfg.prefixes = append(cfg.prefixes, fip)
 	}
 
-	excludeIps := os.Getenv("NDP_EXCLUDE_IPS")
+	excludeIps := getenv("NDP_EXCLUDE_IPS")
 	if excludeIps == "" {
 		excludeIps = "ra-externalips"
 	}
@@ -241,21 +397,21 @@ func loadNDConfig(racfg *RAConfig) (*NDConfig, bool, error) {
 		}
 	}
 
-	extIfs := os.Getenv("NDP_EXTERNAL_INTERFACES")
+	extIfs := getenv("NDP_EXTERNAL_INTERFACES")
 	if extIfs == "" {
 		extIfs = "eth0"
 	}
 	cfg.extIfs = strings.Split(extIfs, ",")
 
 	if cfg.mode == "proxy" {
-		intIfs := os.Getenv("NDP_INTERNAL_INTERFACES")
+		intIfs := getenv("NDP_INTERNAL_INTERFACES")
 		if intIfs == "" {
 			return nil, false, fmt.Errorf("You must specify at least one interface in NDP_INTERNAL_INTERFACES to use NDP_MODE=proxy")
 		}
 		cfg.intIfs = strings.Split(intIfs, ",")
 	}
 
-	timeoutStr := os.Getenv("NDP_TIMEOUT")
+	timeoutStr := getenv("NDP_TIMEOUT")
 	if timeoutStr == "" {
 		timeoutStr = "1000"
 	}
@@ -268,7 +424,17 @@ func loadNDConfig(racfg *RAConfig) (*NDConfig, bool, error) {
 	}
 	cfg.timeoutMs = timeoutMs
 
-	advMACs := os.Getenv("NDP_ADVERTISE_MACS")
+	cacheTTLStr := getenv("NDP_CACHE_TTL")
+	if cacheTTLStr == "" {
+		cacheTTLStr = "0"
+	}
+	cacheTTL, err := strconv.Atoi(cacheTTLStr)
+	if err != nil || cacheTTL < 0 {
+		return nil, false, fmt.Errorf("NDP_CACHE_TTL is not a valid integer")
+	}
+	cfg.cacheTTL = time.Second * time.Duration(cacheTTL)
+
+	advMACs := getenv("NDP_ADVERTISE_MACS")
 	if advMACs == "" {
 		advMACs = "@@external"
 	}
@@ -297,6 +463,357 @@ func loadNDConfig(racfg *RAConfig) (*NDConfig, bool, error) {
 	return cfg, needROS, nil
 }
 
+func loadHookConfig() (*HookConfig, error) {
+	cfg := &HookConfig{}
+
+	for _, path := range strings.Split(getenv("HOOK_EXEC"), ",") {
+		if path == "" {
+			continue
+		}
+		cfg.execs = append(cfg.execs, path)
+	}
+	for _, url := range strings.Split(getenv("HOOK_WEBHOOKS"), ",") {
+		if url == "" {
+			continue
+		}
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, fmt.Errorf("Invalid URL in HOOK_WEBHOOKS: %s", url)
+		}
+		cfg.webhooks = append(cfg.webhooks, url)
+	}
+
+	timeoutStr := getenv("HOOK_TIMEOUT")
+	if timeoutStr == "" {
+		timeoutStr = "10000"
+	}
+	timeout, err := strconv.Atoi(timeoutStr)
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("HOOK_TIMEOUT is not a valid positive integer")
+	}
+	cfg.timeout = time.Millisecond * time.Duration(timeout)
+
+	retriesStr := getenv("HOOK_RETRIES")
+	if retriesStr == "" {
+		retriesStr = "3"
+	}
+	cfg.retries, err = strconv.Atoi(retriesStr)
+	if err != nil || cfg.retries < 0 {
+		return nil, fmt.Errorf("HOOK_RETRIES is not a valid integer")
+	}
+
+	return cfg, nil
+}
+
+func loadDDNSConfig(racfg *RAConfig) (*DDNSConfig, error) {
+	cfg := &DDNSConfig{}
+
+	cfg.server = getenv("DDNS_SERVER")
+	if cfg.server == "" {
+		return cfg, nil
+	}
+	if _, _, err := net.SplitHostPort(cfg.server); err != nil {
+		cfg.server = net.JoinHostPort(cfg.server, "53")
+	}
+	if racfg.mode == "off" {
+		return nil, fmt.Errorf("You cannot use DDNS_SERVER while you set RA_MODE=off")
+	}
+
+	cfg.zone = getenv("DDNS_ZONE")
+	if cfg.zone == "" {
+		return nil, fmt.Errorf("you must specify the zone to update as DDNS_ZONE")
+	}
+	cfg.zone = dns.Fqdn(cfg.zone)
+
+	for _, recstr := range strings.Split(getenv("DDNS_RECORDS"), ",") {
+		if recstr == "" {
+			continue
+		}
+		rec, err := ParseDDNSRecord(recstr, cfg.zone)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid DDNS record: %s", err)
+		}
+		cfg.records = append(cfg.records, rec)
+	}
+	if len(cfg.records) == 0 {
+		return nil, fmt.Errorf("DDNS_RECORDS must have at least 1 record")
+	}
+
+	ttlStr := getenv("DDNS_TTL")
+	if ttlStr == "" {
+		ttlStr = "300"
+	}
+	ttl, err := strconv.ParseUint(ttlStr, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DDNS_TTL: %s", err)
+	}
+	cfg.ttl = uint32(ttl)
+
+	timeoutStr := getenv("DDNS_TIMEOUT")
+	if timeoutStr == "" {
+		timeoutStr = "5000"
+	}
+	timeout, err := strconv.Atoi(timeoutStr)
+	if err != nil || timeout <= 0 {
+		return nil, fmt.Errorf("DDNS_TIMEOUT is not a valid positive integer")
+	}
+	cfg.timeout = time.Millisecond * time.Duration(timeout)
+
+	cfg.tsigName = getenv("DDNS_TSIG_NAME")
+	if cfg.tsigName != "" {
+		cfg.tsigName = dns.Fqdn(cfg.tsigName)
+		cfg.tsigSecret = getenv("DDNS_TSIG_SECRET")
+		if cfg.tsigSecret == "" {
+			return nil, fmt.Errorf("DDNS_TSIG_SECRET must be set when DDNS_TSIG_NAME is specified")
+		}
+		alg := getenv("DDNS_TSIG_ALGORITHM")
+		if alg == "" {
+			alg = "hmac-sha256"
+		}
+		switch dns.Fqdn(alg) {
+		case dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512:
+			cfg.tsigAlgorithm = dns.Fqdn(alg)
+		default:
+			return nil, fmt.Errorf("Unsupported DDNS_TSIG_ALGORITHM %s", alg)
+		}
+	}
+
+	return cfg, nil
+}
+
+func loadMetricsConfig() (*MetricsConfig, error) {
+	cfg := &MetricsConfig{}
+
+	cfg.listen = getenv("METRICS_LISTEN")
+	if cfg.listen != "" {
+		if _, _, err := net.SplitHostPort(cfg.listen); err != nil {
+			return nil, fmt.Errorf("invalid METRICS_LISTEN '%s': %s", cfg.listen, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+func loadAPIConfig() (*APIConfig, error) {
+	cfg := &APIConfig{}
+
+	cfg.listen = getenv("API_LISTEN")
+	if cfg.listen != "" {
+		if _, _, err := net.SplitHostPort(cfg.listen); err != nil {
+			return nil, fmt.Errorf("invalid API_LISTEN '%s': %s", cfg.listen, err)
+		}
+	}
+	cfg.token = getenv("API_TOKEN")
+	control := getenv("API_CONTROL")
+	if control != "" && control != "0" && control != "1" {
+		return nil, fmt.Errorf("invalid API_CONTROL '%s'", control)
+	}
+	cfg.control = control == "1"
+
+	return cfg, nil
+}
+
+func loadHAConfig() (*HAConfig, error) {
+	cfg := &HAConfig{}
+
+	cfg.mode = getenv("HA_MODE")
+	if cfg.mode == "" {
+		cfg.mode = "off"
+	}
+	switch cfg.mode {
+	case "off":
+		return cfg, nil
+	case "heartbeat":
+	default:
+		return nil, fmt.Errorf("invalid HA_MODE '%s'", cfg.mode)
+	}
+
+	cfg.node = getenv("HA_NODE")
+	if cfg.node == "" {
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			return nil, fmt.Errorf("you must specify HA_NODE since the hostname is unavailable")
+		}
+		cfg.node = hostname
+	}
+
+	priorityStr := getenv("HA_PRIORITY")
+	if priorityStr == "" {
+		priorityStr = "100"
+	}
+	priority, err := strconv.Atoi(priorityStr)
+	if err != nil || priority < 1 || priority > 255 {
+		return nil, fmt.Errorf("HA_PRIORITY must be an integer between 1 and 255")
+	}
+	cfg.priority = priority
+
+	cfg.listen = getenv("HA_LISTEN")
+	if cfg.listen == "" {
+		cfg.listen = ":9547"
+	}
+	if _, _, err := net.SplitHostPort(cfg.listen); err != nil {
+		return nil, fmt.Errorf("invalid HA_LISTEN '%s': %s", cfg.listen, err)
+	}
+
+	for _, peer := range strings.Split(getenv("HA_PEERS"), ",") {
+		if peer == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			return nil, fmt.Errorf("invalid peer '%s' in HA_PEERS: %s", peer, err)
+		}
+		cfg.peers = append(cfg.peers, peer)
+	}
+	if len(cfg.peers) == 0 {
+		return nil, fmt.Errorf("HA_PEERS must have at least 1 peer when HA_MODE=heartbeat")
+	}
+
+	// heartbeats decide which instance answers, so they must not be forged
+	cfg.secret = getenv("HA_SECRET")
+	if cfg.secret == "" {
+		return nil, fmt.Errorf("HA_SECRET must be specified when HA_MODE=heartbeat")
+	}
+
+	intervalStr := getenv("HA_INTERVAL")
+	if intervalStr == "" {
+		intervalStr = "1000"
+	}
+	interval, err := strconv.Atoi(intervalStr)
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("HA_INTERVAL is not a valid positive integer")
+	}
+	cfg.interval = time.Millisecond * time.Duration(interval)
+
+	deadStr := getenv("HA_DEAD_INTERVAL")
+	if deadStr == "" {
+		deadStr = strconv.Itoa(interval * 3)
+	}
+	dead, err := strconv.Atoi(deadStr)
+	if err != nil || dead <= interval {
+		return nil, fmt.Errorf("HA_DEAD_INTERVAL must be an integer greater than HA_INTERVAL")
+	}
+	cfg.deadInterval = time.Millisecond * time.Duration(dead)
+
+	preempt := getenv("HA_PREEMPT")
+	if preempt != "" && preempt != "0" && preempt != "1" {
+		return nil, fmt.Errorf("invalid HA_PREEMPT '%s'", preempt)
+	}
+	cfg.preempt = preempt == "1"
+
+	return cfg, nil
+}
+
+func loadLogConfig() (*LogConfig, error) {
+	cfg := &LogConfig{}
+
+	levels, err := logger.ParseLevels(getenv("LOG_LEVEL"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL: %s", err)
+	}
+	for name := range levels.Subsystems {
+		if !isLogSubsystem(name) {
+			return nil, fmt.Errorf("invalid LOG_LEVEL: unknown subsystem '%s' (available: %s)", name, strings.Join(logSubsystems, ", "))
+		}
+	}
+	cfg.levels = levels
+
+	cfg.format = getenv("LOG_FORMAT")
+	if cfg.format == "" {
+		cfg.format = "text"
+	}
+	if _, err := logger.NewEncoder(cfg.format); err != nil {
+		return nil, fmt.Errorf("invalid LOG_FORMAT '%s'", cfg.format)
+	}
+
+	outputs := getenv("LOG_OUTPUTS")
+	if outputs == "" {
+		outputs = "stdout"
+	}
+	for _, output := range strings.Split(outputs, ",") {
+		switch output {
+		case "":
+			continue
+		case "stdout", "file", "syslog":
+		default:
+			return nil, fmt.Errorf("invalid output '%s' in LOG_OUTPUTS", output)
+		}
+		cfg.outputs = append(cfg.outputs, output)
+	}
+
+	cfg.file = getenv("LOG_FILE")
+	if cfg.hasOutput("file") && cfg.file == "" {
+		return nil, fmt.Errorf("you must specify LOG_FILE to use the file output")
+	}
+	maxSizeStr := getenv("LOG_FILE_MAX_SIZE")
+	if maxSizeStr == "" {
+		maxSizeStr = "10"
+	}
+	maxSize, err := strconv.Atoi(maxSizeStr)
+	if err != nil || maxSize < 0 {
+		return nil, fmt.Errorf("LOG_FILE_MAX_SIZE is not a valid integer")
+	}
+	cfg.fileMaxSize = int64(maxSize) * 1024 * 1024
+	backupsStr := getenv("LOG_FILE_MAX_BACKUPS")
+	if backupsStr == "" {
+		backupsStr = "3"
+	}
+	cfg.fileMaxBackups, err = strconv.Atoi(backupsStr)
+	if err != nil || cfg.fileMaxBackups < 0 {
+		return nil, fmt.Errorf("LOG_FILE_MAX_BACKUPS is not a valid integer")
+	}
+
+	if !cfg.hasOutput("syslog") {
+		return cfg, nil
+	}
+	cfg.syslogProtocol = getenv("LOG_SYSLOG_PROTOCOL")
+	if cfg.syslogProtocol == "" {
+		cfg.syslogProtocol = "udp"
+	}
+	defaultPort := "514"
+	switch cfg.syslogProtocol {
+	case "udp", "tcp":
+	case "tls":
+		defaultPort = "6514"
+	default:
+		return nil, fmt.Errorf("invalid LOG_SYSLOG_PROTOCOL '%s'", cfg.syslogProtocol)
+	}
+	cfg.syslogServer = getenv("LOG_SYSLOG_SERVER")
+	if cfg.syslogServer == "" {
+		return nil, fmt.Errorf("you must specify LOG_SYSLOG_SERVER to use the syslog output")
+	}
+	if _, _, err := net.SplitHostPort(cfg.syslogServer); err != nil {
+		cfg.syslogServer = net.JoinHostPort(cfg.syslogServer, defaultPort)
+	}
+	cfg.syslogFormat = getenv("LOG_SYSLOG_FORMAT")
+	if cfg.syslogFormat == "" {
+		cfg.syslogFormat = "rfc5424"
+	}
+	if cfg.syslogFormat != "rfc5424" && cfg.syslogFormat != "rfc3164" {
+		return nil, fmt.Errorf("invalid LOG_SYSLOG_FORMAT '%s'", cfg.syslogFormat)
+	}
+	cfg.syslogFacility = getenv("LOG_SYSLOG_FACILITY")
+	if cfg.syslogFacility == "" {
+		cfg.syslogFacility = "daemon"
+	}
+	if _, ok := logger.SyslogFacilities[cfg.syslogFacility]; !ok {
+		return nil, fmt.Errorf("invalid LOG_SYSLOG_FACILITY '%s'", cfg.syslogFacility)
+	}
+	cfg.syslogTag = getenv("LOG_SYSLOG_TAG")
+	if cfg.syslogTag == "" {
+		cfg.syslogTag = "fletsv6-companion"
+	}
+	bufferStr := getenv("LOG_SYSLOG_BUFFER")
+	if bufferStr == "" {
+		bufferStr = "1000"
+	}
+	cfg.syslogBuffer, err = strconv.Atoi(bufferStr)
+	if err != nil || cfg.syslogBuffer <= 0 {
+		return nil, fmt.Errorf("LOG_SYSLOG_BUFFER is not a valid positive integer")
+	}
+	cfg.syslogTLSCA = getenv("LOG_SYSLOG_TLS_CA")
+
+	return cfg, nil
+}
+
 func loadConfig(cfg *Config) error {
 	prefixes, err := loadPrefixes()
 	if err != nil {
@@ -310,11 +827,26 @@ func loadConfig(cfg *Config) error {
 }
 
 // parsers
+// ra-prefix/<length>#<index> (index is decimal or 0x-prefixed hex)
+var subnetreg = regexp.MustCompile("^/([0-9]+)#(0x[0-9a-fA-F]+|[0-9]+)")
+
 func ParseFlexibleIP(ipstr string) (FlexibleIP, error) {
 	var i FlexibleIP
 	if strings.HasPrefix(ipstr, "ra-prefix") {
 		ipstr = strings.TrimPrefix(ipstr, "ra-prefix")
 		i.raPrefix = true
+		if parsed := subnetreg.FindStringSubmatch(ipstr); parsed != nil {
+			if err := parseSubnet(&i, parsed[1], parsed[2]); err != nil {
+				return i, err
+			}
+			ipstr = strings.TrimPrefix(ipstr, parsed[0])
+			if ipstr == "" {
+				i.cidr = i.subnetLength
+				return i, nil
+			}
+		} else if strings.HasPrefix(ipstr, "/") {
+			return i, fmt.Errorf("invalid subnet '%s' (e.g. ra-prefix/64#1)", ipstr)
+		}
 		if ipstr == "" {
 			i.cidr = -1
 			return i, nil
@@ -328,13 +860,44 @@ func ParseFlexibleIP(ipstr string) (FlexibleIP, error) {
 			return i, err
 		}
 		i.cidr = 128
-		return i, nil
+	} else {
+		i.ip = ip
+		i.cidr, _ = cidr.Mask.Size()
+	}
+	if i.subnetLength != 0 {
+		// the suffix is the address within the subnet
+		if i.cidr < i.subnetLength {
+			return i, fmt.Errorf("prefix length /%d is shorter than the subnet /%d", i.cidr, i.subnetLength)
+		}
+		if !i.ip.Mask(net.CIDRMask(i.subnetLength, 128)).Equal(net.IPv6zero) {
+			return i, fmt.Errorf("suffix %s overlaps the subnet /%d", i.ip, i.subnetLength)
+		}
 	}
-	i.ip = ip
-	i.cidr, _ = cidr.Mask.Size()
 	return i, nil
 }
 
+func parseSubnet(i *FlexibleIP, lengthStr string, indexStr string) error {
+	length, err := strconv.Atoi(lengthStr)
+	if err != nil || length < 1 || length > 128 {
+		return fmt.Errorf("invalid subnet length /%s", lengthStr)
+	}
+	var index uint64
+	if strings.HasPrefix(indexStr, "0x") {
+		index, err = strconv.ParseUint(indexStr[2:], 16, 64)
+	} else {
+		index, err = strconv.ParseUint(indexStr, 10, 64)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid subnet index %s", indexStr)
+	}
+	if length < 64 && index >= 1<<length {
+		return fmt.Errorf("subnet index %s is out of range for /%d", indexStr, length)
+	}
+	i.subnetLength = length
+	i.subnetIndex = index
+	return nil
+}
+
 var rosipreg = regexp.MustCompile("^([^@]+)@(@external|[^@:]+)((?::[-a-z0-9]+)*)$")
 
 func ParseROSIPAssign(config string, extif string) (ROSIPAssign, error) {
@@ -370,6 +933,19 @@ func ParseROSIPAssign(config string, extif string) (ROSIPAssign, error) {
 			a.options.Eui64 = true
 		} else if opt == "advertise" {
 			a.options.Advertise = true
+		} else if opt == "stable-privacy" {
+			a.stablePrivacy = true
+		}
+	}
+	if a.stablePrivacy {
+		if a.options.Eui64 {
+			return a, fmt.Errorf("ip assignment '%s' cannot have both eui-64 and stable-privacy", config)
+		}
+		if fip.cidr == 128 {
+			return a, fmt.Errorf("ip assignment '%s' has no interface identifier bits for stable-privacy (specify a prefix length like ra-prefix::/64)", config)
+		}
+		if fip.ip != nil && fip.cidr > 0 && !fip.ip.Equal(fip.ip.Mask(net.CIDRMask(fip.cidr, 128))) {
+			return a, fmt.Errorf("ip assignment '%s' has a suffix in the interface identifier which is replaced by stable-privacy", config)
 		}
 	}
 
@@ -379,6 +955,52 @@ func ParseROSIPAssign(config string, extif string) (ROSIPAssign, error) {
 	return a, nil
 }
 
+func ParseROSAddressListAssign(config string) (ROSAddressListAssign, error) {
+	var a ROSAddressListAssign
+
+	parts := strings.Split(config, "@")
+	if len(parts) != 2 || parts[1] == "" {
+		return a, fmt.Errorf("address list assignment '%s' has invalid list specifier", config)
+	}
+
+	fip, err := ParseFlexibleIP(parts[0])
+	if err != nil {
+		return a, fmt.Errorf("address list assignment '%s' has invalid ip specifier: %s", config, err)
+	}
+
+	a.ip = fip
+	a.list = parts[1]
+
+	return a, nil
+}
+
+func ParseDDNSRecord(config string, zone string) (DDNSRecord, error) {
+	var r DDNSRecord
+
+	parts := strings.Split(config, "@")
+	if len(parts) != 2 || parts[1] == "" {
+		return r, fmt.Errorf("record '%s' has invalid format", config)
+	}
+
+	fip, err := ParseFlexibleIP(parts[0])
+	if err != nil {
+		return r, fmt.Errorf("record '%s' has invalid ip specifier: %s", config, err)
+	}
+
+	fqdn := dns.Fqdn(parts[1])
+	if _, ok := dns.IsDomainName(fqdn); !ok {
+		return r, fmt.Errorf("record '%s' has invalid domain name", config)
+	}
+	if !dns.IsSubDomain(zone, fqdn) {
+		return r, fmt.Errorf("record '%s' is not in zone %s", config, zone)
+	}
+
+	r.ip = fip
+	r.fqdn = fqdn
+
+	return r, nil
+}
+
 func ParseROSPoolAssign(config string) (ROSPoolAssign, error) {
 	var a ROSPoolAssign
 
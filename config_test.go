@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestLoadROSConfig(t *testing.T) {
+	t.Setenv("ROS_HOST", "192.168.88.1")
+
+	// defaults
+	cfg, err := loadROSConfig()
+	if err != nil {
+		t.Fatalf("loadROSConfig failed: %s", err)
+	}
+	if cfg.port != 8728 || cfg.username != "admin" || cfg.dryRun != "off" || cfg.protocol != "api" {
+		t.Fatalf("unexpected defaults: port=%d user=%s dry-run=%s protocol=%s", cfg.port, cfg.username, cfg.dryRun, cfg.protocol)
+	}
+
+	// ROS_PORT is used when valid and rejected otherwise
+	t.Setenv("ROS_PORT", "18728")
+	t.Setenv("ROS_USER", "fletsv6")
+	if cfg, err := loadROSConfig(); err != nil || cfg.port != 18728 || cfg.username != "fletsv6" {
+		t.Fatalf("loadROSConfig returned port=%d user=%s, %v", cfg.port, cfg.username, err)
+	}
+	for _, bad := range []string{"port", "0", "65536"} {
+		t.Setenv("ROS_PORT", bad)
+		if _, err := loadROSConfig(); err == nil {
+			t.Fatalf("ROS_PORT '%s' was accepted", bad)
+		}
+	}
+}
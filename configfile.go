@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/logger"
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig is the schema of the YAML file given by CONFIG_FILE.
+// Every field corresponds to an environment variable (noted in the comment)
+// which overrides the value in the file when set.
+type FileConfig struct {
+	Log     FileLogConfig     `yaml:"log"`
+	RA      FileRAConfig      `yaml:"ra"`
+	ND      FileNDConfig      `yaml:"nd"`
+	ROS     FileROSConfig     `yaml:"ros"`
+	Hook    FileHookConfig    `yaml:"hook"`
+	DDNS    FileDDNSConfig    `yaml:"ddns"`
+	Metrics FileMetricsConfig `yaml:"metrics"`
+	API     FileAPIConfig     `yaml:"api"`
+	HA      FileHAConfig      `yaml:"ha"`
+}
+
+type FileLogConfig struct {
+	Level          string            `yaml:"level"`            // LOG_LEVEL (default level)
+	Subsystems     map[string]string `yaml:"subsystems"`       // LOG_LEVEL (e.g. nd: TRACE)
+	Format         string            `yaml:"format"`           // LOG_FORMAT
+	Outputs        []string          `yaml:"outputs"`          // LOG_OUTPUTS
+	File           string            `yaml:"file"`             // LOG_FILE
+	FileMaxSize    *int              `yaml:"file_max_size"`    // LOG_FILE_MAX_SIZE (MB)
+	FileMaxBackups *int              `yaml:"file_max_backups"` // LOG_FILE_MAX_BACKUPS
+	Syslog         FileSyslogConfig  `yaml:"syslog"`
+}
+
+type FileSyslogConfig struct {
+	Server   string `yaml:"server"`   // LOG_SYSLOG_SERVER
+	Protocol string `yaml:"protocol"` // LOG_SYSLOG_PROTOCOL
+	Format   string `yaml:"format"`   // LOG_SYSLOG_FORMAT
+	Facility string `yaml:"facility"` // LOG_SYSLOG_FACILITY
+	Tag      string `yaml:"tag"`      // LOG_SYSLOG_TAG
+	Buffer   *int   `yaml:"buffer"`   // LOG_SYSLOG_BUFFER
+	TLSCA    string `yaml:"tls_ca"`   // LOG_SYSLOG_TLS_CA
+}
+
+type FileRAConfig struct {
+	Mode               string          `yaml:"mode"`                // RA_MODE
+	ExternalInterfaces []string        `yaml:"external_interfaces"` // RA_EXTERNAL_INTERFACES
+	Timeout            *int            `yaml:"timeout"`             // RA_TIMEOUT (ms)
+	ROS                FileRAROSConfig `yaml:"ros"`
+	CleanupOnExit      string          `yaml:"cleanup_on_exit"` // CLEANUP_ON_EXIT
+	StateFile          string          `yaml:"state_file"`      // RA_STATE_FILE
+}
+
+type FileRAROSConfig struct {
+	ExternalInterface   string                  `yaml:"external_interface"`    // RA_ROS_EXTERNAL_INTERFACE
+	ExternalIPs         []FileIPAssign          `yaml:"external_ips"`          // RA_ROS_EXTERNAL_IPS
+	InternalIPs         []FileIPAssign          `yaml:"internal_ips"`          // RA_ROS_INTERNAL_IPS
+	Pools               []FilePoolAssign        `yaml:"pools"`                 // RA_ROS_POOLS
+	AddressLists        []FileAddressListAssign `yaml:"address_lists"`         // RA_ROS_ADDRESS_LISTS
+	GC                  string                  `yaml:"gc"`                    // RA_ROS_GC
+	ReconcileInterval   *int                    `yaml:"reconcile_interval"`    // RA_ROS_RECONCILE_INTERVAL (s)
+	StablePrivacySecret string                  `yaml:"stable_privacy_secret"` // RA_ROS_STABLE_PRIVACY_SECRET
+}
+
+type FileIPAssign struct {
+	Address   string   `yaml:"address"`   // e.g. ra-prefix::1/128
+	Interface string   `yaml:"interface"` // RouterOS interface or @external
+	Options   []string `yaml:"options"`   // eui-64, advertise, stable-privacy
+}
+
+type FilePoolAssign struct {
+	Prefix       string `yaml:"prefix"`
+	Name         string `yaml:"name"`
+	PrefixLength int    `yaml:"prefix_length"`
+}
+
+type FileAddressListAssign struct {
+	Address string `yaml:"address"`
+	List    string `yaml:"list"`
+}
+
+type FileNDConfig struct {
+	Mode               string   `yaml:"mode"`                // NDP_MODE
+	Prefixes           []string `yaml:"prefixes"`            // NDP_PREFIXES
+	ExcludeIPs         []string `yaml:"exclude_ips"`         // NDP_EXCLUDE_IPS
+	ExternalInterfaces []string `yaml:"external_interfaces"` // NDP_EXTERNAL_INTERFACES
+	InternalInterfaces []string `yaml:"internal_interfaces"` // NDP_INTERNAL_INTERFACES
+	Timeout            *int     `yaml:"timeout"`             // NDP_TIMEOUT (ms)
+	AdvertiseMACs      []string `yaml:"advertise_macs"`      // NDP_ADVERTISE_MACS
+	CacheTTL           *int     `yaml:"cache_ttl"`           // NDP_CACHE_TTL (s)
+}
+
+type FileROSConfig struct {
+	Host        string            `yaml:"host"`         // ROS_HOST
+	Port        *int              `yaml:"port"`         // ROS_PORT
+	User        string            `yaml:"user"`         // ROS_USER
+	Password    string            `yaml:"password"`     // ROS_PASSWORD
+	UseTLS      *bool             `yaml:"use_tls"`      // ROS_USETLS
+	Protocol    string            `yaml:"protocol"`     // ROS_PROTOCOL
+	DryRun      string            `yaml:"dry_run"`      // ROS_DRY_RUN
+	TLS         FileROSTLSConfig  `yaml:"tls"`          // ROS_TLS_*
+	Timeouts    FileROSTimeouts   `yaml:"timeouts"`     // ROS_*_TIMEOUT (ms)
+	ReadRetries *int              `yaml:"read_retries"` // ROS_READ_RETRIES
+	Pool        FileROSPoolConfig `yaml:"pool"`         // ROS_POOL_*
+}
+
+type FileROSTLSConfig struct {
+	CA          string `yaml:"ca"`
+	Fingerprint string `yaml:"fingerprint"`
+	ServerName  string `yaml:"server_name"`
+	Cert        string `yaml:"cert"`
+	Key         string `yaml:"key"`
+}
+
+type FileROSTimeouts struct {
+	Read  *int `yaml:"read"`
+	Write *int `yaml:"write"`
+	Ping  *int `yaml:"ping"`
+}
+
+type FileROSPoolConfig struct {
+	Min            *int `yaml:"min"`
+	Max            *int `yaml:"max"`
+	IdleTimeout    *int `yaml:"idle_timeout"`    // s
+	HealthInterval *int `yaml:"health_interval"` // s
+}
+
+type FileHookConfig struct {
+	Exec     []string `yaml:"exec"`     // HOOK_EXEC
+	Webhooks []string `yaml:"webhooks"` // HOOK_WEBHOOKS
+	Timeout  *int     `yaml:"timeout"`  // HOOK_TIMEOUT (ms)
+	Retries  *int     `yaml:"retries"`  // HOOK_RETRIES
+}
+
+type FileDDNSConfig struct {
+	Server  string           `yaml:"server"`  // DDNS_SERVER
+	Zone    string           `yaml:"zone"`    // DDNS_ZONE
+	Records []FileDDNSRecord `yaml:"records"` // DDNS_RECORDS
+	TTL     *int             `yaml:"ttl"`     // DDNS_TTL
+	Timeout *int             `yaml:"timeout"` // DDNS_TIMEOUT (ms)
+	TSIG    FileTSIGConfig   `yaml:"tsig"`    // DDNS_TSIG_*
+}
+
+type FileDDNSRecord struct {
+	Address string `yaml:"address"`
+	Name    string `yaml:"name"`
+}
+
+type FileTSIGConfig struct {
+	Name      string `yaml:"name"`
+	Secret    string `yaml:"secret"`
+	Algorithm string `yaml:"algorithm"`
+}
+
+type FileMetricsConfig struct {
+	Listen string `yaml:"listen"` // METRICS_LISTEN
+}
+
+type FileAPIConfig struct {
+	Listen  string `yaml:"listen"`  // API_LISTEN
+	Token   string `yaml:"token"`   // API_TOKEN
+	Control *bool  `yaml:"control"` // API_CONTROL
+}
+
+type FileHAConfig struct {
+	Mode         string   `yaml:"mode"`          // HA_MODE
+	Node         string   `yaml:"node"`          // HA_NODE
+	Priority     *int     `yaml:"priority"`      // HA_PRIORITY
+	Listen       string   `yaml:"listen"`        // HA_LISTEN
+	Peers        []string `yaml:"peers"`         // HA_PEERS
+	Secret       string   `yaml:"secret"`        // HA_SECRET
+	Interval     *int     `yaml:"interval"`      // HA_INTERVAL (ms)
+	DeadInterval *int     `yaml:"dead_interval"` // HA_DEAD_INTERVAL (ms)
+	Preempt      *bool    `yaml:"preempt"`       // HA_PREEMPT
+}
+
+// ConfigError is a validation error of a field in the config file
+type ConfigError struct {
+	Path    string
+	Message string
+}
+
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = fmt.Sprintf("  %s: %s", e.Path, e.Message)
+	}
+	return fmt.Sprintf("invalid config file:\n%s", strings.Join(lines, "\n"))
+}
+
+// values read from CONFIG_FILE, keyed by environment variable name
+var fileEnv = map[string]string{}
+
+// getenv returns the environment variable or the value from CONFIG_FILE
+func getenv(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fileEnv[name]
+}
+
+// loadConfigFile reads CONFIG_FILE (if set) and makes its values available
+// via getenv. It also applies the LOG_* settings.
+func loadConfigFile() error {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return configureLogging()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read CONFIG_FILE: %s", err)
+	}
+	fc, err := ParseFileConfig(data)
+	if err != nil {
+		return err
+	}
+	fileEnv = fc.env()
+	if err := configureLogging(); err != nil {
+		return err
+	}
+	llog.Debug("Loaded %d setting(s) from %s", len(fileEnv), path)
+	return nil
+}
+
+// ParseFileConfig decodes and validates a config file
+func ParseFileConfig(data []byte) (*FileConfig, error) {
+	fc := &FileConfig{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(fc); err != nil && err != io.EOF {
+		var terr *yaml.TypeError
+		if errors.As(err, &terr) {
+			errs := ConfigErrors{}
+			for _, msg := range terr.Errors {
+				errs = append(errs, ConfigError{Path: "yaml", Message: msg})
+			}
+			return nil, errs
+		}
+		return nil, fmt.Errorf("failed to parse config file: %s", err)
+	}
+	if errs := fc.validate(); len(errs) > 0 {
+		return nil, errs
+	}
+	return fc, nil
+}
+
+func (fc *FileConfig) validate() ConfigErrors {
+	var errs ConfigErrors
+	fail := func(path string, format string, args ...interface{}) {
+		errs = append(errs, ConfigError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	oneOf := func(path string, value string, allowed ...string) {
+		if value == "" {
+			return
+		}
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		fail(path, "must be one of %s (got '%s')", strings.Join(allowed, ", "), value)
+	}
+	atLeast := func(path string, value *int, min int) {
+		if value != nil && *value < min {
+			fail(path, "must be %d or greater (got %d)", min, *value)
+		}
+	}
+
+	oneOf("log.level", strings.ToUpper(fc.Log.Level), "ERROR", "WARNING", "INFO", "DEBUG", "TRACE")
+	if fc.Log.Level != strings.ToUpper(fc.Log.Level) && fc.Log.Level != strings.ToLower(fc.Log.Level) {
+		fail("log.level", "must be either uppercase or lowercase (got '%s')", fc.Log.Level)
+	}
+	for name, level := range fc.Log.Subsystems {
+		path := "log.subsystems." + name
+		if !isLogSubsystem(name) {
+			fail(path, "unknown subsystem (available: %s)", strings.Join(logSubsystems, ", "))
+		}
+		oneOf(path, strings.ToUpper(level), "ERROR", "WARNING", "INFO", "DEBUG", "TRACE")
+	}
+	oneOf("log.format", fc.Log.Format, "text", "json", "logfmt")
+	for i, o := range fc.Log.Outputs {
+		oneOf(fmt.Sprintf("log.outputs[%d]", i), o, "stdout", "file", "syslog")
+		if o == "file" && fc.Log.File == "" {
+			fail("log.file", "required by the file output")
+		}
+		if o == "syslog" && fc.Log.Syslog.Server == "" {
+			fail("log.syslog.server", "required by the syslog output")
+		}
+	}
+	oneOf("log.syslog.protocol", fc.Log.Syslog.Protocol, "udp", "tcp", "tls")
+	oneOf("log.syslog.format", fc.Log.Syslog.Format, "rfc5424", "rfc3164")
+	if f := fc.Log.Syslog.Facility; f != "" {
+		if _, ok := logger.SyslogFacilities[f]; !ok {
+			fail("log.syslog.facility", "unknown facility '%s'", f)
+		}
+	}
+	atLeast("log.syslog.buffer", fc.Log.Syslog.Buffer, 1)
+	atLeast("log.file_max_size", fc.Log.FileMaxSize, 0)
+	atLeast("log.file_max_backups", fc.Log.FileMaxBackups, 0)
+
+	// ra
+	oneOf("ra.mode", fc.RA.Mode, "ros", "off")
+	atLeast("ra.timeout", fc.RA.Timeout, 1)
+	oneOf("ra.cleanup_on_exit", fc.RA.CleanupOnExit, "off", "remove", "disable")
+	ros := fc.RA.ROS
+	// @external refers to the merged value: RA_ROS_EXTERNAL_INTERFACE
+	// overrides the file as in getenv
+	extIf := ros.ExternalInterface
+	if v := os.Getenv("RA_ROS_EXTERNAL_INTERFACE"); v != "" {
+		extIf = v
+	}
+	for i, a := range ros.ExternalIPs {
+		path := fmt.Sprintf("ra.ros.external_ips[%d]", i)
+		if _, err := ParseROSIPAssign(a.String(), extIf); err != nil {
+			fail(path, "%s", err)
+		}
+		validateIPOptions(path, a.Options, fail)
+	}
+	for i, a := range ros.InternalIPs {
+		path := fmt.Sprintf("ra.ros.internal_ips[%d]", i)
+		if _, err := ParseROSIPAssign(a.String(), extIf); err != nil {
+			fail(path, "%s", err)
+		}
+		validateIPOptions(path, a.Options, fail)
+	}
+	for i, p := range ros.Pools {
+		if _, err := ParseROSPoolAssign(p.String()); err != nil {
+			fail(fmt.Sprintf("ra.ros.pools[%d]", i), "%s", err)
+		}
+	}
+	for i, l := range ros.AddressLists {
+		if _, err := ParseROSAddressListAssign(l.String()); err != nil {
+			fail(fmt.Sprintf("ra.ros.address_lists[%d]", i), "%s", err)
+		}
+	}
+	oneOf("ra.ros.gc", ros.GC, "off", "dry-run", "on")
+	atLeast("ra.ros.reconcile_interval", ros.ReconcileInterval, 0)
+
+	// nd
+	oneOf("nd.mode", fc.ND.Mode, "off", "static", "proxy", "proxy-ros", "proxy-ros:strict")
+	for i, p := range fc.ND.Prefixes {
+		if _, err := ParseFlexibleIP(p); err != nil {
+			fail(fmt.Sprintf("nd.prefixes[%d]", i), "invalid prefix '%s'", p)
+		}
+	}
+	for i, ip := range fc.ND.ExcludeIPs {
+		if ip == "ra-externalips" || ip == "ra-internalips" || ip == "none" {
+			continue
+		}
+		if _, err := ParseFlexibleIP(ip); err != nil {
+			fail(fmt.Sprintf("nd.exclude_ips[%d]", i), "invalid address '%s'", ip)
+		}
+	}
+	if fc.ND.Timeout != nil && (fc.ND.Mode == "" || fc.ND.Mode == "proxy-ros") &&
+		(*fc.ND.Timeout < 10 || *fc.ND.Timeout > 5000) {
+		fail("nd.timeout", "must be between 10 and 5000 in proxy-ros mode (got %d)", *fc.ND.Timeout)
+	}
+	for i, mac := range fc.ND.AdvertiseMACs {
+		if strings.HasPrefix(mac, "@") {
+			continue
+		}
+		if _, err := net.ParseMAC(mac); err != nil {
+			fail(fmt.Sprintf("nd.advertise_macs[%d]", i), "invalid MAC address '%s'", mac)
+		}
+	}
+
+	atLeast("nd.cache_ttl", fc.ND.CacheTTL, 0)
+
+	// ros
+	if p := fc.ROS.Port; p != nil && (*p <= 0 || *p > 65535) {
+		fail("ros.port", "must be between 1 and 65535 (got %d)", *p)
+	}
+	oneOf("ros.protocol", fc.ROS.Protocol, "api", "rest")
+	oneOf("ros.dry_run", fc.ROS.DryRun, "off", "exit", "observe")
+	if (fc.ROS.TLS.Cert == "") != (fc.ROS.TLS.Key == "") {
+		fail("ros.tls", "cert and key must be specified together")
+	}
+	atLeast("ros.timeouts.read", fc.ROS.Timeouts.Read, 1)
+	atLeast("ros.timeouts.write", fc.ROS.Timeouts.Write, 1)
+	atLeast("ros.timeouts.ping", fc.ROS.Timeouts.Ping, 1)
+	atLeast("ros.read_retries", fc.ROS.ReadRetries, 0)
+	atLeast("ros.pool.min", fc.ROS.Pool.Min, 0)
+	atLeast("ros.pool.max", fc.ROS.Pool.Max, 1)
+	if fc.ROS.Pool.Min != nil && fc.ROS.Pool.Max != nil && *fc.ROS.Pool.Min > *fc.ROS.Pool.Max {
+		fail("ros.pool.min", "must not exceed ros.pool.max")
+	}
+	atLeast("ros.pool.idle_timeout", fc.ROS.Pool.IdleTimeout, 1)
+	atLeast("ros.pool.health_interval", fc.ROS.Pool.HealthInterval, 1)
+
+	// hook
+	for i, url := range fc.Hook.Webhooks {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			fail(fmt.Sprintf("hook.webhooks[%d]", i), "invalid URL '%s'", url)
+		}
+	}
+	atLeast("hook.timeout", fc.Hook.Timeout, 1)
+	atLeast("hook.retries", fc.Hook.Retries, 0)
+
+	// ddns
+	if fc.DDNS.Server != "" {
+		if fc.DDNS.Zone == "" {
+			fail("ddns.zone", "is required when ddns.server is set")
+		}
+		if len(fc.DDNS.Records) == 0 {
+			fail("ddns.records", "must have at least 1 record")
+		}
+	}
+	for i, r := range fc.DDNS.Records {
+		if _, err := ParseDDNSRecord(r.String(), dns.Fqdn(fc.DDNS.Zone)); err != nil {
+			fail(fmt.Sprintf("ddns.records[%d]", i), "%s", err)
+		}
+	}
+	atLeast("ddns.ttl", fc.DDNS.TTL, 0)
+	atLeast("ddns.timeout", fc.DDNS.Timeout, 1)
+	if fc.DDNS.TSIG.Name != "" && fc.DDNS.TSIG.Secret == "" {
+		fail("ddns.tsig.secret", "is required when ddns.tsig.name is set")
+	}
+
+	// metrics
+	if fc.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(fc.Metrics.Listen); err != nil {
+			fail("metrics.listen", "%s", err)
+		}
+	}
+
+	// api
+	if fc.API.Listen != "" {
+		if _, _, err := net.SplitHostPort(fc.API.Listen); err != nil {
+			fail("api.listen", "%s", err)
+		}
+	}
+
+	// ha
+	oneOf("ha.mode", fc.HA.Mode, "off", "heartbeat")
+	if fc.HA.Mode == "heartbeat" && len(fc.HA.Peers) == 0 {
+		fail("ha.peers", "must have at least 1 peer when ha.mode is heartbeat")
+	}
+	if p := fc.HA.Priority; p != nil && (*p < 1 || *p > 255) {
+		fail("ha.priority", "must be between 1 and 255 (got %d)", *p)
+	}
+	if fc.HA.Listen != "" {
+		if _, _, err := net.SplitHostPort(fc.HA.Listen); err != nil {
+			fail("ha.listen", "%s", err)
+		}
+	}
+	for i, peer := range fc.HA.Peers {
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			fail(fmt.Sprintf("ha.peers[%d]", i), "%s", err)
+		}
+	}
+	atLeast("ha.interval", fc.HA.Interval, 1)
+	atLeast("ha.dead_interval", fc.HA.DeadInterval, 1)
+
+	return errs
+}
+
+func validateIPOptions(path string, options []string, fail func(string, string, ...interface{})) {
+	for i, opt := range options {
+		if opt != "eui-64" && opt != "advertise" && opt != "stable-privacy" {
+			fail(fmt.Sprintf("%s.options[%d]", path, i), "must be one of eui-64, advertise, stable-privacy (got '%s')", opt)
+		}
+	}
+}
+
+// String returns the RA_ROS_*_IPS notation
+func (a FileIPAssign) String() string {
+	s := fmt.Sprintf("%s@%s", a.Address, a.Interface)
+	for _, opt := range a.Options {
+		s += ":" + opt
+	}
+	return s
+}
+
+// String returns the RA_ROS_POOLS notation
+func (p FilePoolAssign) String() string {
+	return fmt.Sprintf("%s@%s/%d", p.Prefix, p.Name, p.PrefixLength)
+}
+
+// String returns the RA_ROS_ADDRESS_LISTS notation
+func (l FileAddressListAssign) String() string {
+	return fmt.Sprintf("%s@%s", l.Address, l.List)
+}
+
+// String returns the DDNS_RECORDS notation
+func (r FileDDNSRecord) String() string {
+	return fmt.Sprintf("%s@%s", r.Address, r.Name)
+}
+
+// env converts the file into environment variables
+func (fc *FileConfig) env() map[string]string {
+	env := make(map[string]string)
+	str := func(name string, value string) {
+		if value != "" {
+			env[name] = value
+		}
+	}
+	list := func(name string, values []string) {
+		str(name, strings.Join(values, ","))
+	}
+	num := func(name string, value *int) {
+		if value != nil {
+			str(name, strconv.Itoa(*value))
+		}
+	}
+
+	levels := []string{}
+	if fc.Log.Level != "" {
+		levels = append(levels, fc.Log.Level)
+	}
+	var subsystems []string
+	for name := range fc.Log.Subsystems {
+		subsystems = append(subsystems, name)
+	}
+	sort.Strings(subsystems)
+	for _, name := range subsystems {
+		levels = append(levels, name+"="+fc.Log.Subsystems[name])
+	}
+	list("LOG_LEVEL", levels)
+	str("LOG_FORMAT", fc.Log.Format)
+	list("LOG_OUTPUTS", fc.Log.Outputs)
+	str("LOG_FILE", fc.Log.File)
+	num("LOG_FILE_MAX_SIZE", fc.Log.FileMaxSize)
+	num("LOG_FILE_MAX_BACKUPS", fc.Log.FileMaxBackups)
+	str("LOG_SYSLOG_SERVER", fc.Log.Syslog.Server)
+	str("LOG_SYSLOG_PROTOCOL", fc.Log.Syslog.Protocol)
+	str("LOG_SYSLOG_FORMAT", fc.Log.Syslog.Format)
+	str("LOG_SYSLOG_FACILITY", fc.Log.Syslog.Facility)
+	str("LOG_SYSLOG_TAG", fc.Log.Syslog.Tag)
+	num("LOG_SYSLOG_BUFFER", fc.Log.Syslog.Buffer)
+	str("LOG_SYSLOG_TLS_CA", fc.Log.Syslog.TLSCA)
+
+	str("RA_MODE", fc.RA.Mode)
+	list("RA_EXTERNAL_INTERFACES", fc.RA.ExternalInterfaces)
+	num("RA_TIMEOUT", fc.RA.Timeout)
+	str("CLEANUP_ON_EXIT", fc.RA.CleanupOnExit)
+	str("RA_STATE_FILE", fc.RA.StateFile)
+	ros := fc.RA.ROS
+	str("RA_ROS_EXTERNAL_INTERFACE", ros.ExternalInterface)
+	var ips []string
+	for _, a := range ros.ExternalIPs {
+		ips = append(ips, a.String())
+	}
+	list("RA_ROS_EXTERNAL_IPS", ips)
+	ips = nil
+	for _, a := range ros.InternalIPs {
+		ips = append(ips, a.String())
+	}
+	list("RA_ROS_INTERNAL_IPS", ips)
+	if ros.Pools != nil {
+		var pools []string
+		for _, p := range ros.Pools {
+			pools = append(pools, p.String())
+		}
+		if len(pools) == 0 {
+			// "pools: []" disables the default pool
+			pools = []string{"none"}
+		}
+		list("RA_ROS_POOLS", pools)
+	}
+	var lists []string
+	for _, l := range ros.AddressLists {
+		lists = append(lists, l.String())
+	}
+	list("RA_ROS_ADDRESS_LISTS", lists)
+	str("RA_ROS_GC", ros.GC)
+	num("RA_ROS_RECONCILE_INTERVAL", ros.ReconcileInterval)
+	str("RA_ROS_STABLE_PRIVACY_SECRET", ros.StablePrivacySecret)
+
+	str("NDP_MODE", fc.ND.Mode)
+	list("NDP_PREFIXES", fc.ND.Prefixes)
+	if fc.ND.ExcludeIPs != nil && len(fc.ND.ExcludeIPs) == 0 {
+		str("NDP_EXCLUDE_IPS", "none")
+	} else {
+		list("NDP_EXCLUDE_IPS", fc.ND.ExcludeIPs)
+	}
+	list("NDP_EXTERNAL_INTERFACES", fc.ND.ExternalInterfaces)
+	list("NDP_INTERNAL_INTERFACES", fc.ND.InternalInterfaces)
+	num("NDP_TIMEOUT", fc.ND.Timeout)
+	list("NDP_ADVERTISE_MACS", fc.ND.AdvertiseMACs)
+	num("NDP_CACHE_TTL", fc.ND.CacheTTL)
+
+	str("ROS_HOST", fc.ROS.Host)
+	num("ROS_PORT", fc.ROS.Port)
+	str("ROS_USER", fc.ROS.User)
+	str("ROS_PASSWORD", fc.ROS.Password)
+	if fc.ROS.UseTLS != nil {
+		str("ROS_USETLS", map[bool]string{true: "1", false: "0"}[*fc.ROS.UseTLS])
+	}
+	str("ROS_PROTOCOL", fc.ROS.Protocol)
+	str("ROS_DRY_RUN", fc.ROS.DryRun)
+	str("ROS_TLS_CA", fc.ROS.TLS.CA)
+	str("ROS_TLS_FINGERPRINT", fc.ROS.TLS.Fingerprint)
+	str("ROS_TLS_SERVERNAME", fc.ROS.TLS.ServerName)
+	str("ROS_TLS_CERT", fc.ROS.TLS.Cert)
+	str("ROS_TLS_KEY", fc.ROS.TLS.Key)
+	num("ROS_READ_TIMEOUT", fc.ROS.Timeouts.Read)
+	num("ROS_WRITE_TIMEOUT", fc.ROS.Timeouts.Write)
+	num("ROS_PING_TIMEOUT", fc.ROS.Timeouts.Ping)
+	num("ROS_READ_RETRIES", fc.ROS.ReadRetries)
+	num("ROS_POOL_MIN", fc.ROS.Pool.Min)
+	num("ROS_POOL_MAX", fc.ROS.Pool.Max)
+	num("ROS_POOL_IDLE_TIMEOUT", fc.ROS.Pool.IdleTimeout)
+	num("ROS_POOL_HEALTH_INTERVAL", fc.ROS.Pool.HealthInterval)
+
+	list("HOOK_EXEC", fc.Hook.Exec)
+	list("HOOK_WEBHOOKS", fc.Hook.Webhooks)
+	num("HOOK_TIMEOUT", fc.Hook.Timeout)
+	num("HOOK_RETRIES", fc.Hook.Retries)
+
+	str("DDNS_SERVER", fc.DDNS.Server)
+	str("DDNS_ZONE", fc.DDNS.Zone)
+	var records []string
+	for _, r := range fc.DDNS.Records {
+		records = append(records, r.String())
+	}
+	list("DDNS_RECORDS", records)
+	num("DDNS_TTL", fc.DDNS.TTL)
+	num("DDNS_TIMEOUT", fc.DDNS.Timeout)
+	str("DDNS_TSIG_NAME", fc.DDNS.TSIG.Name)
+	str("DDNS_TSIG_SECRET", fc.DDNS.TSIG.Secret)
+	str("DDNS_TSIG_ALGORITHM", fc.DDNS.TSIG.Algorithm)
+
+	str("METRICS_LISTEN", fc.Metrics.Listen)
+	str("API_LISTEN", fc.API.Listen)
+	str("API_TOKEN", fc.API.Token)
+	if fc.API.Control != nil {
+		str("API_CONTROL", map[bool]string{true: "1", false: "0"}[*fc.API.Control])
+	}
+
+	str("HA_MODE", fc.HA.Mode)
+	str("HA_NODE", fc.HA.Node)
+	num("HA_PRIORITY", fc.HA.Priority)
+	str("HA_LISTEN", fc.HA.Listen)
+	list("HA_PEERS", fc.HA.Peers)
+	str("HA_SECRET", fc.HA.Secret)
+	num("HA_INTERVAL", fc.HA.Interval)
+	num("HA_DEAD_INTERVAL", fc.HA.DeadInterval)
+	if fc.HA.Preempt != nil {
+		str("HA_PREEMPT", map[bool]string{true: "1", false: "0"}[*fc.HA.Preempt])
+	}
+
+	return env
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestConfigFile(t *testing.T) {
+	// invalid fields are reported together with their paths
+	_, err := ParseFileConfig([]byte(`
+ra:
+  mode: ros
+  ros:
+    external_ips:
+      - address: ra-prefix::1/128
+        interface: "@external"
+    pools:
+      - prefix: ra-prefix
+        name: pool
+        prefix_length: 64
+ros:
+  port: 70000
+  protocol: ssh
+`))
+	var errs ConfigErrors
+	if !errors.As(err, &errs) || len(errs) != 3 ||
+		errs[0].Path != "ra.ros.external_ips[0]" ||
+		errs[1].Path != "ros.port" ||
+		errs[2].Path != "ros.protocol" {
+		t.Fatalf("unexpected validation errors: %v", err)
+	}
+
+	// @external may refer to RA_ROS_EXTERNAL_INTERFACE set in the environment
+	t.Setenv("RA_ROS_EXTERNAL_INTERFACE", "ether1")
+	if _, err := ParseFileConfig([]byte(`
+ra:
+  ros:
+    external_ips:
+      - address: ra-prefix::1/128
+        interface: "@external"
+`)); err != nil {
+		t.Fatalf("@external with RA_ROS_EXTERNAL_INTERFACE in the environment was rejected: %s", err)
+	}
+
+	// unknown keys are rejected
+	if _, err := ParseFileConfig([]byte("ros:\n  hostname: 192.168.88.1\n")); err == nil {
+		t.Fatalf("unknown key was accepted")
+	}
+
+	// environment variables override the file
+	fc, err := ParseFileConfig([]byte(`
+ra:
+  ros:
+    external_interface: ether1
+    external_ips:
+      - address: ra-prefix::1/128
+        interface: "@external"
+        options: [advertise]
+    pools: []
+ros:
+  host: 192.168.88.1
+  port: 8729
+  use_tls: true
+`))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	fileEnv = fc.env()
+	defer func() { fileEnv = map[string]string{} }()
+	os.Setenv("ROS_PORT", "18728")
+	defer os.Unsetenv("ROS_PORT")
+	if v := getenv("RA_ROS_EXTERNAL_IPS"); v != "ra-prefix::1/128@@external:advertise" {
+		t.Fatalf("unexpected RA_ROS_EXTERNAL_IPS '%s'", v)
+	}
+	if v := getenv("RA_ROS_POOLS"); v != "none" {
+		t.Fatalf("unexpected RA_ROS_POOLS '%s'", v)
+	}
+	if v := getenv("ROS_USETLS"); v != "1" {
+		t.Fatalf("unexpected ROS_USETLS '%s'", v)
+	}
+	if v := getenv("ROS_PORT"); v != "18728" {
+		t.Fatalf("ROS_PORT was not overridden: '%s'", v)
+	}
+	if v := getenv("ROS_HOST"); v != "192.168.88.1" {
+		t.Fatalf("unexpected ROS_HOST '%s'", v)
+	}
+}
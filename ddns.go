@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type DDNSRecord struct {
+	ip   FlexibleIP
+	fqdn string
+}
+
+type DDNSConfig struct {
+	server        string
+	zone          string
+	records       []DDNSRecord
+	ttl           uint32
+	timeout       time.Duration
+	tsigName      string
+	tsigAlgorithm string
+	tsigSecret    string
+}
+
+type DDNSUpdater struct {
+	cfg   *DDNSConfig
+	ra    *RAClient
+	queue chan RouterInfoEvent
+}
+
+func dumpDDNSConfig(cfg *DDNSConfig) {
+	ddnslog.Debug("DDNS Configuration:")
+	ddnslog.Debug("  DDNS_SERVER=%s", cfg.server)
+	ddnslog.Debug("  DDNS_ZONE=%s", cfg.zone)
+	if len(cfg.records) > 0 {
+		ddnslog.Debug("  DDNS_RECORDS")
+		for i, r := range cfg.records {
+			ddnslog.Debug("  %3d: %s -> %s", i, r.ip, r.fqdn)
+		}
+	}
+	ddnslog.Debug("  DDNS_TTL=%d", cfg.ttl)
+	ddnslog.Debug("  DDNS_TIMEOUT=%d", cfg.timeout/time.Millisecond)
+	if cfg.tsigName != "" {
+		ddnslog.Debug("  DDNS_TSIG_NAME=%s", cfg.tsigName)
+		ddnslog.Debug("  DDNS_TSIG_ALGORITHM=%s", cfg.tsigAlgorithm)
+	}
+}
+
+func (cfg *DDNSConfig) Enabled() bool {
+	return cfg.server != "" && len(cfg.records) > 0
+}
+
+func NewDDNSUpdater(cfg *DDNSConfig, ra *RAClient) *DDNSUpdater {
+	return &DDNSUpdater{
+		cfg:   cfg,
+		ra:    ra,
+		queue: make(chan RouterInfoEvent, 1),
+	}
+}
+
+// HandleRouterInfo only needs to wake the worker; the records are always
+// resolved against the latest RouterInfo, so pending events can be coalesced.
+func (u *DDNSUpdater) HandleRouterInfo(ev RouterInfoEvent) {
+	select {
+	case u.queue <- ev:
+	default:
+	}
+}
+
+// backoff between retries of a failed update
+const (
+	ddnsRetryMin = time.Second * 5
+	ddnsRetryMax = time.Minute * 5
+)
+
+// Work updates the records on every event. A failed update is retried with
+// backoff until it succeeds or the next event arrives.
+func (u *DDNSUpdater) Work(ctx context.Context) error {
+	var retry <-chan time.Time
+	backoff := ddnsRetryMin
+	for {
+		var reason string
+		select {
+		case ev := <-u.queue:
+			reason = ev.Reason
+			backoff = ddnsRetryMin
+		case <-retry:
+			reason = "retry"
+		case <-ctx.Done():
+			return fmt.Errorf("canceled by context")
+		}
+		ddnslog.Debug("Updating DNS records (event=%s)", reason)
+		if err := u.Update(ctx); err != nil {
+			ddnslog.Warning("DDNS update failed. Retrying in %s: %s", backoff, err)
+			retry = time.After(backoff)
+			backoff *= 2
+			if backoff > ddnsRetryMax {
+				backoff = ddnsRetryMax
+			}
+		} else {
+			retry = nil
+		}
+	}
+}
+
+func (u *DDNSUpdater) client() *dns.Client {
+	c := &dns.Client{
+		Net:     "tcp",
+		Timeout: u.cfg.timeout,
+	}
+	if u.cfg.tsigName != "" {
+		c.TsigSecret = map[string]string{u.cfg.tsigName: u.cfg.tsigSecret}
+	}
+	return c
+}
+
+// resolve groups the addresses by FQDN. Records which can't be resolved
+// (e.g. a subnet outside the prefix) are skipped, so an FQDN in fqdns may
+// have no address.
+func (u *DDNSUpdater) resolve() (ips map[string][]net.IP, fqdns []string, resolved int) {
+	ips = make(map[string][]net.IP)
+	for _, r := range u.cfg.records {
+		if _, ok := ips[r.fqdn]; !ok {
+			// in the order of DDNS_RECORDS
+			fqdns = append(fqdns, r.fqdn)
+			ips[r.fqdn] = nil
+		}
+		ip, err := u.ra.resolveFIP(r.ip)
+		if err != nil {
+			ddnslog.Warning("Skipping DDNS record %s: %s", r.fqdn, err)
+			continue
+		}
+		ips[r.fqdn] = append(ips[r.fqdn], ip.IP)
+		resolved++
+	}
+	return ips, fqdns, resolved
+}
+
+// Update replaces the AAAA RRsets of all FQDNs. The RRset of an FQDN whose
+// records are all skipped is deleted instead of leaving the stale addresses.
+func (u *DDNSUpdater) Update(ctx context.Context) error {
+	ips, fqdns, resolved := u.resolve()
+	if resolved == 0 {
+		return fmt.Errorf("none of DDNS_RECORDS could be resolved")
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(u.cfg.zone)
+	for _, fqdn := range fqdns {
+		m.RemoveRRset([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeAAAA, Class: dns.ClassINET}}})
+		var rrs []dns.RR
+		for _, ip := range ips[fqdn] {
+			ddnslog.Trace("  %s AAAA %s", fqdn, ip)
+			rrs = append(rrs, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: fqdn, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: u.cfg.ttl},
+				AAAA: ip,
+			})
+		}
+		if len(rrs) > 0 {
+			m.Insert(rrs)
+		}
+	}
+	if u.cfg.tsigName != "" {
+		m.SetTsig(u.cfg.tsigName, u.cfg.tsigAlgorithm, 300, time.Now().Unix())
+	}
+
+	rep, _, err := u.client().ExchangeContext(ctx, m, u.cfg.server)
+	if err != nil {
+		return fmt.Errorf("UPDATE to %s failed: %s", u.cfg.server, err)
+	}
+	if rep.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("UPDATE to %s was refused: %s", u.cfg.server, dns.RcodeToString[rep.Rcode])
+	}
+
+	// verify
+	for _, fqdn := range fqdns {
+		if err := u.verify(ctx, fqdn, ips[fqdn]); err != nil {
+			return err
+		}
+		if len(ips[fqdn]) == 0 {
+			ddnslog.Info("DNS record removed: %s AAAA (no resolvable address)", fqdn)
+		} else {
+			ddnslog.Info("DNS record updated: %s AAAA %s", fqdn, ips[fqdn])
+		}
+	}
+
+	return nil
+}
+
+func (u *DDNSUpdater) verify(ctx context.Context, fqdn string, ips []net.IP) error {
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn, dns.TypeAAAA)
+	m.RecursionDesired = false
+	rep, _, err := u.client().ExchangeContext(ctx, m, u.cfg.server)
+	if err != nil {
+		return fmt.Errorf("verification query for %s failed: %s", fqdn, err)
+	}
+	if len(ips) == 0 {
+		for _, rr := range rep.Answer {
+			if aaaa, ok := rr.(*dns.AAAA); ok {
+				return fmt.Errorf("%s still resolves to %s after UPDATE", fqdn, aaaa.AAAA)
+			}
+		}
+		return nil
+	}
+next:
+	for _, ip := range ips {
+		for _, rr := range rep.Answer {
+			if aaaa, ok := rr.(*dns.AAAA); ok && aaaa.AAAA.Equal(ip) {
+				continue next
+			}
+		}
+		return fmt.Errorf("%s does not resolve to %s after UPDATE", fqdn, ip)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ddnsStandin is an authoritative DNS server applying RFC 2136 UPDATEs
+// signed with TSIG to an in-memory zone
+type ddnsStandin struct {
+	mutex   sync.Mutex
+	zone    map[string][]net.IP
+	updates []*dns.Msg
+	refuse  bool // answer UPDATEs with REFUSED
+	ignore  bool // answer UPDATEs with NOERROR without applying them
+}
+
+func (s *ddnsStandin) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if r.Opcode == dns.OpcodeUpdate {
+		s.updates = append(s.updates, r)
+		switch {
+		case r.IsTsig() == nil || w.TsigStatus() != nil:
+			m.Rcode = dns.RcodeNotAuth
+		case s.refuse:
+			m.Rcode = dns.RcodeRefused
+		case !s.ignore:
+			for _, rr := range r.Ns {
+				h := rr.Header()
+				if h.Class == dns.ClassANY && h.Rrtype == dns.TypeAAAA {
+					delete(s.zone, h.Name)
+				} else if aaaa, ok := rr.(*dns.AAAA); ok {
+					s.zone[h.Name] = append(s.zone[h.Name], aaaa.AAAA)
+				}
+			}
+		}
+		if r.IsTsig() != nil {
+			m.SetTsig(r.IsTsig().Hdr.Name, r.IsTsig().Algorithm, 300, time.Now().Unix())
+		}
+	} else {
+		for _, q := range r.Question {
+			for _, ip := range s.zone[q.Name] {
+				m.Answer = append(m.Answer, &dns.AAAA{
+					Hdr:  dns.RR_Header{Name: q.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 300},
+					AAAA: ip,
+				})
+			}
+		}
+	}
+	_ = w.WriteMsg(m)
+}
+
+func TestDDNS(t *testing.T) {
+	const tsigName, tsigSecret = "key.example.com.", "c2VjcmV0LWtleS1mb3ItdGVzdHM="
+	standin := &ddnsStandin{zone: map[string][]net.IP{
+		"router.example.com.": {net.ParseIP("2001:db8:ffff::1")}, // stale
+		"bad.example.com.":    {net.ParseIP("2001:db8:ffff::2")}, // stale
+	}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{Listener: ln, Net: "tcp", Handler: standin, TsigSecret: map[string]string{tsigName: tsigSecret},
+		// the default rejects UPDATEs
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }}
+	go func() { _ = srv.ActivateAndServe() }()
+	defer func() { _ = srv.Shutdown() }()
+
+	cfg := &DDNSConfig{
+		server:        ln.Addr().String(),
+		zone:          "example.com.",
+		ttl:           300,
+		timeout:       time.Second * 2,
+		tsigName:      tsigName,
+		tsigAlgorithm: dns.HmacSHA256,
+		tsigSecret:    tsigSecret,
+	}
+	for _, spec := range []string{
+		"ra-prefix::1@router.example.com",
+		"ra-prefix/64#1::1@router.example.com", // same name: both records are kept
+		"ra-prefix/64#256::1@bad.example.com",  // outside the prefix: skipped
+		"ra-prefix::2@www.example.com",
+	} {
+		r, err := ParseDDNSRecord(spec, cfg.zone)
+		if err != nil {
+			t.Fatalf("ParseDDNSRecord(%s) failed: %s", spec, err)
+		}
+		cfg.records = append(cfg.records, r)
+	}
+	rac := NewRAClient(&RAConfig{mode: "ros"}, nil)
+	_, n, _ := net.ParseCIDR("2001:db8:1200::/56")
+	rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+	u := NewDDNSUpdater(cfg, rac)
+
+	// UPDATE replaces the RRsets and is verified
+	if err := u.Update(context.Background()); err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+	standin.mutex.Lock()
+	if got := fmt.Sprint(standin.zone["router.example.com."]); got != "[2001:db8:1200::1 2001:db8:1200:1::1]" {
+		t.Fatalf("unexpected router.example.com: %s", got)
+	}
+	if got := fmt.Sprint(standin.zone["www.example.com."]); got != "[2001:db8:1200::2]" {
+		t.Fatalf("unexpected www.example.com: %s", got)
+	}
+	if got := standin.zone["bad.example.com."]; len(got) != 0 {
+		t.Fatalf("the RRset without resolvable records was kept: %s", got)
+	}
+	update := standin.updates[len(standin.updates)-1]
+	// 3 deletions and 3 insertions
+	if update.IsTsig() == nil || len(update.Question) != 1 || update.Question[0].Name != "example.com." || len(update.Ns) != 6 {
+		t.Fatalf("unexpected UPDATE: %s", update)
+	}
+	for _, rr := range update.Ns {
+		if aaaa, ok := rr.(*dns.AAAA); ok && aaaa.Hdr.Class == dns.ClassINET && aaaa.Hdr.Ttl != 300 {
+			t.Fatalf("unexpected TTL: %s", rr)
+		}
+	}
+
+	// nothing to update before the first RA: fails to be retried
+	updates := len(standin.updates)
+	standin.mutex.Unlock()
+	rac.routerInfo = nil
+	if err := u.Update(context.Background()); err == nil {
+		t.Fatalf("Update without any resolvable record succeeded")
+	}
+	standin.mutex.Lock()
+	if len(standin.updates) != updates {
+		t.Fatalf("UPDATE was sent without any resolvable record")
+	}
+	standin.mutex.Unlock()
+
+	// the server accepts the UPDATE but the records don't change
+	standin.mutex.Lock()
+	standin.ignore = true
+	standin.mutex.Unlock()
+	rac.routerInfo = &RouterInfo{prefix: mustParseCIDR("2001:db8:3400::/56"), gateway: net.ParseIP("fe80::1")}
+	if err := u.Update(context.Background()); err == nil || !strings.Contains(err.Error(), "does not resolve") {
+		t.Fatalf("verification did not fail: %v", err)
+	}
+
+	// refused
+	standin.mutex.Lock()
+	standin.ignore, standin.refuse = false, true
+	standin.mutex.Unlock()
+	if err := u.Update(context.Background()); err == nil || !strings.Contains(err.Error(), "REFUSED") {
+		t.Fatalf("refused UPDATE did not fail: %v", err)
+	}
+
+	// signed with a wrong key
+	standin.mutex.Lock()
+	standin.refuse = false
+	standin.mutex.Unlock()
+	cfg.tsigSecret = "d3Jvbmcta2V5"
+	if err := u.Update(context.Background()); err == nil {
+		t.Fatalf("UPDATE with a wrong TSIG key succeeded")
+	}
+}
+
+func mustParseCIDR(s string) net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return *n
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FakeRouter is an in-memory RouterBackend which models the RouterOS tables
+// touched by the companion. It is used to exercise RAClient and NDClient
+// without a RouterBoard.
+type FakeRouter struct {
+	tables     map[string][]map[string]string
+	interfaces map[string]net.HardwareAddr
+	pingable   map[string]bool
+	seq        int
+	writes     int64
+	dryRun     string
+	plan       []ROSPlanChange
+	planSeq    int
+	mutex      sync.Mutex
+}
+
+func NewFakeRouter() *FakeRouter {
+	return &FakeRouter{
+		tables: map[string][]map[string]string{
+			"/ipv6/address":               nil,
+			"/ipv6/route":                 nil,
+			"/ipv6/pool":                  nil,
+			"/ipv6/neighbor":              nil,
+			"/ipv6/firewall/address-list": nil,
+		},
+		interfaces: make(map[string]net.HardwareAddr),
+		pingable:   make(map[string]bool),
+		dryRun:     "off",
+	}
+}
+
+// test helpers
+
+func (r *FakeRouter) SetInterface(name string, mac net.HardwareAddr) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.interfaces[name] = mac
+}
+
+// AddNeighbor adds a neighbor entry. status is one of reachable, stale, failed etc.
+func (r *FakeRouter) AddNeighbor(ip net.IP, mac net.HardwareAddr, status string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.insert("/ipv6/neighbor", map[string]string{
+		"address":     ip.String(),
+		"mac-address": mac.String(),
+		"status":      status,
+	})
+}
+
+// SetPingable makes ping to ip succeed even without a neighbor entry
+func (r *FakeRouter) SetPingable(ip net.IP) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.pingable[ip.String()] = true
+}
+
+// SetDryRun makes the writes recorded to the plan instead of the tables
+// as ROS_DRY_RUN does (mode is one of off, exit, observe)
+func (r *FakeRouter) SetDryRun(mode string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.dryRun = mode
+}
+
+// Insert adds a row as if it was configured by hand
+func (r *FakeRouter) Insert(path string, props map[string]string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.insert(path, props)
+}
+
+func (r *FakeRouter) Rows(path string) []map[string]string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	rows := make([]map[string]string, len(r.tables[path]))
+	for i, row := range r.tables[path] {
+		rows[i] = copyRow(row)
+	}
+	return rows
+}
+
+func copyRow(row map[string]string) map[string]string {
+	c := make(map[string]string, len(row))
+	for k, v := range row {
+		c[k] = v
+	}
+	return c
+}
+
+// table operations (must be called with mutex held)
+
+func (r *FakeRouter) insert(path string, props map[string]string) string {
+	r.seq++
+	row := map[string]string{".id": fmt.Sprintf("*%X", r.seq)}
+	for k, v := range props {
+		row[k] = v
+	}
+	r.tables[path] = append(r.tables[path], row)
+	return row[".id"]
+}
+
+func (r *FakeRouter) add(path string, props map[string]string) string {
+	if r.dryRun != "off" {
+		r.planSeq++
+		id := fmt.Sprintf("*planned%d", r.planSeq)
+		r.plan = append(r.plan, ROSPlanChange{Action: "add", Path: path, ID: id, After: copyRow(props)})
+		return id
+	}
+	r.writes++
+	return r.insert(path, props)
+}
+
+func (r *FakeRouter) set(path string, id string, props map[string]string) error {
+	for _, row := range r.tables[path] {
+		if row[".id"] == id {
+			if r.dryRun != "off" {
+				before := make(map[string]string)
+				for k := range props {
+					if v, ok := row[k]; ok {
+						before[k] = v
+					}
+				}
+				r.plan = append(r.plan, ROSPlanChange{Action: "set", Path: path, ID: id, Before: before, After: copyRow(props)})
+				return nil
+			}
+			r.writes++
+			for k, v := range props {
+				row[k] = v
+			}
+			return nil
+		}
+	}
+	return &ROSError{Command: path + "/set", Message: "no such item", Kind: ErrROSNotFound}
+}
+
+func (r *FakeRouter) remove(path string, id string) error {
+	rows := r.tables[path]
+	for i, row := range rows {
+		if row[".id"] == id {
+			if r.dryRun != "off" {
+				before := copyRow(row)
+				delete(before, ".id")
+				r.plan = append(r.plan, ROSPlanChange{Action: "remove", Path: path, ID: id, Before: before})
+				return nil
+			}
+			r.writes++
+			r.tables[path] = append(rows[:i:i], rows[i+1:]...)
+			return nil
+		}
+	}
+	return &ROSError{Command: path + "/remove", Message: "no such item", Kind: ErrROSNotFound}
+}
+
+// RouterBackend
+
+func (r *FakeRouter) GetInterfaceMAC(_ context.Context, name string) (net.HardwareAddr, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	mac, ok := r.interfaces[name]
+	if !ok {
+		return nil, fmt.Errorf("device %s not found", name)
+	}
+	return mac, nil
+}
+
+func (r *FakeRouter) LookupNeighbor(_ context.Context, ip net.IP, timeoutms int, strict bool) (net.HardwareAddr, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, row := range r.tables["/ipv6/neighbor"] {
+		if !net.ParseIP(row["address"]).Equal(ip) {
+			continue
+		}
+		if row["status"] == "reachable" || row["status"] == "stale" {
+			return net.ParseMAC(row["mac-address"])
+		}
+	}
+	if !strict && r.pingable[ip.String()] {
+		return make(net.HardwareAddr, 6), nil
+	}
+	return nil, nil
+}
+
+func (r *FakeRouter) SetIPv6Gateway(_ context.Context, ifname string, gateway net.IP) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	gw := fmt.Sprintf("%s%%%s", gateway, ifname)
+	modTarget := ""
+	for _, row := range r.tables["/ipv6/route"] {
+		if row["dst-address"] != "::/0" {
+			continue
+		}
+		if row["gateway"] == gw && !isROSTrue(row["disabled"]) {
+			return row[".id"], nil
+		}
+		if row["comment"] == rosCommentKey {
+			modTarget = row[".id"]
+		}
+	}
+	if modTarget != "" {
+		return modTarget, r.set("/ipv6/route", modTarget, map[string]string{"gateway": gw, "disabled": "false"})
+	}
+	return r.add("/ipv6/route", map[string]string{
+		"dst-address": "::/0",
+		"gateway":     gw,
+		"comment":     rosCommentKey,
+	}), nil
+}
+
+func (r *FakeRouter) AssignIPv6(_ context.Context, ifname string, ip *net.IPNet, key string, options ROSIPOptions) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	comment := fmt.Sprintf("%s %s", rosCommentKey, key)
+	props := map[string]string{
+		"address":   ip.String(),
+		"advertise": strconv.FormatBool(options.Advertise),
+		"eui-64":    strconv.FormatBool(options.Eui64),
+	}
+	for _, row := range r.tables["/ipv6/address"] {
+		if row["comment"] != comment || row["interface"] != ifname {
+			continue
+		}
+		if cidrEqual(row["address"], *ip) &&
+			row["advertise"] == props["advertise"] &&
+			row["eui-64"] == props["eui-64"] &&
+			!isROSTrue(row["disabled"]) {
+			return row[".id"], nil
+		}
+		props["disabled"] = "false"
+		return row[".id"], r.set("/ipv6/address", row[".id"], props)
+	}
+	props["interface"] = ifname
+	props["comment"] = comment
+	return r.add("/ipv6/address", props), nil
+}
+
+func (r *FakeRouter) ExportIPv6Pool(_ context.Context, name string, cidr net.IPNet, prefixlen int, key string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	comment := fmt.Sprintf("%s %s", rosCommentKey, key)
+	props := map[string]string{
+		"prefix":        cidr.String(),
+		"prefix-length": strconv.Itoa(prefixlen),
+	}
+	for _, row := range r.tables["/ipv6/pool"] {
+		if row["name"] != name {
+			continue
+		}
+		tag := row["comment"] != comment && (row["comment"] == "" || isOwnedComment(row["comment"]))
+		if cidrEqual(row["prefix"], cidr) && row["prefix-length"] == props["prefix-length"] && !tag {
+			return row[".id"], nil
+		}
+		if tag {
+			props["comment"] = comment
+		}
+		return row[".id"], r.set("/ipv6/pool", row[".id"], props)
+	}
+	props["name"] = name
+	props["comment"] = comment
+	return r.add("/ipv6/pool", props), nil
+}
+
+func (r *FakeRouter) SetIPv6AddressList(_ context.Context, list string, ip *net.IPNet, key string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	comment := fmt.Sprintf("%s %s", rosCommentKey, key)
+	keep := ""
+	var stale []string
+	for _, row := range r.tables["/ipv6/firewall/address-list"] {
+		if row["list"] != list || row["comment"] != comment {
+			continue
+		}
+		if keep == "" && cidrEqual(row["address"], *ip) {
+			keep = row[".id"]
+			continue
+		}
+		stale = append(stale, row[".id"])
+	}
+	id := ""
+	if keep == "" && len(stale) > 0 {
+		id = stale[0]
+		stale = stale[1:]
+	}
+	for _, sid := range stale {
+		if err := r.remove("/ipv6/firewall/address-list", sid); err != nil {
+			return "", err
+		}
+	}
+	if keep != "" {
+		return keep, nil
+	}
+	if id != "" {
+		return id, r.set("/ipv6/firewall/address-list", id, map[string]string{"address": ip.String()})
+	}
+	return r.add("/ipv6/firewall/address-list", map[string]string{
+		"list":    list,
+		"address": ip.String(),
+		"comment": comment,
+	}), nil
+}
+
+func (r *FakeRouter) ListOwnedObjects(context.Context) ([]ROSObject, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var objs []ROSObject
+	for _, p := range rosOwnedPaths {
+		for _, row := range r.tables[p.path] {
+			if !isOwnedComment(row["comment"]) {
+				continue
+			}
+			props := make(map[string]string)
+			for _, k := range strings.Split(p.proplist, ",") {
+				if v, ok := row[k]; ok {
+					props[k] = v
+				}
+			}
+			objs = append(objs, ROSObject{path: p.path, id: row[".id"], props: props})
+		}
+	}
+	return objs, nil
+}
+
+func (r *FakeRouter) RemoveObject(_ context.Context, obj ROSObject) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.remove(obj.path, obj.id)
+}
+
+func (r *FakeRouter) DisableObject(_ context.Context, obj ROSObject) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.set(obj.path, obj.id, map[string]string{"disabled": "true"})
+}
+
+func (r *FakeRouter) Close() {}
+
+func (r *FakeRouter) WriteCount() int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.writes
+}
+
+func (r *FakeRouter) DryRunMode() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.dryRun
+}
+
+func (r *FakeRouter) TakePlan() []ROSPlanChange {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	plan := r.plan
+	r.plan = nil
+	r.planSeq = 0
+	return plan
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+// planRecorder keeps the plan taken by RAClient.reconcile
+type planRecorder struct {
+	*FakeRouter
+	taken []ROSPlanChange
+}
+
+func (r *planRecorder) TakePlan() []ROSPlanChange {
+	r.taken = r.FakeRouter.TakePlan()
+	return r.taken
+}
+
+func TestFakeBackend(t *testing.T) {
+	fake := NewFakeRouter()
+	fake.SetInterface("vlanflets", net.HardwareAddr{0x48, 0xa9, 0x8a, 0x22, 0xc8, 0xc8})
+	userRoute := fake.Insert("/ipv6/route", map[string]string{"dst-address": "::/0", "gateway": "fe80::99%ether2"})
+
+	cfg := &RAConfig{mode: "ros", rosExtIf: "vlanflets", rosGC: "on"}
+	for _, a := range []string{"ra-prefix::1/128@@external", "ra-prefix::2/64@bridge:advertise"} {
+		ass, err := ParseROSIPAssign(a, cfg.rosExtIf)
+		if err != nil {
+			t.Fatalf("ParseROSIPAssign failed: %s", err)
+		}
+		cfg.rosExtIPs = append(cfg.rosExtIPs, ass)
+	}
+	pool, _ := ParseROSPoolAssign("ra-prefix@fletsv6-pool/64")
+	cfg.rosPools = append(cfg.rosPools, pool)
+	al, _ := ParseROSAddressListAssign("ra-prefix::10/128@servers")
+	cfg.rosLists = append(cfg.rosLists, al)
+
+	rac := NewRAClient(cfg, fake)
+	setPrefix := func(prefix string, gw string) {
+		_, n, _ := net.ParseCIDR(prefix)
+		rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP(gw)}
+	}
+	expect := func(path string, key string, value string) {
+		for _, row := range fake.Rows(path) {
+			if row[key] == value {
+				return
+			}
+		}
+		t.Fatalf("%s has no row with %s=%s: %+v", path, key, value, fake.Rows(path))
+	}
+
+	// initial reconcile
+	setPrefix("2001:db8:1::/64", "fe80::1")
+	rac.reconcile(context.Background(), "solicited")
+	expect("/ipv6/route", "gateway", "fe80::1%vlanflets")
+	expect("/ipv6/address", "address", "2001:db8:1::1/128")
+	expect("/ipv6/address", "address", "2001:db8:1::2/64")
+	expect("/ipv6/pool", "prefix", "2001:db8:1::/64")
+	expect("/ipv6/firewall/address-list", "address", "2001:db8:1::10/128")
+	if r := rac.LastReconcile(); r.Changes != 5 || len(r.Errors) != 0 {
+		t.Fatalf("unexpected reconcile result: %+v", r)
+	}
+
+	// nothing to do when in desired state
+	rac.reconcile(context.Background(), "periodic")
+	if r := rac.LastReconcile(); r.Changes != 0 {
+		t.Fatalf("reconcile in desired state made %d changes", r.Changes)
+	}
+
+	// renumbering updates objects in place
+	setPrefix("2001:db8:2::/64", "fe80::2")
+	rac.reconcile(context.Background(), "changed")
+	expect("/ipv6/route", "gateway", "fe80::2%vlanflets")
+	expect("/ipv6/address", "address", "2001:db8:2::2/64")
+	expect("/ipv6/firewall/address-list", "address", "2001:db8:2::10/128")
+	if n := len(fake.Rows("/ipv6/address")); n != 2 {
+		t.Fatalf("expected 2 addresses, got %d", n)
+	}
+
+	// removed assignments are garbage collected, user objects are kept
+	cfg.rosExtIPs = cfg.rosExtIPs[:1]
+	rac.reconcile(context.Background(), "periodic")
+	if n := len(fake.Rows("/ipv6/address")); n != 1 {
+		t.Fatalf("expected 1 address after gc, got %d", n)
+	}
+	expect("/ipv6/route", ".id", userRoute)
+
+	// CLEANUP_ON_EXIT=disable disables the route and addresses until the next reconcile
+	cfg.cleanupOnExit = "disable"
+	rac.Cleanup(context.Background())
+	expect("/ipv6/route", "disabled", "true")
+	expect("/ipv6/address", "disabled", "true")
+	expect("/ipv6/pool", "prefix", "2001:db8:2::/64")
+	rac.reconcile(context.Background(), "solicited")
+	for _, path := range []string{"/ipv6/route", "/ipv6/address"} {
+		for _, row := range fake.Rows(path) {
+			if isROSTrue(row["disabled"]) {
+				t.Fatalf("%s was not enabled again: %+v", path, row)
+			}
+		}
+	}
+	cfg.cleanupOnExit = "remove"
+	rac.Cleanup(context.Background())
+	if n := len(fake.Rows("/ipv6/address")); n != 0 {
+		t.Fatalf("expected no address after cleanup, got %d", n)
+	}
+	expect("/ipv6/route", ".id", userRoute)
+	rac.reconcile(context.Background(), "solicited")
+
+	// dry-run only plans the renumbering, a new address and gc
+	snapshot := func() map[string][]map[string]string {
+		tables := make(map[string][]map[string]string)
+		for _, path := range []string{"/ipv6/route", "/ipv6/address", "/ipv6/pool", "/ipv6/firewall/address-list"} {
+			tables[path] = fake.Rows(path)
+		}
+		return tables
+	}
+	extIPs, lists := cfg.rosExtIPs, cfg.rosLists
+	addr2, _ := ParseROSIPAssign("ra-prefix::2/64@bridge", cfg.rosExtIf)
+	cfg.rosExtIPs = append(extIPs[:1:1], addr2)
+	cfg.rosLists = nil
+	setPrefix("2001:db8:3::/64", "fe80::3")
+	// reconcile prints the plan, so keep what it took
+	rec := &planRecorder{FakeRouter: fake}
+	drac := NewRAClient(cfg, rec)
+	drac.routerInfo = rac.routerInfo
+	writes := fake.WriteCount()
+	for _, mode := range []string{"exit", "observe"} {
+		fake.SetDryRun(mode)
+		before := snapshot()
+		drac.reconcile(context.Background(), "changed")
+		if after := snapshot(); !reflect.DeepEqual(before, after) {
+			t.Fatalf("dry-run (%s) changed the tables: %+v -> %+v", mode, before, after)
+		}
+		// planned writes are not counted as changes
+		if r := drac.LastReconcile(); r.Changes != 0 || r.Planned != 5 || fake.WriteCount() != writes {
+			t.Fatalf("unexpected dry-run (%s) result: %+v", mode, r)
+		}
+		actions := make(map[string]int)
+		for _, ch := range rec.taken {
+			actions[ch.Action+" "+ch.Path]++
+			switch {
+			case ch.Action == "set" && ch.Path == "/ipv6/route" && ch.After["gateway"] != "fe80::3%vlanflets":
+				t.Fatalf("unexpected route change: %+v", ch)
+			case ch.Action == "set" && ch.Path == "/ipv6/address" && ch.After["address"] != "2001:db8:3::1/128":
+				t.Fatalf("unexpected address change: %+v", ch)
+			case ch.Action == "add" && ch.After["address"] != "2001:db8:3::2/64":
+				t.Fatalf("unexpected address addition: %+v", ch)
+			case ch.Action == "remove" && ch.Before["address"] != "2001:db8:2::10/128":
+				t.Fatalf("unexpected removal: %+v", ch)
+			}
+		}
+		if !reflect.DeepEqual(actions, map[string]int{
+			"set /ipv6/route":                    1,
+			"set /ipv6/address":                  1,
+			"set /ipv6/pool":                     1,
+			"add /ipv6/address":                  1,
+			"remove /ipv6/firewall/address-list": 1,
+		}) {
+			t.Fatalf("unexpected dry-run (%s) plan: %+v", mode, actions)
+		}
+	}
+	fake.SetDryRun("off")
+	cfg.rosExtIPs, cfg.rosLists = extIPs, lists
+	setPrefix("2001:db8:2::/64", "fe80::2")
+
+	// processNd answers with the MAC of the RouterOS interface
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatalf("Socketpair failed: %s", err)
+	}
+	target := net.ParseIP("2001:db8:2::1234")
+	fake.AddNeighbor(target, net.HardwareAddr{0xb8, 0x27, 0xeb, 0xf6, 0x0f, 0x8f}, "reachable")
+	ndc := NewNDClient(&NDConfig{mode: "proxy-ros:strict", timeoutMs: 100}, rac, fake)
+	ref := &SockRef{name: "fake", s: &Socket{fd: fds[0], isValid: true}, advMAC: MACRef{rosIf: "vlanflets"}}
+	ndc.processNd(context.Background(), target, net.HardwareAddr{0, 0x1f, 0x6c, 0x25, 0xf4, 0xc4}, net.ParseIP("fe80::1"), ref)
+	buf := make([]byte, 2048)
+	n, err := syscall.Read(fds[1], buf)
+	if err != nil {
+		t.Fatalf("no NA was sent: %s", err)
+	}
+	na := ICMPv6Data[*layers.ICMPv6NeighborAdvertisement]{}
+	if err := parseICMPv6(buf[:n], &na); err != nil {
+		t.Fatalf("failed to parse NA: %s", err)
+	}
+	if !na.Layer.TargetAddress.Equal(target) || na.SrcMAC.String() != "48:a9:8a:22:c8:c8" {
+		t.Fatalf("unexpected NA: %+v", na)
+	}
+
+	// unknown neighbors are not answered in strict mode
+	ndc.processNd(context.Background(), net.ParseIP("2001:db8:2::dead"), net.HardwareAddr{0, 0x1f, 0x6c, 0x25, 0xf4, 0xc4}, net.ParseIP("fe80::1"), ref)
+	_ = syscall.SetNonblock(fds[1], true)
+	if _, err := syscall.Read(fds[1], buf); err != syscall.EAGAIN {
+		t.Fatalf("NA was sent for an unknown neighbor")
+	}
+}
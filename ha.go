@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Leadership tells whether this instance should answer NSs and reconcile
+type Leadership interface {
+	IsActive() bool
+}
+
+type HAConfig struct {
+	mode         string // off or heartbeat
+	node         string
+	priority     int
+	listen       string
+	peers        []string
+	secret       string
+	interval     time.Duration
+	deadInterval time.Duration
+	preempt      bool
+}
+
+func dumpHAConfig(cfg *HAConfig) {
+	halog.Debug("HA Configuration:")
+	halog.Debug("  HA_MODE=%s", cfg.mode)
+	if cfg.mode == "off" {
+		return
+	}
+	halog.Debug("  HA_NODE=%s", cfg.node)
+	halog.Debug("  HA_PRIORITY=%d", cfg.priority)
+	halog.Debug("  HA_LISTEN=%s", cfg.listen)
+	halog.Debug("  HA_PEERS=%+v", cfg.peers)
+	if cfg.secret != "" {
+		halog.Debug("  HA_SECRET=(set)")
+	}
+	halog.Debug("  HA_INTERVAL=%d", cfg.interval/time.Millisecond)
+	halog.Debug("  HA_DEAD_INTERVAL=%d", cfg.deadInterval/time.Millisecond)
+	halog.Debug("  HA_PREEMPT=%v", cfg.preempt)
+}
+
+func (cfg *HAConfig) Enabled() bool {
+	return cfg.mode != "off"
+}
+
+// heartbeats older or newer than this are rejected when HA_SECRET is set
+const haMaxClockSkew = time.Minute
+
+const haMagic = "fletsv6-ha1"
+
+type haHeartbeat struct {
+	Node     string `json:"node"`
+	Priority int    `json:"priority"`
+	Active   bool   `json:"active"`
+	Time     int64  `json:"time"` // unix milliseconds
+}
+
+type HAPeer struct {
+	Node     string    `json:"node"`
+	Addr     string    `json:"addr"`
+	Priority int       `json:"priority"`
+	Active   bool      `json:"active"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type HAStatus struct {
+	Node     string   `json:"node"`
+	Priority int      `json:"priority"`
+	Active   bool     `json:"active"`
+	Peers    []HAPeer `json:"peers"`
+}
+
+// HAElector elects the active instance among the peers exchanging UDP
+// heartbeats (HA_MODE=heartbeat). The alive instance with the highest
+// HA_PRIORITY (then HA_NODE) becomes active when no peer is active; an
+// active instance keeps the role until it stops sending heartbeats for
+// HA_DEAD_INTERVAL unless HA_PREEMPT is enabled. When two instances are
+// active (e.g. after a network partition) the lower ranked one steps down.
+type HAElector struct {
+	cfg      *HAConfig
+	active   atomic.Bool
+	peers    map[string]*HAPeer // by node
+	conn     net.PacketConn
+	addrs    []*net.UDPAddr
+	mutex    sync.Mutex // guards peers, conn and addrs
+	evalmu   sync.Mutex // serializes the elections
+	handlers []func(active bool)
+}
+
+func NewHAElector(cfg *HAConfig) *HAElector {
+	return &HAElector{
+		cfg:   cfg,
+		peers: make(map[string]*HAPeer),
+	}
+}
+
+// OnChange registers f called with the new state on every transition.
+// f is called from the elector and must not block.
+func (e *HAElector) OnChange(f func(active bool)) {
+	e.handlers = append(e.handlers, f)
+}
+
+func (e *HAElector) IsActive() bool {
+	return e.active.Load()
+}
+
+func (e *HAElector) Status() *HAStatus {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	status := &HAStatus{
+		Node:     e.cfg.node,
+		Priority: e.cfg.priority,
+		Active:   e.IsActive(),
+		Peers:    []HAPeer{},
+	}
+	for _, p := range e.peers {
+		status.Peers = append(status.Peers, *p)
+	}
+	sort.Slice(status.Peers, func(i, j int) bool {
+		return status.Peers[i].Node < status.Peers[j].Node
+	})
+	return status
+}
+
+// outranks reports whether (prio1, node1) wins over (prio2, node2)
+func outranks(prio1 int, node1 string, prio2 int, node2 string) bool {
+	if prio1 != prio2 {
+		return prio1 > prio2
+	}
+	return node1 > node2
+}
+
+func (e *HAElector) setActive(active bool, reason string) {
+	if e.active.Swap(active) == active {
+		return
+	}
+	if active {
+		halog.With("node", e.cfg.node).Info("This instance is now ACTIVE (%s)", reason)
+		metricHAActive.Set(1)
+	} else {
+		halog.With("node", e.cfg.node).Info("This instance is now STANDBY (%s)", reason)
+		metricHAActive.Set(0)
+	}
+	metricHATransitions.Inc()
+	// let the peers know immediately
+	e.sendHeartbeats(false)
+	for _, f := range e.handlers {
+		f(active)
+	}
+}
+
+func (e *HAElector) encode(hb *haHeartbeat) []byte {
+	data, _ := json.Marshal(hb)
+	h := hmac.New(sha256.New, []byte(e.cfg.secret))
+	h.Write(data)
+	mac := hex.EncodeToString(h.Sum(nil))
+	return []byte(haMagic + " " + mac + " " + string(data))
+}
+
+func (e *HAElector) decode(packet []byte) (*haHeartbeat, error) {
+	parts := bytes.SplitN(packet, []byte(" "), 3)
+	if len(parts) != 3 || string(parts[0]) != haMagic {
+		return nil, fmt.Errorf("not a heartbeat")
+	}
+	h := hmac.New(sha256.New, []byte(e.cfg.secret))
+	h.Write(parts[2])
+	mac, err := hex.DecodeString(string(parts[1]))
+	if err != nil || !hmac.Equal(mac, h.Sum(nil)) {
+		return nil, fmt.Errorf("invalid signature (check HA_SECRET)")
+	}
+	var hb haHeartbeat
+	if err := json.Unmarshal(parts[2], &hb); err != nil {
+		return nil, err
+	}
+	// replayed heartbeats are rejected
+	skew := time.Since(time.UnixMilli(hb.Time))
+	if skew > haMaxClockSkew || skew < -haMaxClockSkew {
+		return nil, fmt.Errorf("heartbeat is too old or too new (check the clocks)")
+	}
+	return &hb, nil
+}
+
+// sendHeartbeats tells the peers the current state. resign announces that
+// this instance is leaving so that a standby takes over without waiting.
+func (e *HAElector) sendHeartbeats(resign bool) {
+	e.mutex.Lock()
+	conn, addrs := e.conn, e.addrs
+	e.mutex.Unlock()
+	if conn == nil {
+		return
+	}
+	hb := &haHeartbeat{
+		Node:     e.cfg.node,
+		Priority: e.cfg.priority,
+		Active:   e.IsActive() && !resign,
+		Time:     time.Now().UnixMilli(),
+	}
+	if resign {
+		hb.Priority = 0
+	}
+	packet := e.encode(hb)
+	for _, addr := range addrs {
+		if _, err := conn.WriteTo(packet, addr); err != nil {
+			halog.Trace("failed to send heartbeat to %s: %s", addr, err)
+		}
+	}
+}
+
+func (e *HAElector) receive(packet []byte, from net.Addr) {
+	hb, err := e.decode(packet)
+	if err != nil {
+		halog.Warning("Ignoring a heartbeat from %s: %s", from, err)
+		return
+	}
+	if hb.Node == e.cfg.node {
+		halog.Warning("Received a heartbeat with my own HA_NODE (%s) from %s. HA_NODE must be unique", hb.Node, from)
+		return
+	}
+	e.mutex.Lock()
+	p, ok := e.peers[hb.Node]
+	if !ok {
+		halog.Info("Found HA peer %s at %s (priority=%d active=%v)", hb.Node, from, hb.Priority, hb.Active)
+		p = &HAPeer{Node: hb.Node}
+		e.peers[hb.Node] = p
+	}
+	changed := p.Active != hb.Active || p.Priority != hb.Priority
+	p.Addr = from.String()
+	p.Priority = hb.Priority
+	p.Active = hb.Active
+	p.LastSeen = time.Now()
+	e.mutex.Unlock()
+	if changed {
+		// react to a resignation or a conflict without waiting for the tick
+		e.evaluate(time.Now(), false)
+	}
+}
+
+// evaluate runs the election. learning suppresses becoming active before
+// the peers had a chance to announce themselves.
+func (e *HAElector) evaluate(now time.Time, learning bool) {
+	e.evalmu.Lock()
+	defer e.evalmu.Unlock()
+	e.mutex.Lock()
+	var alive []HAPeer
+	for _, p := range e.peers {
+		if now.Sub(p.LastSeen) <= e.cfg.deadInterval {
+			alive = append(alive, *p)
+		}
+	}
+	e.mutex.Unlock()
+
+	node, prio := e.cfg.node, e.cfg.priority
+	if e.IsActive() {
+		for _, p := range alive {
+			if p.Active && outranks(p.Priority, p.Node, prio, node) {
+				e.setActive(false, fmt.Sprintf("%s with higher priority is active", p.Node))
+				return
+			}
+		}
+		return
+	}
+
+	var activePeer *HAPeer
+	for i, p := range alive {
+		if p.Active {
+			activePeer = &alive[i]
+			break
+		}
+	}
+	if activePeer != nil {
+		if e.cfg.preempt && outranks(prio, node, activePeer.Priority, activePeer.Node) {
+			e.setActive(true, fmt.Sprintf("preempting %s", activePeer.Node))
+		}
+		return
+	}
+	if learning {
+		return
+	}
+	for _, p := range alive {
+		if outranks(p.Priority, p.Node, prio, node) {
+			// the peer takes over
+			return
+		}
+	}
+	if len(alive) == 0 {
+		e.setActive(true, "no peer is alive")
+	} else {
+		e.setActive(true, "no peer is active")
+	}
+}
+
+// Work exchanges heartbeats until ctx is done. On exit this instance
+// resigns so that a standby peer takes over immediately.
+func (e *HAElector) Work(ctx context.Context) error {
+	var addrs []*net.UDPAddr
+	for _, peer := range e.cfg.peers {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return fmt.Errorf("failed to resolve HA peer %s: %s", peer, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	conn, err := net.ListenPacket("udp", e.cfg.listen)
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	e.conn, e.addrs = conn, addrs
+	e.mutex.Unlock()
+	halog.Info("Exchanging heartbeats on %s with %+v as %s (priority=%d)", conn.LocalAddr(), e.cfg.peers, e.cfg.node, e.cfg.priority)
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			e.receive(buf[:n], from)
+		}
+	}()
+	defer conn.Close()
+
+	start := time.Now()
+	ticker := time.NewTicker(e.cfg.interval)
+	defer ticker.Stop()
+	e.sendHeartbeats(false)
+	for {
+		select {
+		case <-ctx.Done():
+			if e.IsActive() {
+				e.sendHeartbeats(true)
+				e.setActive(false, "shutting down")
+			}
+			return ctx.Err()
+		case now := <-ticker.C:
+			e.sendHeartbeats(false)
+			e.evaluate(now, now.Sub(start) < e.cfg.deadInterval)
+		}
+	}
+}
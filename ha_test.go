@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/rosapitest"
+)
+
+type fakeLeadership bool
+
+func (l fakeLeadership) IsActive() bool {
+	return bool(l)
+}
+
+type countingHandler struct {
+	events atomic.Int32
+}
+
+func (h *countingHandler) HandleRouterInfo(ev RouterInfoEvent) {
+	h.events.Add(1)
+}
+
+func TestHA(t *testing.T) {
+	newElector := func(node string, priority int, port int, peerPort int, preempt bool) *HAElector {
+		return NewHAElector(&HAConfig{
+			mode:         "heartbeat",
+			node:         node,
+			priority:     priority,
+			listen:       fmt.Sprintf("127.0.0.1:%d", port),
+			peers:        []string{fmt.Sprintf("127.0.0.1:%d", peerPort)},
+			secret:       "secret",
+			interval:     time.Millisecond * 50,
+			deadInterval: time.Millisecond * 150,
+			preempt:      preempt,
+		})
+	}
+	start := func(e *HAElector) context.CancelFunc {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() { _ = e.Work(ctx) }()
+		return cancel
+	}
+	waitFor := func(what string, cond func() bool) {
+		deadline := time.Now().Add(time.Second * 2)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	// the higher priority becomes active
+	a := newElector("a", 200, 19547, 19548, false)
+	b := newElector("b", 100, 19548, 19547, false)
+	var takeovers atomic.Int32
+	b.OnChange(func(active bool) {
+		if active {
+			takeovers.Add(1)
+		}
+	})
+	stopA := start(a)
+	stopB := start(b)
+	defer stopB()
+	waitFor("a to be active", a.IsActive)
+	time.Sleep(time.Millisecond * 300)
+	if b.IsActive() {
+		t.Fatalf("both instances are active")
+	}
+	if st := b.Status(); len(st.Peers) != 1 || !st.Peers[0].Active || st.Peers[0].Node != "a" {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+
+	// a resigns and b takes over without waiting for the dead interval
+	stopA()
+	waitFor("b to take over", b.IsActive)
+	if takeovers.Load() != 1 {
+		t.Fatalf("OnChange was called %d times", takeovers.Load())
+	}
+
+	// a comes back without preemption: b keeps the role
+	stopA = start(a)
+	time.Sleep(time.Millisecond * 400)
+	if a.IsActive() || !b.IsActive() {
+		t.Fatalf("the role moved without HA_PREEMPT (a=%v b=%v)", a.IsActive(), b.IsActive())
+	}
+	stopA()
+	time.Sleep(time.Millisecond * 100)
+
+	// with preemption a takes the role back
+	a = newElector("a", 200, 19547, 19548, true)
+	stopA = start(a)
+	defer stopA()
+	waitFor("a to preempt", func() bool { return a.IsActive() && !b.IsActive() })
+
+	// heartbeats signed with another secret are rejected
+	c := NewHAElector(&HAConfig{node: "c", secret: "other"})
+	packet := a.encode(&haHeartbeat{Node: "a", Priority: 200, Time: time.Now().UnixMilli()})
+	if _, err := c.decode(packet); err == nil {
+		t.Fatalf("accepted a heartbeat with a wrong signature")
+	}
+	if _, err := b.decode(packet); err != nil {
+		t.Fatalf("rejected a valid heartbeat: %s", err)
+	}
+	old := a.encode(&haHeartbeat{Node: "a", Priority: 200, Time: time.Now().Add(-time.Hour).UnixMilli()})
+	if _, err := b.decode(old); err == nil {
+		t.Fatalf("accepted a replayed heartbeat")
+	}
+	unsigned := []byte(haMagic + " - " + `{"node":"x","priority":255,"active":true}`)
+	if _, err := b.decode(unsigned); err == nil {
+		t.Fatalf("accepted an unsigned heartbeat")
+	}
+
+	// HA_SECRET is required
+	t.Setenv("HA_MODE", "heartbeat")
+	t.Setenv("HA_NODE", "a")
+	t.Setenv("HA_PEERS", "127.0.0.1:9547")
+	if _, err := loadHAConfig(); err == nil {
+		t.Fatalf("HA_MODE=heartbeat without HA_SECRET was accepted")
+	}
+	t.Setenv("HA_SECRET", "secret")
+	if _, err := loadHAConfig(); err != nil {
+		t.Fatalf("loadHAConfig failed: %s", err)
+	}
+
+	// a standby RAClient neither reconciles nor notifies
+	rac := NewRAClient(&RAConfig{mode: "ros"}, NewFakeRouter())
+	handler := &countingHandler{}
+	rac.AddHandler(handler)
+	rac.SetLeadership(fakeLeadership(false))
+	rac.reconcile(context.Background(), "test")
+	rac.notify("changed", nil, nil)
+	if rac.LastReconcile() != nil || handler.events.Load() != 0 {
+		t.Fatalf("standby instance reconciled or notified")
+	}
+	rac.SetLeadership(fakeLeadership(true))
+	rac.notify("changed", nil, nil)
+	if handler.events.Load() != 1 {
+		t.Fatalf("active instance did not notify")
+	}
+
+	// nor cleans up the objects managed by the active peer
+	fake := NewFakeRouter()
+	rac = NewRAClient(&RAConfig{mode: "ros", rosExtIf: "vlanflets", rosGC: "off", cleanupOnExit: "remove"}, fake)
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+	rac.reconcile(context.Background(), "solicited")
+	rac.SetLeadership(fakeLeadership(false))
+	rac.Cleanup(context.Background())
+	if rows := fake.Rows("/ipv6/route"); len(rows) != 1 {
+		t.Fatalf("standby instance cleaned up the route: %+v", rows)
+	}
+
+	// but a dry run plans on standby
+	srv, err := rosapitest.NewServer("fletsv6", "password")
+	if err != nil {
+		t.Fatalf("rosapitest.NewServer failed: %s", err)
+	}
+	defer srv.Close()
+	rosc, err := NewROSClient(ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", dryRun: "exit"})
+	if err != nil {
+		t.Fatalf("NewROSClient failed: %s", err)
+	}
+	defer rosc.Close()
+	rac = NewRAClient(&RAConfig{mode: "ros", rosExtIf: "vlanflets", rosGC: "off"}, rosc)
+	rac.SetLeadership(fakeLeadership(false))
+	rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+	rac.reconcile(context.Background(), "solicited")
+	if r := rac.LastReconcile(); r == nil || r.Changes != 0 || r.Planned != 1 || srv.CommandCount("/ipv6/route/add") != 0 {
+		t.Fatalf("unexpected dry-run reconcile on standby: %+v", r)
+	}
+}
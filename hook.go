@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+type HookConfig struct {
+	execs    []string
+	webhooks []string
+	timeout  time.Duration
+	retries  int
+}
+
+type HookRunner struct {
+	cfg    *HookConfig
+	client *http.Client
+	queue  chan RouterInfoEvent
+}
+
+type hookRouterInfo struct {
+	Prefix  string `json:"prefix"`
+	Gateway string `json:"gateway"`
+}
+
+type hookPayload struct {
+	Event     string          `json:"event"`
+	Timestamp time.Time       `json:"timestamp"`
+	Old       *hookRouterInfo `json:"old"`
+	New       *hookRouterInfo `json:"new"`
+}
+
+func dumpHookConfig(cfg *HookConfig) {
+	hooklog.Debug("Hook Configuration:")
+	if len(cfg.execs) > 0 {
+		hooklog.Debug("  HOOK_EXEC=%+v", cfg.execs)
+	}
+	if len(cfg.webhooks) > 0 {
+		hooklog.Debug("  HOOK_WEBHOOKS=%+v", cfg.webhooks)
+	}
+	hooklog.Debug("  HOOK_TIMEOUT=%d", cfg.timeout/time.Millisecond)
+	hooklog.Debug("  HOOK_RETRIES=%d", cfg.retries)
+}
+
+func (cfg *HookConfig) Enabled() bool {
+	return len(cfg.execs) > 0 || len(cfg.webhooks) > 0
+}
+
+func NewHookRunner(cfg *HookConfig) *HookRunner {
+	return &HookRunner{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.timeout},
+		queue:  make(chan RouterInfoEvent, 16),
+	}
+}
+
+// HandleRouterInfo queues the event so that slow hooks never block the RA worker.
+func (h *HookRunner) HandleRouterInfo(ev RouterInfoEvent) {
+	select {
+	case h.queue <- ev:
+	default:
+		hooklog.Warning("hook queue is full. dropping %s event (prefix=%s)", ev.Reason, ev.New.prefix.String())
+	}
+}
+
+func (h *HookRunner) Work(ctx context.Context) error {
+	for {
+		select {
+		case ev := <-h.queue:
+			h.run(ctx, ev)
+		case <-ctx.Done():
+			return fmt.Errorf("canceled by context")
+		}
+	}
+}
+
+func (h *HookRunner) run(ctx context.Context, ev RouterInfoEvent) {
+	for _, path := range h.cfg.execs {
+		if err := h.runExec(ctx, path, ev); err != nil {
+			hooklog.Warning("hook %s failed: %s", path, err)
+		}
+	}
+	if len(h.cfg.webhooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(makeHookPayload(ev))
+	if err != nil {
+		hooklog.Warning("failed to encode hook payload: %s", err)
+		return
+	}
+	for _, url := range h.cfg.webhooks {
+		if err := h.postWebhook(ctx, url, body); err != nil {
+			hooklog.Warning("webhook %s failed: %s", url, err)
+		}
+	}
+}
+
+func (h *HookRunner) runExec(ctx context.Context, path string, ev RouterInfoEvent) error {
+	hooklog.Debug("Running hook %s (event=%s)", path, ev.Reason)
+	ctx, cancel := context.WithTimeout(ctx, h.cfg.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(), hookEnv(ev)...)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		hooklog.Debug("  output of %s: %s", path, bytes.TrimSpace(out))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %dms", h.cfg.timeout/time.Millisecond)
+	}
+	return err
+}
+
+func (h *HookRunner) postWebhook(ctx context.Context, url string, body []byte) error {
+	var err error
+	wait := time.Second
+	for i := 0; i <= h.cfg.retries; i++ {
+		if i > 0 {
+			hooklog.Debug("  retrying webhook %s in %s (%d/%d)", url, wait, i, h.cfg.retries)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return fmt.Errorf("canceled by context")
+			}
+			wait *= 2
+		}
+		err = func() error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			res, err := h.client.Do(req)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+			if res.StatusCode < 200 || res.StatusCode >= 300 {
+				return fmt.Errorf("unexpected status %s", res.Status)
+			}
+			return nil
+		}()
+		if err == nil {
+			hooklog.Debug("Posted hook event to %s", url)
+			return nil
+		}
+	}
+	return err
+}
+
+func hookEnv(ev RouterInfoEvent) []string {
+	env := []string{fmt.Sprintf("FLETSV6_EVENT=%s", ev.Reason)}
+	if ev.Old != nil {
+		env = append(env,
+			fmt.Sprintf("FLETSV6_OLD_PREFIX=%s", ev.Old.prefix.String()),
+			fmt.Sprintf("FLETSV6_OLD_GATEWAY=%s", ev.Old.gateway.String()),
+		)
+	}
+	if ev.New != nil {
+		env = append(env,
+			fmt.Sprintf("FLETSV6_NEW_PREFIX=%s", ev.New.prefix.String()),
+			fmt.Sprintf("FLETSV6_NEW_GATEWAY=%s", ev.New.gateway.String()),
+		)
+	}
+	return env
+}
+
+func makeHookPayload(ev RouterInfoEvent) hookPayload {
+	conv := func(info *RouterInfo) *hookRouterInfo {
+		if info == nil {
+			return nil
+		}
+		return &hookRouterInfo{
+			Prefix:  info.prefix.String(),
+			Gateway: info.gateway.String(),
+		}
+	}
+	return hookPayload{
+		Event:     ev.Reason,
+		Timestamp: ev.Time,
+		Old:       conv(ev.Old),
+		New:       conv(ev.New),
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHook(t *testing.T) {
+	var attempts, failures atomic.Int32
+	var delay atomic.Int64
+	payloads := make(chan hookPayload, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		time.Sleep(time.Duration(delay.Load()))
+		if failures.Add(-1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var p hookPayload
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&p) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		payloads <- p
+	}))
+	defer srv.Close()
+
+	h := NewHookRunner(&HookConfig{webhooks: []string{srv.URL}, timeout: time.Millisecond * 300, retries: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = h.Work(ctx) }()
+	receive := func() hookPayload {
+		select {
+		case p := <-payloads:
+			return p
+		case <-time.After(time.Second * 5):
+			t.Fatalf("no webhook received")
+		}
+		return hookPayload{}
+	}
+
+	_, n1, _ := net.ParseCIDR("2001:db8:1::/64")
+	_, n2, _ := net.ParseCIDR("2001:db8:2::/64")
+	old := &RouterInfo{prefix: *n1, gateway: net.ParseIP("fe80::1")}
+	cur := &RouterInfo{prefix: *n2, gateway: net.ParseIP("fe80::2")}
+	h.HandleRouterInfo(RouterInfoEvent{Reason: "solicited", Time: time.Now(), New: old})
+	if p := receive(); p.Event != "solicited" || p.Old != nil || p.New == nil ||
+		p.New.Prefix != "2001:db8:1::/64" || p.New.Gateway != "fe80::1" || p.Timestamp.IsZero() {
+		t.Fatalf("unexpected solicited payload: %+v", p)
+	}
+	h.HandleRouterInfo(RouterInfoEvent{Reason: "changed", Time: time.Now(), Old: old, New: cur})
+	if p := receive(); p.Event != "changed" || p.Old == nil || p.New == nil ||
+		p.Old.Prefix != "2001:db8:1::/64" || p.New.Prefix != "2001:db8:2::/64" || p.New.Gateway != "fe80::2" {
+		t.Fatalf("unexpected changed payload: %+v", p)
+	}
+
+	// 5xx is retried
+	attempts.Store(0)
+	failures.Store(1)
+	h.HandleRouterInfo(RouterInfoEvent{Reason: "changed", Time: time.Now(), Old: old, New: cur})
+	if p := receive(); p.Event != "changed" || attempts.Load() != 2 {
+		t.Fatalf("webhook was not retried after 5xx (attempts=%d)", attempts.Load())
+	}
+
+	// a slow endpoint times out on every attempt
+	attempts.Store(0)
+	failures.Store(0)
+	delay.Store(int64(time.Second))
+	start := time.Now()
+	if err := h.postWebhook(context.Background(), srv.URL, []byte("{}")); err == nil {
+		t.Fatalf("slow webhook did not time out")
+	}
+	// 2 attempts of 300ms and a wait of 1s
+	if d := time.Since(start); d > time.Second*2 || attempts.Load() != 2 {
+		t.Fatalf("webhook took %s in %d attempts", d, attempts.Load())
+	}
+}
@@ -11,6 +11,9 @@ import (
 
 var vlanmutex sync.Mutex
 
+// vlan devices created by the companion, deleted on exit
+var createdVlans []string
+
 type DecodedInterface struct {
 	name string
 	vlan int
@@ -66,7 +69,24 @@ func (i DecodedInterface) PrepareVLAN() error {
 	} else if result == 2 {
 		return nil
 	}
-	return createVlanDev(i.name, i.ActualName(), i.vlan)
+	if err := createVlanDev(i.name, i.ActualName(), i.vlan); err != nil {
+		return err
+	}
+	createdVlans = append(createdVlans, i.ActualName())
+	return nil
+}
+
+// cleanupVlanDevs deletes the vlan devices created by PrepareVLAN
+func cleanupVlanDevs() {
+	vlanmutex.Lock()
+	defer vlanmutex.Unlock()
+	for _, name := range createdVlans {
+		llog.Debug("Deleting vlan device %s", name)
+		if err := deleteVlanDev(name); err != nil {
+			llog.Warning("failed to delete vlan device %s: %s", name, err)
+		}
+	}
+	createdVlans = nil
 }
 
 func (i DecodedInterface) Index() (int, error) {
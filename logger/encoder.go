@@ -0,0 +1,165 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encoder formats a record as a single line (including the newline)
+type Encoder interface {
+	Encode(r *Record) []byte
+}
+
+// NewEncoder returns the encoder for LOG_FORMAT (text, json or logfmt)
+func NewEncoder(format string) (Encoder, error) {
+	switch format {
+	case "", "text":
+		return TextEncoder{}, nil
+	case "json":
+		return JSONEncoder{}, nil
+	case "logfmt":
+		return LogfmtEncoder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+}
+
+var textLevelTags = map[int]string{
+	FATAL:   "[FATAL] ",
+	ERROR:   "[ERROR] ",
+	WARNING: "[WARN]  ",
+	INFO:    "[INFO]  ",
+	DEBUG:   "[DEBUG] ",
+	TRACE:   "[TRACE] ",
+}
+
+// TextEncoder writes the traditional human readable lines:
+//
+//	2006/01/02 15:04:05 [DEBUG] nd: nd.go:123: message key=value
+type TextEncoder struct{}
+
+func (TextEncoder) Encode(r *Record) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	buf.WriteString(textLevelTags[r.Level])
+	if r.Subsystem != "" {
+		buf.WriteString(r.Subsystem + ": ")
+	}
+	if r.Caller != "" {
+		buf.WriteString(r.Caller + ": ")
+	}
+	buf.WriteString(strings.TrimSuffix(r.Message, "\n"))
+	for _, f := range r.Fields {
+		buf.WriteByte(' ')
+		writeLogfmtPair(&buf, f.Key, formatFieldValue(f.Value))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+// JSONEncoder writes one JSON object per line
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(r *Record) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`{"time":`)
+	writeJSONValue(&buf, r.Time.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(&buf, strings.ToLower(LevelName(r.Level)))
+	if r.Subsystem != "" {
+		buf.WriteString(`,"subsystem":`)
+		writeJSONValue(&buf, r.Subsystem)
+	}
+	if r.Caller != "" {
+		buf.WriteString(`,"caller":`)
+		writeJSONValue(&buf, r.Caller)
+	}
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(&buf, r.Message)
+	for _, f := range r.Fields {
+		buf.WriteByte(',')
+		writeJSONValue(&buf, f.Key)
+		buf.WriteByte(':')
+		writeJSONValue(&buf, jsonFieldValue(f.Value))
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func writeJSONValue(buf *bytes.Buffer, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(data)
+}
+
+// jsonFieldValue keeps numbers and booleans and stringifies the rest
+// (net.IP, net.HardwareAddr, errors, ...)
+func jsonFieldValue(v any) any {
+	switch v := v.(type) {
+	case nil, bool, string,
+		int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64,
+		float32, float64:
+		return v
+	default:
+		return formatFieldValue(v)
+	}
+}
+
+// LogfmtEncoder writes key=value pairs
+// (https://brandur.org/logfmt)
+type LogfmtEncoder struct{}
+
+func (LogfmtEncoder) Encode(r *Record) []byte {
+	var buf bytes.Buffer
+	writeLogfmtPair(&buf, "time", r.Time.Format(time.RFC3339Nano))
+	buf.WriteByte(' ')
+	writeLogfmtPair(&buf, "level", strings.ToLower(LevelName(r.Level)))
+	if r.Subsystem != "" {
+		buf.WriteByte(' ')
+		writeLogfmtPair(&buf, "subsystem", r.Subsystem)
+	}
+	if r.Caller != "" {
+		buf.WriteByte(' ')
+		writeLogfmtPair(&buf, "caller", r.Caller)
+	}
+	buf.WriteByte(' ')
+	writeLogfmtPair(&buf, "msg", r.Message)
+	for _, f := range r.Fields {
+		buf.WriteByte(' ')
+		writeLogfmtPair(&buf, f.Key, formatFieldValue(f.Value))
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func writeLogfmtPair(buf *bytes.Buffer, key string, value string) {
+	buf.WriteString(key)
+	buf.WriteByte('=')
+	if value == "" || strings.ContainsAny(value, " =\"\\\n\t") {
+		buf.WriteString(strconv.Quote(value))
+	} else {
+		buf.WriteString(value)
+	}
+}
+
+func formatFieldValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
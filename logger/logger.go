@@ -3,126 +3,301 @@ package logger
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const totalStep = 5
 
 const (
-	ERROR = iota + 1
+	FATAL = iota
+	ERROR
 	WARNING
 	INFO
 	DEBUG
 	TRACE
 )
 
+var levelNames = map[int]string{
+	FATAL:   "FATAL",
+	ERROR:   "ERROR",
+	WARNING: "WARNING",
+	INFO:    "INFO",
+	DEBUG:   "DEBUG",
+	TRACE:   "TRACE",
+}
+
 func SetLogLevel() int {
-	logLevel := os.Getenv("LOG_LEVEL")
-	switch logLevel {
-	case "INFO", "info":
+	return ParseLevel(os.Getenv("LOG_LEVEL"))
+}
+
+// ParseLevel converts a LOG_LEVEL value into a level (INFO if unknown)
+func ParseLevel(logLevel string) int {
+	level, err := parseLevelName(logLevel)
+	if err != nil {
 		return INFO
-	case "DEBUG", "debug":
-		return DEBUG
-	case "TRACE", "trace":
-		return TRACE
-	case "ERROR", "error":
-		return ERROR
-	case "WARNING", "warning":
-		return WARNING
+	}
+	return level
+}
+
+func parseLevelName(name string) (int, error) {
+	switch strings.ToUpper(name) {
+	case "INFO":
+		return INFO, nil
+	case "DEBUG":
+		return DEBUG, nil
+	case "TRACE":
+		return TRACE, nil
+	case "ERROR":
+		return ERROR, nil
+	case "WARNING", "WARN":
+		return WARNING, nil
 	default:
-		return INFO
+		return 0, fmt.Errorf("unknown log level: %s", name)
 	}
 }
 
+// LevelName returns the name of level (e.g. "INFO")
+func LevelName(level int) string {
+	if name, ok := levelNames[level]; ok {
+		return name
+	}
+	return fmt.Sprintf("LEVEL(%d)", level)
+}
+
+// Levels is the default level and the overrides per subsystem
+type Levels struct {
+	Default    int
+	Subsystems map[string]int
+}
+
+// ParseLevels parses "INFO,nd=TRACE,ros=INFO". The entry without a subsystem
+// is the default level (INFO if omitted).
+func ParseLevels(spec string) (Levels, error) {
+	levels := Levels{Default: INFO, Subsystems: map[string]int{}}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			level, err := parseLevelName(entry)
+			if err != nil {
+				return levels, err
+			}
+			levels.Default = level
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return levels, fmt.Errorf("missing subsystem name: %s", entry)
+		}
+		level, err := parseLevelName(strings.TrimSpace(value))
+		if err != nil {
+			return levels, err
+		}
+		levels.Subsystems[name] = level
+	}
+	return levels, nil
+}
+
+func (l Levels) String() string {
+	entries := []string{LevelName(l.Default)}
+	var names []string
+	for name := range l.Subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entries = append(entries, name+"="+LevelName(l.Subsystems[name]))
+	}
+	return strings.Join(entries, ",")
+}
+
+// Field is a key/value pair attached to log records
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Record is a single log event passed to the sinks
+type Record struct {
+	Time      time.Time
+	Level     int
+	Subsystem string
+	Caller    string // file:line for TRACE, DEBUG, ERROR and FATAL
+	Message   string
+	Fields    []Field
+}
+
+// core is shared by a logger and all the loggers derived from it
+type core struct {
+	level  atomic.Int32
+	levels atomic.Pointer[map[string]int] // per subsystem
+	sinks  []Sink
+	mutex  sync.Mutex // guards sinks and serializes writes
+}
+
+// BuiltinLogger writes printf-style messages with optional fields to the
+// sinks (stdout in text format by default).
 type BuiltinLogger struct {
-	logger *log.Logger
-	level  int
+	core      *core
+	subsystem string
+	fields    []Field
 }
 
 func NewBuiltinLogger() *BuiltinLogger {
+	l := &BuiltinLogger{
+		core: &core{
+			sinks: []Sink{NewWriterSink(os.Stdout, TextEncoder{})},
+		},
+	}
+	l.SetLevel(SetLogLevel())
+	return l
+}
+
+// Named returns a logger for the subsystem sharing the level and the sinks
+func (l *BuiltinLogger) Named(subsystem string) *BuiltinLogger {
 	return &BuiltinLogger{
-		logger: log.Default(),
-		level:  SetLogLevel(),
+		core:      l.core,
+		subsystem: subsystem,
+		fields:    l.fields,
 	}
 }
 
-func (l *BuiltinLogger) Trace(format string, args ...any) {
-	if l.level >= TRACE {
-		l.logger.SetOutput(os.Stdout)
-		l.logger.SetFlags(log.Ldate | log.Ltime)
-
-		_, file, line, ok := runtime.Caller(1)
-		if ok {
-			caller := fmt.Sprintf("%s:%d: ", filepath.Base(file), line)
-			l.logger.Printf("[TRACE] "+caller+format, args...)
-		} else {
-			l.logger.Printf("[TRACE] "+format, args...)
-		}
+// With returns a logger adding the key/value pairs to every record
+// (e.g. With("interface", ifname, "target", ip))
+func (l *BuiltinLogger) With(keyvals ...any) *BuiltinLogger {
+	fields := make([]Field, len(l.fields), len(l.fields)+len(keyvals)/2)
+	copy(fields, l.fields)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		fields = append(fields, Field{Key: fmt.Sprint(keyvals[i]), Value: keyvals[i+1]})
+	}
+	return &BuiltinLogger{
+		core:      l.core,
+		subsystem: l.subsystem,
+		fields:    fields,
 	}
 }
 
-func (l *BuiltinLogger) Debug(format string, args ...any) {
-	if l.level >= DEBUG {
-		l.logger.SetOutput(os.Stdout)
-		l.logger.SetFlags(log.Ldate | log.Ltime)
-
-		_, file, line, ok := runtime.Caller(1)
-		if ok {
-			caller := fmt.Sprintf("%s:%d: ", filepath.Base(file), line)
-			l.logger.Printf("[DEBUG] "+caller+format, args...)
-		} else {
-			l.logger.Printf("[DEBUG] "+format, args...)
-		}
+// SetLevel changes the default level (safe to call while logging)
+func (l *BuiltinLogger) SetLevel(level int) {
+	l.core.level.Store(int32(level))
+}
+
+// SetLevels changes the default level and replaces the levels per subsystem
+func (l *BuiltinLogger) SetLevels(levels Levels) {
+	subsystems := make(map[string]int, len(levels.Subsystems))
+	for name, level := range levels.Subsystems {
+		subsystems[name] = level
 	}
+	l.core.levels.Store(&subsystems)
+	l.SetLevel(levels.Default)
 }
 
-func (l *BuiltinLogger) Info(format string, args ...any) {
-	if l.level >= INFO {
-		l.logger.SetOutput(os.Stdout)
-		format = "[INFO]  " + format
-		l.logger.SetFlags(log.Ldate | log.Ltime)
-		l.logger.Printf(format, args...)
+// Levels returns the current levels
+func (l *BuiltinLogger) Levels() Levels {
+	levels := Levels{Default: int(l.core.level.Load()), Subsystems: map[string]int{}}
+	if m := l.core.levels.Load(); m != nil {
+		for name, level := range *m {
+			levels.Subsystems[name] = level
+		}
 	}
+	return levels
 }
 
-func (l *BuiltinLogger) Warning(format string, args ...any) {
-	if l.level >= WARNING {
-		l.logger.SetOutput(os.Stdout)
-		format = "[WARN]  " + format
-		l.logger.SetFlags(log.Ldate | log.Ltime)
-		l.logger.Printf(format, args...)
+// Enabled reports whether records of level are written by this logger
+func (l *BuiltinLogger) Enabled(level int) bool {
+	if l.subsystem != "" {
+		if m := l.core.levels.Load(); m != nil {
+			if sl, ok := (*m)[l.subsystem]; ok {
+				return level <= sl
+			}
+		}
 	}
+	return level <= int(l.core.level.Load())
 }
 
-func (l *BuiltinLogger) Error(format string, args ...any) {
-	if l.level >= ERROR {
-		l.logger.SetOutput(os.Stdout)
-		l.logger.SetFlags(log.Ldate | log.Ltime)
-
-		_, file, line, ok := runtime.Caller(1)
-		if ok {
-			caller := fmt.Sprintf("%s:%d: ", filepath.Base(file), line)
-			l.logger.Printf("[ERROR] "+caller+format, args...)
-		} else {
-			l.logger.Printf("[ERROR] "+format, args...)
+// SetSinks replaces the sinks and closes the ones no longer used
+func (l *BuiltinLogger) SetSinks(sinks ...Sink) {
+	l.core.mutex.Lock()
+	old := l.core.sinks
+	l.core.sinks = sinks
+	l.core.mutex.Unlock()
+
+next:
+	for _, s := range old {
+		for _, n := range sinks {
+			if s == n {
+				continue next
+			}
 		}
+		_ = s.Close()
 	}
 }
 
-func (l *BuiltinLogger) Fatal(format string, args ...any) {
-	if l.level >= ERROR {
-		l.logger.SetOutput(os.Stdout)
-		l.logger.SetFlags(log.Ldate | log.Ltime)
-
-		_, file, line, ok := runtime.Caller(1)
-		if ok {
-			caller := fmt.Sprintf("%s:%d: ", filepath.Base(file), line)
-			l.logger.Fatalf("[FATAL] "+caller+format, args...)
-		} else {
-			l.logger.Fatalf("[FATAL] "+format, args...)
+// Close closes the sinks. Records logged afterwards are discarded.
+func (l *BuiltinLogger) Close() {
+	l.SetSinks()
+}
+
+func (l *BuiltinLogger) log(level int, withCaller bool, format string, args []any) {
+	if !l.Enabled(level) {
+		return
+	}
+	r := &Record{
+		Time:      time.Now(),
+		Level:     level,
+		Subsystem: l.subsystem,
+		Message:   fmt.Sprintf(format, args...),
+		Fields:    l.fields,
+	}
+	if withCaller {
+		// log <- Trace/Debug/... <- caller
+		if _, file, line, ok := runtime.Caller(2); ok {
+			r.Caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+		}
+	}
+
+	l.core.mutex.Lock()
+	defer l.core.mutex.Unlock()
+	for _, s := range l.core.sinks {
+		if err := s.Write(r); err != nil {
+			fmt.Fprintf(os.Stderr, "logger: %s\n", err)
 		}
 	}
 }
+
+func (l *BuiltinLogger) Trace(format string, args ...any) {
+	l.log(TRACE, true, format, args)
+}
+
+func (l *BuiltinLogger) Debug(format string, args ...any) {
+	l.log(DEBUG, true, format, args)
+}
+
+func (l *BuiltinLogger) Info(format string, args ...any) {
+	l.log(INFO, false, format, args)
+}
+
+func (l *BuiltinLogger) Warning(format string, args ...any) {
+	l.log(WARNING, false, format, args)
+}
+
+func (l *BuiltinLogger) Error(format string, args ...any) {
+	l.log(ERROR, true, format, args)
+}
+
+// Fatal logs the message, closes the sinks and exits with status 1
+func (l *BuiltinLogger) Fatal(format string, args ...any) {
+	l.log(FATAL, true, format, args)
+	l.Close()
+	os.Exit(1)
+}
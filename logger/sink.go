@@ -0,0 +1,131 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Sink is a destination of the log records. Write is called with the
+// logger's lock held, so sinks don't need their own locking for it.
+type Sink interface {
+	Write(r *Record) error
+	Close() error
+}
+
+// WriterSink writes encoded records to w (e.g. os.Stdout). Close does not
+// close w.
+type WriterSink struct {
+	w   io.Writer
+	enc Encoder
+}
+
+func NewWriterSink(w io.Writer, enc Encoder) *WriterSink {
+	return &WriterSink{w: w, enc: enc}
+}
+
+func (s *WriterSink) Write(r *Record) error {
+	_, err := s.w.Write(s.enc.Encode(r))
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// FileSink appends encoded records to a file and rotates it when it grows
+// beyond maxSize bytes, keeping maxBackups old files (path.1 is the newest).
+type FileSink struct {
+	path       string
+	maxSize    int64 // 0 disables rotation
+	maxBackups int
+	enc        Encoder
+	f          *os.File
+	size       int64
+}
+
+func NewFileSink(path string, maxSize int64, maxBackups int, enc Encoder) (*FileSink, error) {
+	s := &FileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		enc:        enc,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.f, s.size = f, fi.Size()
+	return nil
+}
+
+func (s *FileSink) backupName(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+// rotate shifts path -> path.1 -> path.2 ... and opens a new file
+func (s *FileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	s.f = nil
+	if s.maxBackups <= 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		_ = os.Remove(s.backupName(s.maxBackups))
+		for i := s.maxBackups - 1; i >= 1; i-- {
+			if err := os.Rename(s.backupName(i), s.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(s.path, s.backupName(1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return s.open()
+}
+
+func (s *FileSink) Write(r *Record) error {
+	line := s.enc.Encode(r)
+	if s.f == nil {
+		// a previous rotation failed
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate %s: %s", s.path, err)
+		}
+	}
+	n, err := s.f.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *FileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,318 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// syslog facilities (RFC 5424 section 6.2.1)
+var SyslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverity maps the levels to the syslog severities
+func syslogSeverity(level int) int {
+	switch level {
+	case FATAL:
+		return 2 // critical
+	case ERROR:
+		return 3 // error
+	case WARNING:
+		return 4 // warning
+	case INFO:
+		return 6 // informational
+	default:
+		return 7 // debug
+	}
+}
+
+type SyslogConfig struct {
+	Network    string // udp, tcp or tls
+	Addr       string // host:port
+	Format     string // rfc5424 or rfc3164
+	Facility   int
+	Tag        string // APP-NAME (RFC 5424) or TAG (RFC 3164)
+	Hostname   string // os.Hostname() if empty
+	BufferSize int    // messages kept while the server is unreachable
+	TLSConfig  *tls.Config
+}
+
+// SyslogSink sends the records to a remote syslog server. Messages are
+// queued and sent in the background; when the queue is full (e.g. the
+// server is unreachable) new messages are dropped and counted.
+//
+// With RFC 5424 the fields are sent as structured data. On TCP/TLS the
+// messages are framed by octet counting (RFC 5424) or a newline (RFC 3164).
+type SyslogSink struct {
+	cfg      SyslogConfig
+	enc      Encoder // nil: plain message
+	hostname string
+	pid      int
+
+	queue   chan []byte
+	dropped atomic.Uint64
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+const (
+	syslogDialTimeout  = time.Second * 5
+	syslogWriteTimeout = time.Second * 5
+	syslogMaxBackoff   = time.Second * 30
+	syslogCloseTimeout = time.Second * 2
+)
+
+// NewSyslogSink starts sending to cfg.Addr. enc formats the message part;
+// nil sends the message and the fields as plain text.
+func NewSyslogSink(cfg SyslogConfig, enc Encoder) *SyslogSink {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 1000
+	}
+	hostname := cfg.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	if hostname == "" {
+		hostname = "-"
+	}
+	s := &SyslogSink{
+		cfg:      cfg,
+		enc:      enc,
+		hostname: hostname,
+		pid:      os.Getpid(),
+		queue:    make(chan []byte, cfg.BufferSize),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Dropped returns the number of messages dropped since the queue was full
+func (s *SyslogSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *SyslogSink) Write(r *Record) error {
+	select {
+	case s.queue <- s.format(r):
+	default:
+		s.dropped.Add(1)
+	}
+	return nil
+}
+
+// Close sends the queued messages (waiting up to 2 seconds) and stops
+func (s *SyslogSink) Close() error {
+	s.once.Do(func() {
+		close(s.closing)
+	})
+	<-s.done
+	return nil
+}
+
+func (s *SyslogSink) format(r *Record) []byte {
+	pri := s.cfg.Facility*8 + syslogSeverity(r.Level)
+	tag := s.cfg.Tag
+	if tag == "" {
+		tag = "-"
+	}
+
+	var buf bytes.Buffer
+	if s.cfg.Format == "rfc3164" {
+		fmt.Fprintf(&buf, "<%d>%s %s %s[%d]: ", pri, r.Time.Format(time.Stamp), s.hostname, tag, s.pid)
+		buf.WriteString(s.body(r, true))
+		return buf.Bytes()
+	}
+
+	msgid := r.Subsystem
+	if msgid == "" {
+		msgid = "-"
+	}
+	fmt.Fprintf(&buf, "<%d>1 %s %s %s %d %s ", pri, r.Time.Format(time.RFC3339Nano), s.hostname, tag, s.pid, msgid)
+	if s.enc == nil && len(r.Fields) > 0 {
+		// structured data with the private enterprise number for documentation
+		buf.WriteString("[fields@32473")
+		for _, f := range r.Fields {
+			fmt.Fprintf(&buf, ` %s="%s"`, sdName(f.Key), sdEscaper.Replace(formatFieldValue(f.Value)))
+		}
+		buf.WriteString("] ")
+	} else {
+		buf.WriteString("- ")
+	}
+	buf.WriteString(s.body(r, false))
+	return buf.Bytes()
+}
+
+// body is the MSG part. withFields appends the fields when the header
+// can't carry them.
+func (s *SyslogSink) body(r *Record, withFields bool) string {
+	if s.enc != nil {
+		return strings.TrimSuffix(string(s.enc.Encode(r)), "\n")
+	}
+	var buf bytes.Buffer
+	if withFields && r.Subsystem != "" {
+		buf.WriteString(r.Subsystem + ": ")
+	}
+	if r.Caller != "" {
+		buf.WriteString(r.Caller + ": ")
+	}
+	buf.WriteString(strings.TrimSuffix(r.Message, "\n"))
+	if withFields {
+		for _, f := range r.Fields {
+			buf.WriteByte(' ')
+			writeLogfmtPair(&buf, f.Key, formatFieldValue(f.Value))
+		}
+	}
+	return buf.String()
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// sdName strips the characters not allowed in SD-NAME
+func sdName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= 32 || r >= 127 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	switch s.cfg.Network {
+	case "tls":
+		return tls.DialWithDialer(&net.Dialer{Timeout: syslogDialTimeout}, "tcp", s.cfg.Addr, s.cfg.TLSConfig)
+	case "tcp":
+		return net.DialTimeout("tcp", s.cfg.Addr, syslogDialTimeout)
+	default:
+		return net.DialTimeout("udp", s.cfg.Addr, syslogDialTimeout)
+	}
+}
+
+func (s *SyslogSink) frame(msg []byte) []byte {
+	switch {
+	case s.cfg.Network == "udp" || s.cfg.Network == "":
+		return msg
+	case s.cfg.Format == "rfc3164":
+		return append(msg, '\n')
+	default:
+		return append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+}
+
+func (s *SyslogSink) run() {
+	defer close(s.done)
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			_ = conn.Close()
+		}
+	}()
+	var reported uint64  // dropped count already reported to the server
+	unreachable := false // reported to stderr
+	backoff := time.Second
+	var closeDeadline <-chan time.Time
+	var pending []byte
+
+	for {
+		if closeDeadline == nil {
+			select {
+			case <-s.closing:
+				closeDeadline = time.After(syslogCloseTimeout)
+			default:
+			}
+		}
+		if pending == nil {
+			select {
+			case pending = <-s.queue:
+			case <-s.closing:
+				// flush the queue before stopping
+				select {
+				case pending = <-s.queue:
+				default:
+					return
+				}
+			}
+		}
+
+		if conn == nil {
+			c, err := s.dial()
+			if err != nil {
+				if !unreachable {
+					fmt.Fprintf(os.Stderr, "logger: failed to connect to syslog server %s: %s\n", s.cfg.Addr, err)
+					unreachable = true
+				}
+				if !s.wait(backoff, closeDeadline) {
+					return
+				}
+				backoff *= 2
+				if backoff > syslogMaxBackoff {
+					backoff = syslogMaxBackoff
+				}
+				continue
+			}
+			conn, backoff = c, time.Second
+		}
+
+		if dropped := s.dropped.Load(); dropped > reported {
+			notice := s.format(&Record{
+				Time:    time.Now(),
+				Level:   WARNING,
+				Message: fmt.Sprintf("%d log message(s) dropped while the syslog server was unreachable", dropped-reported),
+			})
+			if s.send(conn, notice) == nil {
+				reported = dropped
+			}
+		}
+		if err := s.send(conn, pending); err != nil {
+			if !unreachable {
+				fmt.Fprintf(os.Stderr, "logger: failed to send to syslog server %s: %s\n", s.cfg.Addr, err)
+				unreachable = true
+			}
+			_ = conn.Close()
+			conn = nil
+			if !s.wait(backoff, closeDeadline) {
+				return
+			}
+			continue
+		}
+		pending, unreachable = nil, false
+	}
+}
+
+func (s *SyslogSink) send(conn net.Conn, msg []byte) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	_, err := conn.Write(s.frame(msg))
+	return err
+}
+
+// wait sleeps for d or until Close is called. It returns false when the
+// close deadline has passed.
+func (s *SyslogSink) wait(d time.Duration, closeDeadline <-chan time.Time) bool {
+	closing := s.closing
+	if closeDeadline != nil {
+		closing = nil
+	}
+	select {
+	case <-time.After(d):
+		return true
+	case <-closing:
+		// retry until the close deadline
+		return true
+	case <-closeDeadline:
+		return false
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/logger"
+)
+
+// subsystems whose level can be set by LOG_LEVEL (e.g. "INFO,nd=TRACE,ros=INFO")
+var logSubsystems = []string{"ra", "nd", "ros", "hook", "ddns", "http", "ha"}
+
+var (
+	ralog   = llog.Named("ra")
+	ndlog   = llog.Named("nd")
+	roslog  = llog.Named("ros")
+	hooklog = llog.Named("hook")
+	ddnslog = llog.Named("ddns")
+	httplog = llog.Named("http") // API and metrics servers
+	halog   = llog.Named("ha")
+)
+
+func isLogSubsystem(name string) bool {
+	for _, s := range logSubsystems {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+type LogConfig struct {
+	levels         logger.Levels
+	format         string
+	outputs        []string
+	file           string
+	fileMaxSize    int64 // bytes
+	fileMaxBackups int
+	syslogServer   string // host:port
+	syslogProtocol string // udp, tcp or tls
+	syslogFormat   string // rfc5424 or rfc3164
+	syslogFacility string
+	syslogTag      string
+	syslogBuffer   int
+	syslogTLSCA    string
+}
+
+func dumpLogConfig(cfg *LogConfig) {
+	llog.Debug("Log Configuration:")
+	llog.Debug("  LOG_LEVEL=%s", cfg.levels)
+	llog.Debug("  LOG_FORMAT=%s", cfg.format)
+	llog.Debug("  LOG_OUTPUTS=%+v", cfg.outputs)
+	if cfg.hasOutput("file") {
+		llog.Debug("  LOG_FILE=%s", cfg.file)
+		llog.Debug("  LOG_FILE_MAX_SIZE=%d", cfg.fileMaxSize/1024/1024)
+		llog.Debug("  LOG_FILE_MAX_BACKUPS=%d", cfg.fileMaxBackups)
+	}
+	if cfg.hasOutput("syslog") {
+		llog.Debug("  LOG_SYSLOG_SERVER=%s", cfg.syslogServer)
+		llog.Debug("  LOG_SYSLOG_PROTOCOL=%s", cfg.syslogProtocol)
+		llog.Debug("  LOG_SYSLOG_FORMAT=%s", cfg.syslogFormat)
+		llog.Debug("  LOG_SYSLOG_FACILITY=%s", cfg.syslogFacility)
+		llog.Debug("  LOG_SYSLOG_TAG=%s", cfg.syslogTag)
+		llog.Debug("  LOG_SYSLOG_BUFFER=%d", cfg.syslogBuffer)
+		if cfg.syslogTLSCA != "" {
+			llog.Debug("  LOG_SYSLOG_TLS_CA=%s", cfg.syslogTLSCA)
+		}
+	}
+}
+
+func (cfg *LogConfig) hasOutput(name string) bool {
+	for _, o := range cfg.outputs {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOutputs reports whether the sinks built from cfg and other are the same
+func (cfg *LogConfig) sameOutputs(other *LogConfig) bool {
+	a, b := *cfg, *other
+	a.levels, b.levels = logger.Levels{}, logger.Levels{}
+	return reflect.DeepEqual(a, b)
+}
+
+// the configuration applied to llog
+var currentLogConfig *LogConfig
+
+// the syslog sink reported by fletsv6_log_syslog_dropped_total
+var (
+	logSyslogSink   *logger.SyslogSink
+	logSyslogSinkMu sync.Mutex
+)
+
+func newSyslogTLSConfig(cfg *LogConfig) (*tls.Config, error) {
+	host, _, _ := net.SplitHostPort(cfg.syslogServer)
+	tcfg := &tls.Config{ServerName: host}
+	if cfg.syslogTLSCA != "" {
+		pem, err := os.ReadFile(cfg.syslogTLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LOG_SYSLOG_TLS_CA: %s", err)
+		}
+		tcfg.RootCAs = x509.NewCertPool()
+		if !tcfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in LOG_SYSLOG_TLS_CA")
+		}
+	}
+	return tcfg, nil
+}
+
+func newLogSinks(cfg *LogConfig) ([]logger.Sink, error) {
+	enc, err := logger.NewEncoder(cfg.format)
+	if err != nil {
+		return nil, err
+	}
+	var sinks []logger.Sink
+	for _, output := range cfg.outputs {
+		switch output {
+		case "stdout":
+			sinks = append(sinks, logger.NewWriterSink(os.Stdout, enc))
+		case "file":
+			s, err := logger.NewFileSink(cfg.file, cfg.fileMaxSize, cfg.fileMaxBackups, enc)
+			if err != nil {
+				closeSinks(sinks)
+				return nil, fmt.Errorf("failed to open LOG_FILE: %s", err)
+			}
+			sinks = append(sinks, s)
+		case "syslog":
+			scfg := logger.SyslogConfig{
+				Network:    cfg.syslogProtocol,
+				Addr:       cfg.syslogServer,
+				Format:     cfg.syslogFormat,
+				Facility:   logger.SyslogFacilities[cfg.syslogFacility],
+				Tag:        cfg.syslogTag,
+				BufferSize: cfg.syslogBuffer,
+			}
+			if cfg.syslogProtocol == "tls" {
+				if scfg.TLSConfig, err = newSyslogTLSConfig(cfg); err != nil {
+					closeSinks(sinks)
+					return nil, err
+				}
+			}
+			var senc logger.Encoder // plain messages unless json/logfmt
+			if cfg.format != "text" {
+				senc = enc
+			}
+			sinks = append(sinks, logger.NewSyslogSink(scfg, senc))
+		}
+	}
+	return sinks, nil
+}
+
+func closeSinks(sinks []logger.Sink) {
+	for _, s := range sinks {
+		_ = s.Close()
+	}
+}
+
+// configureLogging applies LOG_* to llog. The sinks are only replaced when
+// the output settings have changed.
+func configureLogging() error {
+	cfg, err := loadLogConfig()
+	if err != nil {
+		return err
+	}
+	if currentLogConfig == nil || !currentLogConfig.sameOutputs(cfg) {
+		sinks, err := newLogSinks(cfg)
+		if err != nil {
+			return err
+		}
+		logSyslogSinkMu.Lock()
+		logSyslogSink = nil
+		for _, s := range sinks {
+			if ss, ok := s.(*logger.SyslogSink); ok {
+				logSyslogSink = ss
+			}
+		}
+		logSyslogSinkMu.Unlock()
+		llog.SetSinks(sinks...)
+	}
+	llog.SetLevels(cfg.levels)
+	currentLogConfig = cfg
+	dumpLogConfig(cfg)
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/logger"
+)
+
+func TestLogging(t *testing.T) {
+	var buf strings.Builder
+	l := logger.NewBuiltinLogger()
+	l.SetSinks(logger.NewWriterSink(&buf, logger.JSONEncoder{}))
+	levels, err := logger.ParseLevels("INFO,nd=TRACE,ros=WARNING")
+	if err != nil {
+		t.Fatalf("ParseLevels failed: %s", err)
+	}
+	l.SetLevels(levels)
+
+	nd := l.Named("nd").With("interface", "eth0", "target", net.ParseIP("2001:db8::1"))
+	nd.Trace("solicitation for %s", "2001:db8::1")
+	l.Named("ros").Info("hidden")
+	l.Named("ros").With("command", "/ip/address/print").Warning("shown")
+	l.Debug("hidden")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected records: %q", lines)
+	}
+	var rec map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("invalid JSON record %s: %s", lines[0], err)
+	}
+	if rec["level"] != "trace" || rec["subsystem"] != "nd" || rec["target"] != "2001:db8::1" || rec["interface"] != "eth0" {
+		t.Fatalf("unexpected record: %s", lines[0])
+	}
+	if l.Levels().String() != "INFO,nd=TRACE,ros=WARNING" {
+		t.Fatalf("unexpected levels: %s", l.Levels())
+	}
+
+	// logfmt quotes values with spaces
+	line := string(logger.LogfmtEncoder{}.Encode(&logger.Record{
+		Time:    time.Unix(0, 0).UTC(),
+		Level:   logger.INFO,
+		Message: "hello world",
+		Fields:  []logger.Field{{Key: "mac", Value: net.HardwareAddr{0, 1, 2, 3, 4, 5}}},
+	}))
+	if line != "time=1970-01-01T00:00:00Z level=info msg=\"hello world\" mac=00:01:02:03:04:05\n" {
+		t.Fatalf("unexpected logfmt: %q", line)
+	}
+
+	// rotation keeps maxBackups files
+	dir, err := os.MkdirTemp("", "fletsv6-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/companion.log"
+	fs, err := logger.NewFileSink(path, 200, 2, logger.TextEncoder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetSinks(fs)
+	for i := 0; i < 20; i++ {
+		l.Warning("line %d of the rotated log file", i)
+	}
+	l.Close()
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		fi, err := os.Stat(name)
+		if err != nil || fi.Size() > 200 {
+			t.Fatalf("unexpected log file %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("too many backups are kept")
+	}
+
+	// LOG_LEVEL only accepts the known subsystems
+	os.Setenv("LOG_LEVEL", "INFO,nd=TRACE")
+	if _, err := loadLogConfig(); err != nil {
+		t.Fatalf("loadLogConfig failed: %s", err)
+	}
+	os.Setenv("LOG_LEVEL", "INFO,foo=TRACE")
+	if _, err := loadLogConfig(); err == nil {
+		t.Fatalf("loadLogConfig accepted an unknown subsystem")
+	}
+	os.Unsetenv("LOG_LEVEL")
+}
+
+func TestSyslog(t *testing.T) {
+	// RFC 5424 over UDP with the fields as structured data
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	sink := logger.NewSyslogSink(logger.SyslogConfig{
+		Network:  "udp",
+		Addr:     pc.LocalAddr().String(),
+		Facility: logger.SyslogFacilities["local0"],
+		Tag:      "fletsv6",
+		Hostname: "rb",
+	}, nil)
+	l := logger.NewBuiltinLogger()
+	l.SetSinks(sink)
+	l.Named("nd").With("target", net.ParseIP("2001:db8::1")).Warning("no answer")
+	buf := make([]byte, 1024)
+	_ = pc.SetReadDeadline(time.Now().Add(time.Second * 2))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no syslog message received: %s", err)
+	}
+	msg := string(buf[:n])
+	// local0(16)*8 + warning(4)
+	if !strings.HasPrefix(msg, "<132>1 ") || !strings.Contains(msg, ` rb fletsv6 `) ||
+		!strings.HasSuffix(msg, ` nd [fields@32473 target="2001:db8::1"] no answer`) {
+		t.Fatalf("unexpected RFC 5424 message: %s", msg)
+	}
+	l.Close()
+
+	// RFC 3164 over TCP is framed by newlines
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, 1024)
+		n, _ := conn.Read(b)
+		lines <- string(b[:n])
+	}()
+	sink = logger.NewSyslogSink(logger.SyslogConfig{
+		Network:  "tcp",
+		Addr:     ln.Addr().String(),
+		Format:   "rfc3164",
+		Facility: logger.SyslogFacilities["daemon"],
+		Tag:      "fletsv6",
+		Hostname: "rb",
+	}, nil)
+	l.SetSinks(sink)
+	l.Named("ra").Error("failed")
+	select {
+	case line := <-lines:
+		// daemon(3)*8 + err(3)
+		if !strings.HasPrefix(line, "<27>") || !strings.Contains(line, " rb fletsv6[") || !strings.Contains(line, "]: ra: ") || !strings.HasSuffix(line, "failed\n") {
+			t.Fatalf("unexpected RFC 3164 message: %q", line)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("no syslog message received over TCP")
+	}
+	l.Close()
+
+	// unreachable server: messages beyond the buffer are dropped
+	addr := ln.Addr().String()
+	ln.Close()
+	sink = logger.NewSyslogSink(logger.SyslogConfig{Network: "tcp", Addr: addr, BufferSize: 2}, nil)
+	l.SetSinks(sink)
+	for i := 0; i < 10; i++ {
+		l.Info("message %d", i)
+	}
+	if d := sink.Dropped(); d < 7 {
+		t.Fatalf("expected dropped messages, got %d", d)
+	}
+	start := time.Now()
+	l.Close()
+	if time.Since(start) > time.Second*5 {
+		t.Fatalf("Close took too long")
+	}
+}
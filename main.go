@@ -2,63 +2,176 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/grainrigi/routeros-fletsv6-companion/logger"
 )
 
-var daemonErrs = make(chan error)
+// how long to wait for the workers to stop on shutdown
+const shutdownTimeout = time.Second * 10
+
+// names of the workers restarted by Reloader
+const (
+	raWorkerName = "Router Advertisement Worker"
+	ndWorkerName = "NDProxy Worker"
+)
 
 var llog = logger.NewBuiltinLogger()
 
-func startDaemon(ctx context.Context, f func(context.Context) error) {
-	go func() {
-		err := f(ctx)
-		if err != nil {
-			daemonErrs <- err
-		}
-	}()
+func shutdown(sup *Supervisor, rac *RAClient, ndc *NDClient, ros RouterBackend) {
+	if !sup.Wait(shutdownTimeout) {
+		llog.Warning("Workers did not stop within %s", shutdownTimeout)
+	}
+
+	if ndc != nil {
+		ndc.Close()
+	}
+	rac.Close()
+	if ros != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		rac.Cleanup(ctx)
+		cancel()
+		ros.Close()
+	}
+	cleanupVlanDevs()
 }
 
 func main() {
-	racfg, err := loadRAConfig()
-	if err != nil {
-		llog.Fatal("%s", err)
-	}
-	dumpRAConfig(racfg)
+	runCommand(os.Args[1:])
+}
 
-	ndcfg, ndNeedROS, err := loadNDConfig(racfg)
+// cmdRun runs the daemons (default command)
+func cmdRun(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("run takes no arguments")
+	}
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	cfg, err := loadAppConfig()
 	if err != nil {
-		llog.Fatal("%s", err)
+		return err
 	}
-	dumpNDConfig(ndcfg)
+	racfg, ndcfg, hookcfg, ddnscfg := cfg.ra, cfg.nd, cfg.hook, cfg.ddns
 
-	ctx, _ := context.WithCancel(context.Background())
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 
 	// init ros (if necessary)
-	var ros *ROSClient
-	if racfg.mode == "ros" || ndNeedROS {
-		roscfg, err := loadROSConfig()
-		if err != nil {
-			llog.Fatal("%s", err)
-		}
-		ros, err = NewROSClient(roscfg)
+	var ros RouterBackend
+	if cfg.needROS() {
+		rosc, err := connectROS()
 		if err != nil {
-			llog.Fatal("Failed to initialize RouterOS API: %s", err)
+			return err
 		}
+		ros = rosc
 	}
 
-	// startRA
+	sup := NewSupervisor()
+
+	// start hooks
 	rac := NewRAClient(racfg, ros)
+	if racfg.mode != "off" && hookcfg.Enabled() {
+		llog.Info("Starting Hook Runner")
+		hooks := NewHookRunner(hookcfg)
+		rac.AddHandler(hooks)
+		sup.Go(ctx, "Hook Runner", hooks.Work)
+	}
+	// start ddns
+	if ddnscfg.Enabled() {
+		llog.Info("Starting DDNS Updater")
+		ddns := NewDDNSUpdater(ddnscfg, rac)
+		rac.AddHandler(ddns)
+		sup.Go(ctx, "DDNS Updater", ddns.Work)
+	}
+	// start metrics
+	if cfg.metrics.Enabled() {
+		llog.Info("Starting Metrics Server")
+		sup.Go(ctx, "Metrics Server", NewMetricsServer(cfg.metrics, ros).Work)
+	}
+	// ha is started after the ND worker
+	var ha *HAElector
+	if cfg.ha.Enabled() {
+		ha = NewHAElector(cfg.ha)
+		rac.SetLeadership(ha)
+	}
+	// startRA
 	if racfg.mode != "off" {
 		llog.Info("Starting RA Server")
-		startDaemon(ctx, func(ctx context.Context) error { return rac.Work(ctx) })
+		sup.Go(ctx, raWorkerName, rac.Work)
 	}
 	// start ND
+	var ndc *NDClient
 	if ndcfg.mode != "off" {
 		llog.Info("Starting ND Server")
-		ndc := NewNDClient(ndcfg, rac, ros)
-		startDaemon(ctx, func(ctx context.Context) error { return ndc.Work(ctx) })
+		ndc = NewNDClient(ndcfg, rac, ros)
+		if ha != nil {
+			ndc.SetLeadership(ha)
+		}
+		sup.Go(ctx, ndWorkerName, ndc.Work)
+	}
+	// start ha
+	if ha != nil {
+		llog.Info("Starting HA Elector")
+		ha.OnChange(func(active bool) {
+			if !active {
+				return
+			}
+			// take over: apply the configuration and move the traffic to us
+			if rac.RouterInfo() != nil {
+				go rac.reconcile(ctx, "failover")
+			}
+			if ndc != nil {
+				go ndc.Announce(ctx)
+			}
+		})
+		sup.Go(ctx, "HA Elector", ha.Work)
+	}
+	// start api
+	if cfg.api.Enabled() {
+		llog.Info("Starting API Server")
+		sup.Go(ctx, "API Server", NewAPIServer(cfg.api, rac, ndc, sup, ha).Work)
+	}
+
+	// reload on SIGHUP or when CONFIG_FILE is modified
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	changed := make(chan struct{}, 1)
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		go watchConfigFile(ctx, path, changed)
+	}
+	reloader := &Reloader{
+		racfg: racfg,
+		ndcfg: ndcfg,
+		rac:   rac,
+		ndc:   ndc,
+		ros:   ros,
+		sup:   sup,
 	}
 
-	<-daemonErrs
+loop:
+	for {
+		select {
+		case <-sup.Done():
+			llog.Info("Dry-run plan completed. Shutting down")
+			break loop
+		case <-ctx.Done():
+			llog.Info("Received a termination signal. Shutting down")
+			break loop
+		case <-hup:
+			llog.Info("Received SIGHUP. Reloading configuration")
+		case <-changed:
+			llog.Info("CONFIG_FILE has been modified. Reloading configuration")
+		}
+		if err := reloader.Reload(ctx); err != nil {
+			llog.Error("Failed to reload configuration. Keeping the current one: %s", err)
+		}
+	}
+	stop()
+	shutdown(sup, rac, ndc, ros)
+	return nil
 }
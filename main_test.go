@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/rosapitest"
+)
+
+func TestCleanupOnExit(t *testing.T) {
+	srv, err := rosapitest.NewServer("fletsv6", "password")
+	if err != nil {
+		t.Fatalf("rosapitest.NewServer failed: %s", err)
+	}
+	defer srv.Close()
+	userRoute := srv.Insert("/ipv6/route", map[string]string{"dst-address": "::/0", "gateway": "fe80::99%ether2"})
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+
+	// runs the companion until shutdown
+	run := func(cleanup string) {
+		c, err := NewROSClient(ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", dryRun: "off"})
+		if err != nil {
+			t.Fatalf("NewROSClient failed: %s", err)
+		}
+		cfg := &RAConfig{mode: "ros", rosExtIf: "vlanflets", rosGC: "on", cleanupOnExit: cleanup}
+		eip, _ := ParseROSIPAssign("ra-prefix::1/128@@external", cfg.rosExtIf)
+		cfg.rosExtIPs = append(cfg.rosExtIPs, eip)
+		pool, _ := ParseROSPoolAssign("ra-prefix@fletsv6-pool/64")
+		cfg.rosPools = append(cfg.rosPools, pool)
+		rac := NewRAClient(cfg, c)
+		rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+		rac.reconcile(context.Background(), "solicited")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		sup := NewSupervisor()
+		sup.Go(ctx, "ra", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		cancel()
+		shutdown(sup, rac, nil, c)
+	}
+	owned := func(path string) []map[string]string {
+		var rows []map[string]string
+		for _, row := range srv.Rows(path) {
+			if isOwnedComment(row["comment"]) {
+				rows = append(rows, row)
+			}
+		}
+		return rows
+	}
+
+	// off leaves everything in place
+	run("off")
+	if len(owned("/ipv6/route")) != 1 || len(owned("/ipv6/address")) != 1 || isROSTrue(owned("/ipv6/route")[0]["disabled"]) {
+		t.Fatalf("CLEANUP_ON_EXIT=off touched the objects: %+v %+v", srv.Rows("/ipv6/route"), srv.Rows("/ipv6/address"))
+	}
+
+	// disable keeps the objects disabled until the next start
+	run("disable")
+	routes, addrs := owned("/ipv6/route"), owned("/ipv6/address")
+	if len(routes) != 1 || !isROSTrue(routes[0]["disabled"]) || len(addrs) != 1 || !isROSTrue(addrs[0]["disabled"]) {
+		t.Fatalf("CLEANUP_ON_EXIT=disable did not disable the objects: %+v %+v", routes, addrs)
+	}
+	if srv.CommandCount("/ipv6/route/add") != 1 || srv.CommandCount("/ipv6/address/add") != 1 {
+		t.Fatalf("disabled objects were not reused")
+	}
+
+	// remove removes the route and addresses but keeps the pool and the objects of the user
+	run("remove")
+	if len(owned("/ipv6/route")) != 0 || len(owned("/ipv6/address")) != 0 || len(owned("/ipv6/pool")) != 1 {
+		t.Fatalf("CLEANUP_ON_EXIT=remove left %+v %+v", srv.Rows("/ipv6/route"), srv.Rows("/ipv6/address"))
+	}
+	if rows := srv.Rows("/ipv6/route"); len(rows) != 1 || rows[0][".id"] != userRoute || isROSTrue(rows[0]["disabled"]) {
+		t.Fatalf("the route of the user was touched: %+v", rows)
+	}
+
+	// CLEANUP_ON_EXIT is validated
+	t.Setenv("RA_MODE", "ros")
+	t.Setenv("RA_ROS_EXTERNAL_INTERFACE", "vlanflets")
+	t.Setenv("CLEANUP_ON_EXIT", "delete")
+	if _, err := loadRAConfig(); err == nil {
+		t.Fatalf("invalid CLEANUP_ON_EXIT was accepted")
+	}
+}
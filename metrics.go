@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal registry exposing metrics in the Prometheus text format (0.0.4).
+// Every metric is registered once at startup as a package variable below.
+
+type MetricsConfig struct {
+	listen string
+}
+
+func dumpMetricsConfig(cfg *MetricsConfig) {
+	httplog.Debug("Metrics Configuration:")
+	httplog.Debug("  METRICS_LISTEN=%s", cfg.listen)
+}
+
+func (cfg *MetricsConfig) Enabled() bool {
+	return cfg.listen != ""
+}
+
+// buckets (seconds) of the latency histograms
+var metricLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type metricVec struct {
+	name    string
+	help    string
+	typ     string // counter, gauge, histogram
+	labels  []string
+	buckets []float64
+	series  map[string]*metricSeries
+	collect func() []metricSample // for gauges computed on scrape
+	mutex   sync.Mutex
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64 // per bucket (not cumulative)
+	sum         float64
+	count       uint64
+}
+
+type metricSample struct {
+	labelValues []string
+	value       float64
+}
+
+var metricsRegistry []*metricVec
+
+func newMetric(typ string, name string, help string, labels ...string) *metricVec {
+	m := &metricVec{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		series: make(map[string]*metricSeries),
+	}
+	metricsRegistry = append(metricsRegistry, m)
+	return m
+}
+
+func newCounter(name string, help string, labels ...string) *metricVec {
+	return newMetric("counter", name, help, labels...)
+}
+
+func newGauge(name string, help string, labels ...string) *metricVec {
+	return newMetric("gauge", name, help, labels...)
+}
+
+func newHistogram(name string, help string, buckets []float64, labels ...string) *metricVec {
+	m := newMetric("histogram", name, help, labels...)
+	m.buckets = buckets
+	return m
+}
+
+// newGaugeFunc registers a gauge whose samples are computed by collect on every scrape
+func newGaugeFunc(name string, help string, collect func() []metricSample, labels ...string) *metricVec {
+	m := newMetric("gauge", name, help, labels...)
+	m.collect = collect
+	return m
+}
+
+// newCounterFunc registers a counter whose samples are computed by collect on every scrape
+func newCounterFunc(name string, help string, collect func() []metricSample, labels ...string) *metricVec {
+	m := newMetric("counter", name, help, labels...)
+	m.collect = collect
+	return m
+}
+
+func (m *metricVec) get(labelValues []string) *metricSeries {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label(s)", m.name, len(m.labels)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: labelValues}
+		if m.buckets != nil {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+func (m *metricVec) Add(delta float64, labelValues ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.get(labelValues).value += delta
+}
+
+func (m *metricVec) Set(value float64, labelValues ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.get(labelValues).value = value
+}
+
+// Reset removes all series (e.g. to replace the labels of an info metric)
+func (m *metricVec) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.series = make(map[string]*metricSeries)
+}
+
+func (m *metricVec) Observe(value float64, labelValues ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := m.get(labelValues)
+	for i, le := range m.buckets {
+		if value <= le {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// ObserveSince observes the seconds elapsed since start
+func (m *metricVec) ObserveSince(start time.Time, labelValues ...string) {
+	m.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (m *metricVec) write(w io.Writer) {
+	var series []*metricSeries
+	if m.collect != nil {
+		for _, sample := range m.collect() {
+			series = append(series, &metricSeries{labelValues: sample.labelValues, value: sample.value})
+		}
+	} else {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		for _, s := range m.series {
+			series = append(series, s)
+		}
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return strings.Join(series[i].labelValues, "\x00") < strings.Join(series[j].labelValues, "\x00")
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+	for _, s := range series {
+		if m.typ != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatMetricValue(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, le := range m.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", formatMetricValue(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, metricLabelEscaper.Replace(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// WriteMetrics writes all registered metrics
+func WriteMetrics(w io.Writer) {
+	for _, m := range metricsRegistry {
+		m.write(w)
+	}
+}
+
+// metrics
+
+var (
+	metricNDReceived = newCounter("fletsv6_nd_solicitations_received_total",
+		"Neighbor Solicitations received on the external interfaces.", "interface")
+	metricNDAnswered = newCounter("fletsv6_nd_solicitations_answered_total",
+		"Neighbor Solicitations answered with a Neighbor Advertisement.", "interface")
+	metricNDExcluded = newCounter("fletsv6_nd_solicitations_excluded_total",
+		"Neighbor Solicitations ignored since the target is outside NDP_PREFIXES (reason=prefix) or in NDP_EXCLUDE_IPS (reason=exclude).", "interface", "reason")
+	metricNDFailed = newCounter("fletsv6_nd_solicitations_failed_total",
+		"Neighbor Solicitations not answered since the lookup or the advertisement failed.", "interface")
+	metricNDStandby = newCounter("fletsv6_nd_solicitations_standby_total",
+		"Neighbor Solicitations not answered since this instance is standby (HA_MODE).", "interface")
+	metricNDLookupDuration = newHistogram("fletsv6_nd_lookup_duration_seconds",
+		"Time taken to look up the target in the internal network.", metricLatencyBuckets, "mode")
+
+	metricRAReceived = newCounter("fletsv6_ra_received_total",
+		"Router Advertisements received.", "interface")
+	metricRAPrefixChanges = newCounter("fletsv6_ra_prefix_changes_total",
+		"Changes of the prefix or the gateway advertised by the router.")
+	metricRAInfo = newGauge("fletsv6_ra_info",
+		"The current prefix and gateway (always 1).", "prefix", "gateway")
+
+	metricROSRequests = newCounter("fletsv6_ros_requests_total",
+		"RouterOS API commands issued (including retries).", "command")
+	metricROSErrors = newCounter("fletsv6_ros_request_errors_total",
+		"RouterOS API commands failed.", "command")
+	metricROSDuration = newHistogram("fletsv6_ros_request_duration_seconds",
+		"Time taken by RouterOS API commands.", metricLatencyBuckets, "command")
+	metricROSPool = newGaugeFunc("fletsv6_ros_pool_connections",
+		"Connections of the RouterOS API pool (state=open includes the ones in use).", collectROSPoolMetrics, "state")
+
+	metricHAActive = newGauge("fletsv6_ha_active",
+		"1 if this instance is active, 0 if standby (HA_MODE).")
+	metricHATransitions = newCounter("fletsv6_ha_transitions_total",
+		"Transitions between active and standby.")
+
+	metricLogSyslogDropped = newCounterFunc("fletsv6_log_syslog_dropped_total",
+		"Log messages dropped since the syslog server was unreachable.", collectLogSyslogDropped)
+)
+
+// the pool reported by fletsv6_ros_pool_connections
+var (
+	metricsPool   *ROSConnectionPool
+	metricsPoolMu sync.Mutex
+)
+
+func collectROSPoolMetrics() []metricSample {
+	metricsPoolMu.Lock()
+	pool := metricsPool
+	metricsPoolMu.Unlock()
+	if pool == nil {
+		return nil
+	}
+	stats := pool.Stats()
+	return []metricSample{
+		{[]string{"open"}, float64(stats.Open)},
+		{[]string{"idle"}, float64(stats.Idle)},
+	}
+}
+
+func collectLogSyslogDropped() []metricSample {
+	logSyslogSinkMu.Lock()
+	sink := logSyslogSink
+	logSyslogSinkMu.Unlock()
+	if sink == nil {
+		return nil
+	}
+	return []metricSample{{nil, float64(sink.Dropped())}}
+}
+
+// MetricsServer serves /metrics on METRICS_LISTEN
+type MetricsServer struct {
+	cfg *MetricsConfig
+}
+
+func NewMetricsServer(cfg *MetricsConfig, ros RouterBackend) *MetricsServer {
+	if rosc, ok := ros.(*ROSClient); ok {
+		if pool, ok := rosc.transport.(*ROSConnectionPool); ok {
+			metricsPoolMu.Lock()
+			metricsPool = pool
+			metricsPoolMu.Unlock()
+		}
+	}
+	return &MetricsServer{cfg: cfg}
+}
+
+func (s *MetricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	WriteMetrics(w)
+}
+
+// Work serves until ctx is done
+func (s *MetricsServer) Work(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s)
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	l, err := net.Listen("tcp", s.cfg.listen)
+	if err != nil {
+		return err
+	}
+	httplog.Info("Serving metrics on http://%s/metrics", l.Addr())
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(l)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	rac := NewRAClient(&RAConfig{mode: "off"}, nil)
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	rac.setRouterInfo(&RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")})
+	_, n, _ = net.ParseCIDR("2001:db8:2::/64")
+	rac.setRouterInfo(&RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")})
+	metricNDLookupDuration.Observe(0.02, "proxy-ros")
+	metricNDLookupDuration.Observe(10, "proxy-ros")
+	metricNDExcluded.Inc(`eth0"`, "prefix")
+
+	rec := httptest.NewRecorder()
+	NewMetricsServer(&MetricsConfig{}, nil).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE fletsv6_nd_lookup_duration_seconds histogram",
+		`fletsv6_nd_lookup_duration_seconds_bucket{mode="proxy-ros",le="0.01"} 0`,
+		`fletsv6_nd_lookup_duration_seconds_bucket{mode="proxy-ros",le="0.025"} 1`,
+		`fletsv6_nd_lookup_duration_seconds_bucket{mode="proxy-ros",le="+Inf"} 2`,
+		`fletsv6_nd_lookup_duration_seconds_sum{mode="proxy-ros"} 10.02`,
+		`fletsv6_nd_lookup_duration_seconds_count{mode="proxy-ros"} 2`,
+		`fletsv6_nd_solicitations_excluded_total{interface="eth0\"",reason="prefix"} 1`,
+		`fletsv6_ra_info{prefix="2001:db8:2::/64",gateway="fe80::1"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("metrics do not contain %s:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, "2001:db8:1::/64") {
+		t.Fatalf("old prefix was not removed from fletsv6_ra_info")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
@@ -164,7 +165,7 @@ L:
 func rosTest() {
 	rosCfg, err := loadROSConfig()
 	if err != nil {
-		log.Fatalf("loadROSConfig failed: %s", rosCfg)
+		log.Fatalf("loadROSConfig failed: %s", err)
 	}
 	log.Printf("%+v", rosCfg)
 	c, err := NewROSClient(rosCfg)
@@ -176,7 +177,7 @@ func rosTest() {
 		IP:   ip,
 		Mask: net.CIDRMask(64, 128),
 	}
-	err = c.AssignIPv6("loopback", &cidr, "ra-prefix::1/64", ROSIPOptions{Advertise: true, Eui64: true})
+	_, err = c.AssignIPv6(context.Background(), "loopback", &cidr, "ra-prefix::1/64", ROSIPOptions{Advertise: true, Eui64: true})
 	if err != nil {
 		log.Fatalf("AssignIPv6 failed: %s", err)
 	}
@@ -202,7 +203,7 @@ func multiSockTest() {
 	}
 	s2, err := NewSocket(ii2)
 	if err != nil {
-		log.Fatal("NewSocket(ii2) failed: %s", err)
+		log.Fatalf("NewSocket(ii2) failed: %s", err)
 	}
 	if err := s2.ApplyBPF(bpfICMPv6(128)); err != nil {
 		log.Fatalf("s2.ApplyBPF failed: %s", err)
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"net"
+	"reflect"
+	"runtime/debug"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket/layers"
@@ -18,6 +24,7 @@ type NDConfig struct {
 	extIfs    []string
 	intIfs    []string
 	advMACs   []MACRef
+	cacheTTL  time.Duration
 }
 
 type MACRef struct {
@@ -27,11 +34,50 @@ type MACRef struct {
 
 type NDClient struct {
 	cfg      *NDConfig
+	cfgmu    sync.RWMutex
 	ra       *RAClient
-	ros      *ROSClient
+	ros      RouterBackend
 	extSocks map[string]SockRef
 	intSocks map[string]SockRef
 	mutex    sync.Mutex
+	inflight sync.WaitGroup
+
+	neighbors   map[string]*NeighborEntry
+	observed    map[string]observedTarget // targets of the NSs received recently
+	neighborsmu sync.Mutex
+
+	leader Leadership // answers only while active (nil: always)
+}
+
+type observedTarget struct {
+	ip     net.IP
+	ifname string
+	seen   time.Time
+}
+
+// targets solicited within this period are announced on failover
+const announceWindow = time.Minute * 10
+
+// NeighborEntry is the last lookup result of an IP (the neighbor cache).
+// Entries are served without looking up again for NDP_CACHE_TTL.
+type NeighborEntry struct {
+	IP         string    `json:"ip"`
+	MAC        string    `json:"mac,omitempty"` // empty when the target did not respond
+	LastLookup time.Time `json:"last_lookup"`
+	Lookups    int       `json:"lookups"`
+
+	hwaddr net.HardwareAddr
+}
+
+// the neighbor cache drops the least recently looked up entry beyond this
+const neighborCacheMax = 1024
+
+// SocketStatus describes an open raw socket
+type SocketStatus struct {
+	Role      string `json:"role"` // ra, nd-external, nd-internal
+	Interface string `json:"interface"`
+	Valid     bool   `json:"valid"`
+	AdvMAC    string `json:"advertise_mac,omitempty"`
 }
 
 type SockRef struct {
@@ -41,62 +87,105 @@ type SockRef struct {
 }
 
 func dumpNDConfig(cfg *NDConfig) {
-	llog.Debug("NDProxy Configuration:")
-	llog.Debug("  NDP_MODE=%s", cfg.mode)
-	llog.Debug("  NDP_TIMEOUT=%s", cfg.timeoutMs)
+	ndlog.Debug("NDProxy Configuration:")
+	ndlog.Debug("  NDP_MODE=%s", cfg.mode)
+	ndlog.Debug("  NDP_TIMEOUT=%d", cfg.timeoutMs)
+	ndlog.Debug("  NDP_CACHE_TTL=%d", cfg.cacheTTL/time.Second)
 	if len(cfg.prefixes) > 0 {
-		llog.Debug("  NDP_PREFIXES")
+		ndlog.Debug("  NDP_PREFIXES")
 		for i, p := range cfg.prefixes {
-			llog.Debug("  %3d: %+v", i, p)
+			ndlog.Debug("  %3d: %+v", i, p)
 		}
 	}
 	if len(cfg.excludes) > 0 {
-		llog.Debug("  NDP_EXCLUDE_IPS")
+		ndlog.Debug("  NDP_EXCLUDE_IPS")
 		for i, p := range cfg.excludes {
-			llog.Debug("  %3d: %+v", i, p)
+			ndlog.Debug("  %3d: %+v", i, p)
 		}
 	}
 	if len(cfg.extIfs) > 0 {
-		llog.Debug("  NDP_EXTERNAL_INTERFACES=%+v", cfg.extIfs)
+		ndlog.Debug("  NDP_EXTERNAL_INTERFACES=%+v", cfg.extIfs)
 	}
 	if len(cfg.intIfs) > 0 {
-		llog.Debug("  NDP_INTERNAL_INTERFACES=%+v", cfg.intIfs)
+		ndlog.Debug("  NDP_INTERNAL_INTERFACES=%+v", cfg.intIfs)
 	}
 	if len(cfg.excludes) > 0 {
-		llog.Debug("  NDP_ADVERTISE_MACS")
+		ndlog.Debug("  NDP_ADVERTISE_MACS")
 		for i, p := range cfg.advMACs {
-			llog.Debug("  %3d: %+v", i, p)
+			ndlog.Debug("  %3d: %+v", i, p)
 		}
 	}
 }
 
-func NewNDClient(cfg *NDConfig, ra *RAClient, ros *ROSClient) *NDClient {
+func NewNDClient(cfg *NDConfig, ra *RAClient, ros RouterBackend) *NDClient {
 	c := &NDClient{
-		cfg: cfg,
-		ra:  ra,
-		ros: ros,
+		cfg:       cfg,
+		ra:        ra,
+		ros:       ros,
+		neighbors: make(map[string]*NeighborEntry),
+		observed:  make(map[string]observedTarget),
 	}
 
 	return c
 }
 
-func initSockGroup(socks map[string]SockRef, ifnames []string, filter []bpf.RawInstruction, advMACs []MACRef) (map[string]SockRef, error) {
-	var iflist []string
+// SetLeadership makes the client answer solicitations only while l is active
+func (c *NDClient) SetLeadership(l Leadership) {
+	c.leader = l
+}
+
+func (c *NDClient) config() *NDConfig {
+	c.cfgmu.RLock()
+	defer c.cfgmu.RUnlock()
+	return c.cfg
+}
+
+// Reload replaces the configuration. It returns true when Work has to be
+// restarted to apply it.
+func (c *NDClient) Reload(cfg *NDConfig) bool {
+	c.cfgmu.Lock()
+	defer c.cfgmu.Unlock()
+	if reflect.DeepEqual(c.cfg, cfg) {
+		return false
+	}
+	c.cfg = cfg
+	return true
+}
 
+// pruneSockGroup closes the sockets of interfaces which are no longer
+// listed in ifnames or whose advertised MAC has changed
+func pruneSockGroup(socks map[string]SockRef, ifnames []string, advMACs []MACRef) {
+	for k, sr := range socks {
+		i := -1
+		for j, name := range ifnames {
+			if k == name {
+				i = j
+				break
+			}
+		}
+		if i >= 0 && (advMACs == nil ||
+			(sr.advMAC.rosIf == advMACs[i].rosIf && bytes.Equal(sr.advMAC.hwaddr, advMACs[i].hwaddr))) {
+			continue
+		}
+		ndlog.Debug("  closing socket for %s", k)
+		if sr.s != nil {
+			_ = sr.s.Close()
+		}
+		delete(socks, k)
+	}
+}
+
+func initSockGroup(socks map[string]SockRef, ifnames []string, filter []bpf.RawInstruction, advMACs []MACRef) (map[string]SockRef, error) {
+	iflist := ifnames
 	if socks == nil {
-		iflist = ifnames
 		socks = make(map[string]SockRef)
-	} else {
-		for k := range socks {
-			iflist = append(iflist, k)
-		}
 	}
 
 	ifs, err := collectInterfaces(iflist)
 	if err != nil {
 		return socks, err
 	}
-	llog.Debug("  collectInterfaces(%+v) -> %+v", ifnames, ifs)
+	ndlog.Debug("  collectInterfaces(%+v) -> %+v", ifnames, ifs)
 
 	maci := 0
 	for k := range ifs {
@@ -112,17 +201,17 @@ func initSockGroup(socks map[string]SockRef, ifnames []string, filter []bpf.RawI
 		if sr.s == nil || !sr.s.isValid {
 			ii, err := ifs[k].Index()
 			if err != nil {
-				llog.Warning("  failed to get the index of %s", k)
+				ndlog.Warning("  failed to get the index of %s", k)
 				continue
 			}
 			s, err := NewSocket(ii)
 			if err != nil {
-				llog.Warning("  failed to initialize socket for %s", k)
+				ndlog.Warning("  failed to initialize socket for %s", k)
 				continue
 			}
 			if filter != nil {
 				if err := s.ApplyBPF(filter); err != nil {
-					llog.Warning("  failed to apply a packet filter on %s", k)
+					ndlog.Warning("  failed to apply a packet filter on %s", k)
 					_ = s.Close()
 					continue
 				}
@@ -142,83 +231,320 @@ func initSockGroup(socks map[string]SockRef, ifnames []string, filter []bpf.RawI
 	return socks, nil
 }
 
-func (c *NDClient) processNd(targetIP net.IP, srcMAC net.HardwareAddr, srcIP net.IP, ref *SockRef) {
-	var hwaddr net.HardwareAddr
-	var err error
-	switch c.cfg.mode {
+// Lookup resolves the MAC address of targetIP in the internal network
+// according to NDP_MODE. nil is returned when the target did not respond.
+func (c *NDClient) Lookup(ctx context.Context, targetIP net.IP) (net.HardwareAddr, error) {
+	cfg := c.config()
+	if cfg.cacheTTL > 0 {
+		if hwaddr := c.cachedNeighbor(targetIP, cfg.cacheTTL); hwaddr != nil {
+			ndlog.Trace("using cached neighbor: targetIP=%s hwaddr=%s", targetIP, hwaddr)
+			return hwaddr, nil
+		}
+	}
+	hwaddr, err := c.lookup(ctx, cfg, targetIP)
+	if err == nil && cfg.mode != "static" {
+		c.recordNeighbor(targetIP, hwaddr)
+	}
+	return hwaddr, err
+}
+
+func (c *NDClient) lookup(ctx context.Context, cfg *NDConfig, targetIP net.IP) (net.HardwareAddr, error) {
+	switch cfg.mode {
 	case "static":
-		llog.Trace("skipping solicitation since NDP_MODE=static")
-		hwaddr = make([]byte, 6)
+		ndlog.Trace("skipping solicitation since NDP_MODE=static")
+		return make([]byte, 6), nil
 	case "proxy":
-		llog.Trace("soliciting by myself: targetIP=%s", targetIP.String())
-		hwaddr, err = c.solicitInternal(targetIP)
+		ndlog.Trace("soliciting by myself: targetIP=%s", targetIP.String())
+		hwaddr, err := c.solicitInternal(targetIP)
 		if err != nil {
-			llog.Warning("failed to send internal ND solicitation: %s", err)
+			return nil, fmt.Errorf("failed to send internal ND solicitation: %s", err)
 		}
+		return hwaddr, nil
 	case "proxy-ros":
 		fallthrough
 	case "proxy-ros:strict":
-		llog.Trace("soliciting via routerboard: targetIP=%s", targetIP.String())
-		hwaddr, err = c.ros.LookupNeighbor(targetIP, c.cfg.timeoutMs, c.cfg.mode == "proxy-ros:strict")
+		ndlog.Trace("soliciting via routerboard: targetIP=%s", targetIP.String())
+		hwaddr, err := c.ros.LookupNeighbor(ctx, targetIP, cfg.timeoutMs, cfg.mode == "proxy-ros:strict")
 		if err != nil {
-			llog.Warning("failed to send ND solicitation via RouterOS: %s", err)
+			return nil, fmt.Errorf("failed to send ND solicitation via RouterOS: %s", err)
 		}
+		return hwaddr, nil
 	}
+	return nil, nil
+}
 
+func (c *NDClient) cachedNeighbor(ip net.IP, ttl time.Duration) net.HardwareAddr {
+	c.neighborsmu.Lock()
+	defer c.neighborsmu.Unlock()
+	e, ok := c.neighbors[ip.String()]
+	if !ok || e.hwaddr == nil || time.Since(e.LastLookup) >= ttl {
+		return nil
+	}
+	return e.hwaddr
+}
+
+func (c *NDClient) recordNeighbor(ip net.IP, hwaddr net.HardwareAddr) {
+	c.neighborsmu.Lock()
+	defer c.neighborsmu.Unlock()
+	key := ip.String()
+	e, ok := c.neighbors[key]
+	if !ok {
+		if len(c.neighbors) >= neighborCacheMax {
+			var oldest *NeighborEntry
+			for _, n := range c.neighbors {
+				if oldest == nil || n.LastLookup.Before(oldest.LastLookup) {
+					oldest = n
+				}
+			}
+			delete(c.neighbors, oldest.IP)
+		}
+		e = &NeighborEntry{IP: key}
+		c.neighbors[key] = e
+	}
+	e.hwaddr = hwaddr
+	e.MAC = ""
 	if hwaddr != nil {
-		if c.cfg.mode != "static" {
-			llog.Debug("SOLICITATION SUCCUSSFUL! %s is at %s", targetIP, hwaddr)
+		e.MAC = hwaddr.String()
+	}
+	e.LastLookup = time.Now()
+	e.Lookups++
+}
+
+func (c *NDClient) observeTarget(ip net.IP, ifname string) {
+	c.neighborsmu.Lock()
+	defer c.neighborsmu.Unlock()
+	now := time.Now()
+	c.observed[ip.String()+"%"+ifname] = observedTarget{ip: ip, ifname: ifname, seen: now}
+	if len(c.observed) <= neighborCacheMax {
+		return
+	}
+	for k, t := range c.observed {
+		if now.Sub(t.seen) > announceWindow {
+			delete(c.observed, k)
 		}
-		if ref.advMAC.rosIf != "" {
-			// update advMAC
-			mac, err := c.ros.GetInterfaceMAC(ref.advMAC.rosIf)
-			if err != nil {
-				llog.Warning("failed to fetch the MAC address of %s: %s", ref.advMAC.rosIf, err)
+	}
+	if len(c.observed) > neighborCacheMax {
+		// still full of recent targets (e.g. a scan)
+		oldest := ""
+		for k, t := range c.observed {
+			if oldest == "" || t.seen.Before(c.observed[oldest].seen) {
+				oldest = k
 			}
-			ref.advMAC.hwaddr = mac
-		}
-		llog.Debug("Sending out Neighbor Advertisement: targetIP=%s srcMAC=%s, dstMAC=%s", targetIP, ref.advMAC.hwaddr, srcMAC)
-		// sending out NA (synchronized)
-		na := makeICMPv6(ICMPv6Data[*layers.ICMPv6NeighborAdvertisement]{
-			SrcMAC: ref.advMAC.hwaddr,
-			DstMAC: srcMAC,
-			SrcIP:  targetIP,
-			DstIP:  srcIP,
-			Type:   136,
-			Layer: &layers.ICMPv6NeighborAdvertisement{
-				Flags:         0x40,
-				TargetAddress: targetIP,
-				Options: []layers.ICMPv6Option{
-					{Type: 2, Data: ref.advMAC.hwaddr},
-				},
-			},
-		})
-		if err := func() error {
-			c.mutex.Lock()
-			defer c.mutex.Unlock()
-			return ref.s.WriteOnce(na)
-		}(); err != nil {
-			llog.Warning("failed to send NA via %s", ref.name)
+		}
+		delete(c.observed, oldest)
+	}
+}
+
+// Announce sends unsolicited Neighbor Advertisements (with the override flag)
+// for the targets solicited within announceWindow, so that the upstream
+// router switches to this instance immediately (e.g. on failover).
+// Except in NDP_MODE=static only the targets found in the internal network
+// are announced.
+func (c *NDClient) Announce(ctx context.Context) int {
+	cfg := c.config()
+	c.neighborsmu.Lock()
+	var targets []observedTarget
+	for _, t := range c.observed {
+		if time.Since(t.seen) <= announceWindow {
+			targets = append(targets, t)
+		}
+	}
+	c.neighborsmu.Unlock()
+	c.mutex.Lock()
+	refs := make(map[string]SockRef, len(c.extSocks))
+	for k, sr := range c.extSocks {
+		refs[k] = sr
+	}
+	c.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	var announced atomic.Int32
+	sem := make(chan struct{}, 16)
+	for _, t := range targets {
+		ref, ok := refs[t.ifname]
+		if !ok || ref.s == nil || !ref.s.isValid {
+			continue
+		}
+		t := t
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if cfg.mode != "static" {
+				if hwaddr, err := c.Lookup(ctx, t.ip); err != nil || hwaddr == nil {
+					return
+				}
+			}
+			allNodesIP := net.ParseIP("ff02::1")
+			allNodesMAC := net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1}
+			if err := c.sendNA(ctx, &ref, t.ip, allNodesMAC, allNodesIP, naFlagOverride); err != nil {
+				ndlog.With("interface", t.ifname, "target", t.ip).Warning("failed to send unsolicited NA via %s: %s", t.ifname, err)
+				return
+			}
+			announced.Add(1)
+		}()
+	}
+	wg.Wait()
+	ndlog.Info("Announced %d target(s) with unsolicited Neighbor Advertisements", announced.Load())
+	return int(announced.Load())
+}
+
+// Neighbors returns a snapshot of the neighbor cache
+func (c *NDClient) Neighbors() []NeighborEntry {
+	c.neighborsmu.Lock()
+	defer c.neighborsmu.Unlock()
+	entries := make([]NeighborEntry, 0, len(c.neighbors))
+	for _, e := range c.neighbors {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].IP < entries[j].IP
+	})
+	return entries
+}
+
+// FlushNeighbors empties the neighbor cache and returns the number of entries removed
+func (c *NDClient) FlushNeighbors() int {
+	c.neighborsmu.Lock()
+	defer c.neighborsmu.Unlock()
+	n := len(c.neighbors)
+	c.neighbors = make(map[string]*NeighborEntry)
+	return n
+}
+
+// Sockets returns the sockets opened by the worker
+func (c *NDClient) Sockets() []SocketStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var status []SocketStatus
+	for role, socks := range map[string]map[string]SockRef{"nd-external": c.extSocks, "nd-internal": c.intSocks} {
+		for _, sr := range socks {
+			st := SocketStatus{
+				Role:      role,
+				Interface: sr.name,
+				Valid:     sr.s != nil && sr.s.isValid,
+			}
+			if sr.advMAC.rosIf != "" {
+				st.AdvMAC = "@" + sr.advMAC.rosIf
+			} else if sr.advMAC.hwaddr != nil {
+				st.AdvMAC = sr.advMAC.hwaddr.String()
+			}
+			status = append(status, st)
+		}
+	}
+	sort.Slice(status, func(i, j int) bool {
+		if status[i].Role != status[j].Role {
+			return status[i].Role < status[j].Role
+		}
+		return status[i].Interface < status[j].Interface
+	})
+	return status
+}
+
+// InitInternalSockets opens the sockets used by Lookup in proxy mode
+func (c *NDClient) InitInternalSockets() error {
+	cfg := c.config()
+	if cfg.mode != "proxy" {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var err error
+	c.intSocks, err = initSockGroup(c.intSocks, cfg.intIfs, bpfICMPv6(136), nil) // Neighbor Advertisement
+	return err
+}
+
+func (c *NDClient) processNd(ctx context.Context, targetIP net.IP, srcMAC net.HardwareAddr, srcIP net.IP, ref *SockRef) {
+	cfg := c.config()
+	nslog := ndlog.With("interface", ref.name, "target", targetIP)
+	start := time.Now()
+	hwaddr, err := c.Lookup(ctx, targetIP)
+	metricNDLookupDuration.ObserveSince(start, cfg.mode)
+	if err != nil {
+		nslog.Warning("%s", err)
+	}
+
+	if hwaddr != nil {
+		if cfg.mode != "static" {
+			nslog.With("mac", hwaddr).Debug("SOLICITATION SUCCUSSFUL! %s is at %s", targetIP, hwaddr)
+		}
+		if err := c.sendNA(ctx, ref, targetIP, srcMAC, srcIP, naFlagSolicited); err != nil {
+			nslog.Warning("failed to send NA via %s", ref.name)
+			metricNDFailed.Inc(ref.name)
+		} else {
+			metricNDAnswered.Inc(ref.name)
 		}
 	} else {
-		llog.Trace("solicitation failed for %s", targetIP)
+		nslog.Trace("solicitation failed for %s", targetIP)
+		metricNDFailed.Inc(ref.name)
 	}
 }
 
-func (c *NDClient) workInternal(context.Context) error {
+// flags of Neighbor Advertisements
+const (
+	naFlagSolicited = 0x40
+	naFlagOverride  = 0x20
+)
+
+// sendNA advertises that targetIP is at the advertised MAC of ref
+func (c *NDClient) sendNA(ctx context.Context, ref *SockRef, targetIP net.IP, dstMAC net.HardwareAddr, dstIP net.IP, flags uint8) error {
+	if ref.advMAC.rosIf != "" {
+		// update advMAC
+		mac, err := c.ros.GetInterfaceMAC(ctx, ref.advMAC.rosIf)
+		if err != nil {
+			ndlog.Warning("failed to fetch the MAC address of %s: %s", ref.advMAC.rosIf, err)
+		}
+		ref.advMAC.hwaddr = mac
+	}
+	ndlog.With("interface", ref.name, "target", targetIP).Debug("Sending out Neighbor Advertisement: targetIP=%s srcMAC=%s, dstMAC=%s", targetIP, ref.advMAC.hwaddr, dstMAC)
+	// sending out NA (synchronized)
+	na := makeICMPv6(ICMPv6Data[*layers.ICMPv6NeighborAdvertisement]{
+		SrcMAC: ref.advMAC.hwaddr,
+		DstMAC: dstMAC,
+		SrcIP:  targetIP,
+		DstIP:  dstIP,
+		Type:   136,
+		Layer: &layers.ICMPv6NeighborAdvertisement{
+			Flags:         flags,
+			TargetAddress: targetIP,
+			Options: []layers.ICMPv6Option{
+				{Type: 2, Data: ref.advMAC.hwaddr},
+			},
+		},
+	})
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return ref.s.WriteOnce(na)
+}
+
+func (c *NDClient) workInternal(ctx context.Context) error {
 	var err error
+	cfg := c.config()
 
-	// initialize external sockets (mandatory)
-	c.extSocks, err = initSockGroup(c.extSocks, c.cfg.extIfs, bpfND(), c.cfg.advMACs)
+	// solicitations of the previous run may still use the sockets
+	c.inflight.Wait()
+	err = func() error {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		pruneSockGroup(c.extSocks, cfg.extIfs, cfg.advMACs)
+		if cfg.mode == "proxy" {
+			pruneSockGroup(c.intSocks, cfg.intIfs, nil)
+		} else {
+			pruneSockGroup(c.intSocks, nil, nil)
+		}
+
+		// initialize external sockets (mandatory)
+		var err error
+		c.extSocks, err = initSockGroup(c.extSocks, cfg.extIfs, bpfND(), cfg.advMACs)
+		return err
+	}()
 	if err != nil {
 		return err
 	}
 	// initialize internal sockets (if necessarry)
-	if c.cfg.mode == "proxy" {
-		c.intSocks, err = initSockGroup(c.intSocks, c.cfg.intIfs, bpfICMPv6(136), nil) // Neighbor Advertisement
-		if err != nil {
-			return err
-		}
+	if err := c.InitInternalSockets(); err != nil {
+		return err
 	}
 
 	// nd receive loop
@@ -230,24 +556,33 @@ func (c *NDClient) workInternal(context.Context) error {
 		extSocks[i] = s.s
 		i++
 	}
+	poll := socketPollInterval
 main:
 	for {
-		si, packet, err := ReadMultiSocksOnce(extSocks, nil)
+		si, packet, err := ReadMultiSocksOnce(extSocks, &poll)
 		if err != nil {
 			return err
 		}
+		if si < 0 {
+			// timed out, check ctx
+			if ctx.Err() != nil {
+				return fmt.Errorf("canceled by context")
+			}
+			continue
+		}
 		sr := extSockRefs[si]
 		nd := ICMPv6Data[*layers.ICMPv6NeighborSolicitation]{}
 		if err := parseICMPv6(packet, &nd); err != nil {
-			llog.Warning("failed to parse ND Solicitation: %s", err)
+			ndlog.Warning("failed to parse ND Solicitation: %s", err)
 			continue
 		}
 		targetIP := nd.Layer.TargetAddress
-		llog.Debug("Received an nd solicitation: targetIP=%s srcMAC=%s", targetIP.String(), nd.SrcMAC.String())
+		ndlog.With("interface", sr.name, "target", targetIP, "src_mac", nd.SrcMAC).Debug("Received an nd solicitation: targetIP=%s srcMAC=%s", targetIP.String(), nd.SrcMAC.String())
+		metricNDReceived.Inc(sr.name)
 
 		// check whether in specified prefixes
 		validPrefix := false
-		for _, prefix := range c.cfg.prefixes {
+		for _, prefix := range cfg.prefixes {
 			pfip := c.ra.ResolveFIP(prefix)
 			if pfip == nil {
 				continue
@@ -258,44 +593,68 @@ main:
 			}
 		}
 		if !validPrefix {
+			metricNDExcluded.Inc(sr.name, "prefix")
 			continue
 		}
 
 		//  check exclude ip
-		for _, exclude := range c.cfg.excludes {
+		for _, exclude := range cfg.excludes {
 			efip := c.ra.ResolveFIP(exclude)
 			if efip == nil {
 				continue
 			}
 			if efip.Contains(targetIP) {
-				llog.Debug("excluding %s", targetIP.String())
+				ndlog.With("interface", sr.name, "target", targetIP).Debug("excluding %s", targetIP.String())
+				metricNDExcluded.Inc(sr.name, "exclude")
 				continue main
 			}
 		}
 
-		go c.processNd(targetIP, nd.SrcMAC, nd.SrcIP, &sr)
+		// remembered to announce the target on failover
+		c.observeTarget(targetIP, sr.name)
+		if c.leader != nil && !c.leader.IsActive() {
+			ndlog.With("interface", sr.name, "target", targetIP).Trace("not answering since this instance is standby")
+			metricNDStandby.Inc(sr.name)
+			continue
+		}
+
+		c.inflight.Add(1)
+		go func() {
+			defer c.inflight.Done()
+			// a bug in handling one solicitation must not take down the worker
+			defer func() {
+				if r := recover(); r != nil {
+					ndlog.Error("panic while processing nd solicitation for %s: %v\n%s", targetIP, r, debug.Stack())
+				}
+			}()
+			c.processNd(ctx, targetIP, nd.SrcMAC, nd.SrcIP, &sr)
+		}()
 	}
 }
 
+// Work runs until ctx is done or an error occurs. It is restarted by Supervisor
+// keeping the sockets of the previous run.
 func (c *NDClient) Work(ctx context.Context) error {
-	for {
-		err := c.workInternal(ctx)
-		if err != nil {
-			// check ctx
-			select {
-			case <-ctx.Done():
-				return err
-			default:
+	return c.workInternal(ctx)
+}
+
+// Close waits for in-flight solicitations and closes the sockets.
+// Work must have returned.
+func (c *NDClient) Close() {
+	c.inflight.Wait()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, socks := range []map[string]SockRef{c.extSocks, c.intSocks} {
+		for _, sr := range socks {
+			if sr.s != nil {
+				_ = sr.s.Close()
 			}
-			// holdoff timer
-			llog.Warning("NDProxy Worker failed: %s", err)
-			llog.Warning("Waiting 10s to avoid error bursting")
 		}
-		<-time.After(time.Second * 10)
 	}
 }
 
 func (c *NDClient) solicitInternal(ip net.IP) (net.HardwareAddr, error) {
+	cfg := c.config()
 	socks := make([]*Socket, len(c.intSocks))
 	sockRefs := make([]SockRef, len(c.intSocks))
 	// send nd
@@ -316,7 +675,7 @@ func (c *NDClient) solicitInternal(ip net.IP) (net.HardwareAddr, error) {
 			},
 		})
 		s.s.FlushAll()
-		llog.Trace("  sending out nd via %s", s.name)
+		ndlog.Trace("  sending out nd via %s", s.name)
 		if err := s.s.WriteOnce(packet); err != nil {
 			return nil, err
 		}
@@ -326,9 +685,9 @@ func (c *NDClient) solicitInternal(ip net.IP) (net.HardwareAddr, error) {
 	}
 	// wait for na
 	var remain *time.Duration
-	if c.cfg.timeoutMs != 0 {
+	if cfg.timeoutMs != 0 {
 		remain = new(time.Duration)
-		*remain = time.Millisecond * time.Duration(c.cfg.timeoutMs)
+		*remain = time.Millisecond * time.Duration(cfg.timeoutMs)
 	}
 	for remain == nil || *remain > 0 {
 		var na ICMPv6Data[*layers.ICMPv6NeighborAdvertisement]
@@ -344,7 +703,7 @@ func (c *NDClient) solicitInternal(ip net.IP) (net.HardwareAddr, error) {
 		}
 		err = parseICMPv6(packet, &na)
 		if err != nil {
-			llog.Warning("  failed to parse na packet from %s: %+v", sockRefs[si].name, packet)
+			ndlog.Warning("  failed to parse na packet from %s: %+v", sockRefs[si].name, packet)
 			goto next
 		}
 		if !na.Layer.TargetAddress.Equal(ip) {
@@ -357,6 +716,6 @@ func (c *NDClient) solicitInternal(ip net.IP) (net.HardwareAddr, error) {
 		*remain -= time.Now().Sub(start)
 	}
 
-	llog.Trace("  nd solicitation timed out after %d ms", c.cfg.timeoutMs)
+	ndlog.Trace("  nd solicitation timed out after %d ms", cfg.timeoutMs)
 	return nil, nil
 }
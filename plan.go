@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-routeros/routeros"
+	"github.com/go-routeros/routeros/proto"
+)
+
+var errPlanCompleted = fmt.Errorf("dry-run plan completed")
+
+// ROSPlanChange is a write command which would have been sent to RouterOS
+type ROSPlanChange struct {
+	Action string            `json:"action"`
+	Path   string            `json:"path"`
+	ID     string            `json:"id,omitempty"`
+	Before map[string]string `json:"before,omitempty"`
+	After  map[string]string `json:"after,omitempty"`
+}
+
+// ROSPlan records write commands instead of executing them (ROS_DRY_RUN)
+type ROSPlan struct {
+	changes []ROSPlanChange
+	seq     int
+	mutex   sync.Mutex
+}
+
+func isROSWriteCommand(cmd string) bool {
+	switch path.Base(cmd) {
+	case "add", "set", "remove":
+		return true
+	}
+	return false
+}
+
+func (p *ROSPlan) record(ctx context.Context, c *ROSClient, args []string) (*routeros.Reply, error) {
+	change := ROSPlanChange{
+		Action: path.Base(args[0]),
+		Path:   path.Dir(args[0]),
+	}
+	props := make(map[string]string)
+	for _, arg := range args[1:] {
+		kv := strings.SplitN(strings.TrimPrefix(arg, "="), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		if kv[0] == ".id" {
+			change.ID = kv[1]
+		} else {
+			props[kv[0]] = kv[1]
+		}
+	}
+
+	// fetch current state for diff
+	if change.ID != "" {
+		rep, err := c.RunArgs(ctx, []string{
+			change.Path + "/print",
+			fmt.Sprintf("?.id=%s", change.ID),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(rep.Re) > 0 {
+			change.Before = make(map[string]string)
+			for k, v := range rep.Re[0].Map {
+				if k == ".id" {
+					continue
+				}
+				// only show properties which are going to be changed
+				if _, ok := props[k]; ok || change.Action == "remove" {
+					change.Before[k] = v
+				}
+			}
+		}
+	}
+	if change.Action != "remove" {
+		change.After = props
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if change.Action == "add" {
+		p.seq++
+		change.ID = fmt.Sprintf("*planned%d", p.seq)
+	}
+	roslog.Debug("Planned RouterOS command: %s", args)
+	p.changes = append(p.changes, change)
+
+	return &routeros.Reply{
+		Done: &proto.Sentence{Word: "!done", Map: map[string]string{"ret": change.ID}},
+	}, nil
+}
+
+// Take returns the recorded changes and resets the plan
+func (p *ROSPlan) Take() []ROSPlanChange {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	changes := p.changes
+	p.changes = nil
+	p.seq = 0
+	return changes
+}
+
+func formatProps(props map[string]string) string {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%q", k, props[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+func (ch ROSPlanChange) String() string {
+	switch ch.Action {
+	case "add":
+		return fmt.Sprintf("+ %s add %s", ch.Path, formatProps(ch.After))
+	case "remove":
+		return fmt.Sprintf("- %s remove .id=%s (%s)", ch.Path, ch.ID, formatProps(ch.Before))
+	default:
+		diffs := []string{}
+		keys := make([]string, 0, len(ch.After))
+		for k := range ch.After {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			diffs = append(diffs, fmt.Sprintf("%s: %q -> %q", k, ch.Before[k], ch.After[k]))
+		}
+		return fmt.Sprintf("~ %s set .id=%s %s", ch.Path, ch.ID, strings.Join(diffs, ", "))
+	}
+}
+
+// printPlan logs the plan. With asJSON (ROS_DRY_RUN=exit and plan) it is also
+// printed to stdout as JSON, which would be mixed into the log stream of a
+// running daemon otherwise.
+func printPlan(changes []ROSPlanChange, asJSON bool) {
+	if len(changes) == 0 {
+		llog.Info("RouterOS plan: no changes. RouterBoard is in desired state")
+	} else {
+		llog.Info("RouterOS plan: %d change(s)", len(changes))
+		for _, ch := range changes {
+			llog.Info("  %s", ch)
+		}
+	}
+
+	if !asJSON {
+		return
+	}
+	if changes == nil {
+		changes = []ROSPlanChange{}
+	}
+	b, err := json.MarshalIndent(struct {
+		Changes []ROSPlanChange `json:"changes"`
+	}{changes}, "", "  ")
+	if err != nil {
+		llog.Warning("failed to encode plan: %s", err)
+		return
+	}
+	fmt.Println(string(b))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestPrintPlan(t *testing.T) {
+	capture := func(asJSON bool) string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := os.Stdout
+		os.Stdout = w
+		printPlan([]ROSPlanChange{{Action: "add", Path: "/ipv6/route", ID: "*planned1", After: map[string]string{"gateway": "fe80::1%vlanflets"}}}, asJSON)
+		os.Stdout = stdout
+		w.Close()
+		out, _ := io.ReadAll(r)
+		return string(out)
+	}
+
+	// observe only logs
+	if out := capture(false); out != "" {
+		t.Fatalf("plan was printed to stdout: %s", out)
+	}
+	var plan struct {
+		Changes []ROSPlanChange `json:"changes"`
+	}
+	if err := json.Unmarshal([]byte(capture(true)), &plan); err != nil || len(plan.Changes) != 1 || plan.Changes[0].ID != "*planned1" {
+		t.Fatalf("unexpected plan JSON: %+v, %v", plan, err)
+	}
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net"
+	"os"
+	"reflect"
 	"sync"
 	"time"
 
@@ -14,22 +19,39 @@ type FlexibleIP struct {
 	raPrefix bool
 	ip       net.IP
 	cidr     int
+	// ra-prefix/<subnetLength>#<subnetIndex> selects a subnet of the prefix
+	subnetLength int // 0 if not specified
+	subnetIndex  uint64
 }
 
 func (f FlexibleIP) String() string {
+	base := "ra-prefix"
+	if f.subnetLength != 0 {
+		base = fmt.Sprintf("ra-prefix/%d#%d", f.subnetLength, f.subnetIndex)
+	}
 	if f.ip == nil {
-		return "ra-prefix"
+		return base
 	}
 	if f.cidr == -1 {
-		return fmt.Sprintf("ra-prefix%s", f.ip)
+		return fmt.Sprintf("%s%s", base, f.ip)
 	}
-	return fmt.Sprintf("ra-prefix%s/%d", f.ip, f.cidr)
+	return fmt.Sprintf("%s%s/%d", base, f.ip, f.cidr)
 }
 
 type ROSIPAssign struct {
 	ip      FlexibleIP
 	ifname  string
 	options ROSIPOptions
+	// fill the host bits with an RFC 7217 interface identifier
+	stablePrivacy bool
+}
+
+// key identifies the RouterOS address created for the assignment
+func (a ROSIPAssign) key() string {
+	if a.stablePrivacy {
+		return a.ip.String() + ":stable-privacy"
+	}
+	return a.ip.String()
 }
 
 type ROSPoolAssign struct {
@@ -38,6 +60,11 @@ type ROSPoolAssign struct {
 	prefixLength int
 }
 
+type ROSAddressListAssign struct {
+	ip   FlexibleIP
+	list string
+}
+
 type RAConfig struct {
 	mode      string
 	extIfs    []string
@@ -46,66 +73,186 @@ type RAConfig struct {
 	rosExtIPs []ROSIPAssign
 	rosIntIPs []ROSIPAssign
 	rosPools  []ROSPoolAssign
+	rosLists  []ROSAddressListAssign
+	rosGC     string
+
+	reconcileInterval   time.Duration
+	cleanupOnExit       string
+	stateFile           string // RouterInfo is saved to and restored from
+	stablePrivacySecret string // key of the stable-privacy interface identifiers
 }
 
 type RAClient struct {
 	cfg        *RAConfig
-	ros        *ROSClient
+	cfgmu      sync.RWMutex
+	ros        RouterBackend
 	extSock    *Socket
+	extSockIfs []string // RA_EXTERNAL_INTERFACES extSock was opened with
 	routerInfo *RouterInfo
 	infomu     sync.RWMutex
+	handlers   []RouterInfoHandler
+	leader     Leadership // reconciles and notifies only while active (nil: always)
+
+	reconcilemu   sync.Mutex
+	lastReconcile *ReconcileResult
+	stateSaved    time.Time // last write of RA_STATE_FILE
+}
+
+type ReconcileResult struct {
+	Trigger  string        `json:"trigger"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"duration_ns"`
+	Changes  int           `json:"changes"`
+	Planned  int           `json:"planned,omitempty"` // changes recorded instead in dry-run mode
+	Errors   []string      `json:"errors"`
 }
 
 type RouterInfo struct {
 	prefix  net.IPNet
 	gateway net.IP
+
+	received          time.Time
+	validLifetime     time.Duration // of the prefix. infiniteLifetime if unlimited
+	preferredLifetime time.Duration
+	routerLifetime    time.Duration
+	// restored from RA_STATE_FILE and not confirmed by an RA yet
+	provisional bool
+}
+
+// RouterInfoEvent is emitted on the initial solicitation ("solicited")
+// and whenever a received RA differs from the current RouterInfo ("changed").
+type RouterInfoEvent struct {
+	Reason string
+	Time   time.Time
+	Old    *RouterInfo
+	New    *RouterInfo
+}
+
+type RouterInfoHandler interface {
+	HandleRouterInfo(ev RouterInfoEvent)
 }
 
 func dumpRAConfig(cfg *RAConfig) {
-	llog.Debug("Router Advertisement Configuration:")
-	llog.Debug("  RA_MODE=%s", cfg.mode)
+	ralog.Debug("Router Advertisement Configuration:")
+	ralog.Debug("  RA_MODE=%s", cfg.mode)
 	if len(cfg.extIfs) > 0 {
-		llog.Debug("  RA_EXTERNAL_INTERFACES=%+v", cfg.extIfs)
+		ralog.Debug("  RA_EXTERNAL_INTERFACES=%+v", cfg.extIfs)
 	}
 	if cfg.timeout != 0 {
-		llog.Debug("  RA_TIMEOUT=%d", cfg.timeout/time.Millisecond)
+		ralog.Debug("  RA_TIMEOUT=%d", cfg.timeout/time.Millisecond)
 	}
 	if cfg.rosExtIf != "" {
-		llog.Debug("  RA_ROS_EXTERNAL_INTERFACE=%s", cfg.rosExtIf)
+		ralog.Debug("  RA_ROS_EXTERNAL_INTERFACE=%s", cfg.rosExtIf)
 	}
 	if len(cfg.rosExtIPs) > 0 {
-		llog.Debug("  RA_ROS_EXTERNAL_IPS")
+		ralog.Debug("  RA_ROS_EXTERNAL_IPS")
 		for i, ass := range cfg.rosExtIPs {
-			llog.Debug("  %3d: %+v", i, ass)
+			ralog.Debug("  %3d: %+v", i, ass)
 		}
 	}
 	if len(cfg.rosIntIPs) > 0 {
-		llog.Debug("  RA_ROS_INTERNAL_IPS")
+		ralog.Debug("  RA_ROS_INTERNAL_IPS")
 		for i, ass := range cfg.rosIntIPs {
-			llog.Debug("  %3d: %+v", i, ass)
+			ralog.Debug("  %3d: %+v", i, ass)
 		}
 	}
-	if len(cfg.rosIntIPs) > 0 {
-		llog.Debug("  RA_ROS_INTERNAL_IPS")
-		for i, ass := range cfg.rosIntIPs {
-			llog.Debug("  %3d: %+v", i, ass)
+	if cfg.stablePrivacySecret != "" {
+		ralog.Debug("  RA_ROS_STABLE_PRIVACY_SECRET=(set)")
+	}
+	if cfg.rosGC != "" {
+		ralog.Debug("  RA_ROS_GC=%s", cfg.rosGC)
+	}
+	if cfg.reconcileInterval != 0 {
+		ralog.Debug("  RA_ROS_RECONCILE_INTERVAL=%d", cfg.reconcileInterval/time.Second)
+	}
+	if cfg.stateFile != "" {
+		ralog.Debug("  RA_STATE_FILE=%s", cfg.stateFile)
+	}
+	if cfg.cleanupOnExit != "" {
+		ralog.Debug("  CLEANUP_ON_EXIT=%s", cfg.cleanupOnExit)
+	}
+	if len(cfg.rosPools) > 0 {
+		ralog.Debug("  RA_ROS_POOLS")
+		for i, ass := range cfg.rosPools {
+			ralog.Debug("  %3d: %+v", i, ass)
+		}
+	}
+	if len(cfg.rosLists) > 0 {
+		ralog.Debug("  RA_ROS_ADDRESS_LISTS")
+		for i, ass := range cfg.rosLists {
+			ralog.Debug("  %3d: %+v", i, ass)
 		}
 	}
 }
 
-func NewRAClient(cfg *RAConfig, ros *ROSClient) *RAClient {
+func NewRAClient(cfg *RAConfig, ros RouterBackend) *RAClient {
 	return &RAClient{
 		cfg: cfg,
 		ros: ros,
 	}
 }
 
+func (c *RAClient) config() *RAConfig {
+	c.cfgmu.RLock()
+	defer c.cfgmu.RUnlock()
+	return c.cfg
+}
+
+// Reload replaces the configuration. RouterOS objects are updated by the
+// next reconciliation. It returns true when Work has to be restarted to
+// reopen the socket or to reschedule the periodic reconciliation.
+func (c *RAClient) Reload(cfg *RAConfig) bool {
+	c.cfgmu.Lock()
+	defer c.cfgmu.Unlock()
+	old := c.cfg
+	c.cfg = cfg
+	return !reflect.DeepEqual(old.extIfs, cfg.extIfs) || old.reconcileInterval != cfg.reconcileInterval
+}
+
+func (c *RAClient) AddHandler(h RouterInfoHandler) {
+	c.handlers = append(c.handlers, h)
+}
+
+// SetLeadership makes the client reconcile and run the handlers only while l is active
+func (c *RAClient) SetLeadership(l Leadership) {
+	c.leader = l
+}
+
+func (c *RAClient) standby() bool {
+	return c.leader != nil && !c.leader.IsActive()
+}
+
+func (c *RAClient) notify(reason string, old *RouterInfo, new *RouterInfo) {
+	if c.standby() {
+		ralog.Debug("Skipping handlers for %s event since this instance is standby", reason)
+		return
+	}
+	if c.ros != nil && c.ros.DryRunMode() != "off" && len(c.handlers) > 0 {
+		ralog.Info("Skipping hooks for %s event since ROS_DRY_RUN is enabled", reason)
+		return
+	}
+	ev := RouterInfoEvent{
+		Reason: reason,
+		Time:   time.Now(),
+		Old:    old,
+		New:    new,
+	}
+	for _, h := range c.handlers {
+		h.HandleRouterInfo(ev)
+	}
+}
+
 func (c *RAClient) initSock() error {
+	cfg := c.config()
 	if c.extSock != nil && c.extSock.isValid {
-		return nil
+		if reflect.DeepEqual(c.extSockIfs, cfg.extIfs) {
+			return nil
+		}
+		ralog.Info("Reopening the RA socket since RA_EXTERNAL_INTERFACES has changed")
+		_ = c.extSock.Close()
 	}
 
-	extif, err := findFirstInterface(c.cfg.extIfs)
+	extif, err := findFirstInterface(cfg.extIfs)
 	if err != nil {
 		return err
 	}
@@ -121,33 +268,32 @@ func (c *RAClient) initSock() error {
 		return err
 	}
 
+	c.infomu.Lock()
 	c.extSock = extsock
+	c.infomu.Unlock()
+	c.extSockIfs = cfg.extIfs
 
 	return nil
 }
 
 func (c *RAClient) receive(ctx context.Context, timeout bool) (*RouterInfo, error) {
+	cfg := c.config()
 	var info RouterInfo
 	var rapacket []byte
 	var to *time.Duration
 
 	if timeout {
-		to = &c.cfg.timeout
+		to = &cfg.timeout
 	}
 	timeoutStr := "nil"
 	if to != nil {
 		timeoutStr = fmt.Sprintf("%dms", *to/time.Millisecond)
 	}
 
-	llog.Debug("Waiting for router advertisement on %s (timeout=%s)", c.extSock.netif.Name, timeoutStr)
-	select {
-	case result := <-c.extSock.ReadOnceChan(to):
-		if result.err != nil {
-			return nil, result.err
-		}
-		rapacket = result.data
-	case <-ctx.Done():
-		return nil, fmt.Errorf("canceled by context")
+	ralog.Debug("Waiting for router advertisement on %s (timeout=%s)", c.extSock.netif.Name, timeoutStr)
+	rapacket, err := c.extSock.ReadContext(ctx, to)
+	if err != nil {
+		return nil, err
 	}
 
 	ra := &ICMPv6Data[*layers.ICMPv6RouterAdvertisement]{}
@@ -155,26 +301,37 @@ func (c *RAClient) receive(ctx context.Context, timeout bool) (*RouterInfo, erro
 		return nil, err
 	}
 	info.gateway = ra.SrcIP
+	info.received = time.Now()
+	info.routerLifetime = time.Duration(ra.Layer.RouterLifetime) * time.Second
 	for _, opt := range ra.Layer.Options {
 		if opt.Type == 3 {
 			length := int(opt.Data[0])
 			ip := net.IP(opt.Data[14:30])
 			info.prefix = net.IPNet{IP: ip, Mask: net.CIDRMask(length, 128)}
+			info.validLifetime = lifetimeFromRA(binary.BigEndian.Uint32(opt.Data[2:6]))
+			info.preferredLifetime = lifetimeFromRA(binary.BigEndian.Uint32(opt.Data[6:10]))
 		}
 	}
-	llog.Debug("Received a router advertisement: gateway=%s prefix=%s", info.gateway.String(), info.prefix.String())
+	ralog.With("interface", c.extSock.netif.Name).Debug("Received a router advertisement: gateway=%s prefix=%s", info.gateway.String(), info.prefix.String())
+	metricRAReceived.Inc(c.extSock.netif.Name)
 
 	return &info, nil
 }
 
 func (c *RAClient) soilicit(ctx context.Context) error {
 	if c.routerInfo != nil {
+		if c.routerInfo.provisional {
+			// confirmed or replaced by the advertisement received in Work
+			if err := c.Resolicit(); err != nil {
+				ralog.Warning("Failed to send Router Solicitation: %s", err)
+			}
+		}
 		return nil
 	}
 
 	for {
 		// send solicitation
-		llog.Debug("Sending out Router Solicitation via %s", c.extSock.netif.Name)
+		ralog.Debug("Sending out Router Solicitation via %s", c.extSock.netif.Name)
 		rs := makeRouterSolicitation(c.extSock.LinkLocal(), c.extSock.netif.HardwareAddr)
 		if err := c.extSock.WriteOnce(rs); err != nil {
 			return err
@@ -187,57 +344,346 @@ func (c *RAClient) soilicit(ctx context.Context) error {
 		if rinfo.prefix.IP == nil {
 			return fmt.Errorf("Router did not return a prefix")
 		}
-		llog.Info("Router solicited: prefix=%s gateway=%s", rinfo.prefix.String(), rinfo.gateway.String())
-		func() {
-			c.infomu.Lock()
-			defer c.infomu.Unlock()
-			c.routerInfo = rinfo
-		}()
+		ralog.Info("Router solicited: prefix=%s gateway=%s", rinfo.prefix.String(), rinfo.gateway.String())
+		c.setRouterInfo(rinfo)
+		c.saveState(rinfo, true)
 		break
 	}
 
 	return nil
 }
 
-func (c *RAClient) reconcile() {
-	if c.cfg.mode == "ros" {
-		// apply ros config
-		if c.cfg.rosExtIf != "" {
-			if err := c.ros.SetIPv6Gateway(c.cfg.rosExtIf, c.routerInfo.gateway); err != nil {
-				llog.Warning("ros.SetIPv6Gateway failed: %s", err)
-			}
+func (c *RAClient) reconcile(ctx context.Context, trigger string) {
+	if c.config().mode != "ros" {
+		return
+	}
+	// a dry run changes nothing, so it plans on a standby instance as well
+	if c.standby() && c.ros.DryRunMode() == "off" {
+		ralog.Debug("Skipping %s reconciliation since this instance is standby", trigger)
+		return
+	}
+	c.reconcilemu.Lock()
+	defer c.reconcilemu.Unlock()
+	// taken after the lock to apply a configuration reloaded meanwhile
+	cfg := c.config()
+
+	rinfo := c.RouterInfo()
+	result := &ReconcileResult{
+		Trigger: trigger,
+		Time:    time.Now(),
+	}
+	writes := c.ros.WriteCount()
+
+	// apply ros config, remembering which objects are desired
+	desired := make(map[string]bool)
+	keep := func(path string, id string, err error) bool {
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			return false
 		}
-		for _, eip := range c.cfg.rosExtIPs {
-			ip := c.ResolveFIP(eip.ip)
-			if err := c.ros.AssignIPv6(eip.ifname, ip, eip.ip.String(), eip.options); err != nil {
-				llog.Warning("ros.AssignIPv6(%s, %s) failed: %s", eip.ifname, ip.String(), err)
-			}
+		desired[path+id] = true
+		return true
+	}
+	// e.g. a subnet which does not fit in the prefix
+	resolved := func(err error) bool {
+		if err != nil {
+			ralog.Warning("%s", err)
+			result.Errors = append(result.Errors, err.Error())
+			return false
 		}
-		for _, iip := range c.cfg.rosIntIPs {
-			ip := c.ResolveFIP(iip.ip)
-			if err := c.ros.AssignIPv6(iip.ifname, ip, iip.ip.String(), iip.options); err != nil {
-				llog.Warning("ros.AssignIPv6(%s, %s) failed: %s", iip.ifname, ip.String(), err)
-			}
+		return true
+	}
+	if cfg.rosExtIf != "" {
+		id, err := c.ros.SetIPv6Gateway(ctx, cfg.rosExtIf, rinfo.gateway)
+		if !keep("/ipv6/route", id, err) {
+			ralog.Warning("ros.SetIPv6Gateway failed: %s", err)
 		}
-		for _, pool := range c.cfg.rosPools {
-			prefix := c.ResolveFIP(pool.ip)
-			if err := c.ros.ExportIPv6Pool(pool.poolname, *prefix, pool.prefixLength); err != nil {
-				llog.Warning("ros.ExportIPv6Pool(%s, %s, %d) failed: %s", pool.poolname, prefix.String(), pool.prefixLength, err)
-			}
+	}
+	for _, eip := range cfg.rosExtIPs {
+		ip, err := c.resolveIPAssign(cfg, eip)
+		if !resolved(err) {
+			continue
+		}
+		id, err := c.ros.AssignIPv6(ctx, eip.ifname, ip, eip.key(), eip.options)
+		if !keep("/ipv6/address", id, err) {
+			ralog.Warning("ros.AssignIPv6(%s, %s) failed: %s", eip.ifname, ip.String(), err)
+		}
+	}
+	for _, iip := range cfg.rosIntIPs {
+		ip, err := c.resolveIPAssign(cfg, iip)
+		if !resolved(err) {
+			continue
+		}
+		id, err := c.ros.AssignIPv6(ctx, iip.ifname, ip, iip.key(), iip.options)
+		if !keep("/ipv6/address", id, err) {
+			ralog.Warning("ros.AssignIPv6(%s, %s) failed: %s", iip.ifname, ip.String(), err)
+		}
+	}
+	for _, pool := range cfg.rosPools {
+		prefix, err := c.resolveFIP(pool.ip)
+		if !resolved(err) {
+			continue
+		}
+		id, err := c.ros.ExportIPv6Pool(ctx, pool.poolname, *prefix, pool.prefixLength, pool.ip.String())
+		if !keep("/ipv6/pool", id, err) {
+			ralog.Warning("ros.ExportIPv6Pool(%s, %s, %d) failed: %s", pool.poolname, prefix.String(), pool.prefixLength, err)
+		}
+	}
+	for _, al := range cfg.rosLists {
+		ip, err := c.resolveFIP(al.ip)
+		if !resolved(err) {
+			continue
+		}
+		id, err := c.ros.SetIPv6AddressList(ctx, al.list, ip, al.ip.String())
+		if !keep("/ipv6/firewall/address-list", id, err) {
+			ralog.Warning("ros.SetIPv6AddressList(%s, %s) failed: %s", al.list, ip.String(), err)
+		}
+	}
+
+	if cfg.rosGC != "off" {
+		if len(result.Errors) > 0 {
+			// an object we failed to verify might be removed by mistake
+			ralog.Warning("Skipping garbage collection of RouterOS objects since reconciliation failed")
+		} else {
+			c.collectGarbage(ctx, desired)
+		}
+	}
+
+	result.Changes = int(c.ros.WriteCount() - writes)
+	var plan []ROSPlanChange
+	if c.ros.DryRunMode() != "off" {
+		plan = c.ros.TakePlan()
+		result.Planned = len(plan)
+	}
+	result.Duration = time.Since(result.Time)
+	if trigger == "periodic" && result.Changes > 0 {
+		ralog.Info("Repaired %d drifted RouterOS object(s)", result.Changes)
+	}
+	ralog.Debug("Reconciliation finished: trigger=%s changes=%d planned=%d errors=%d duration=%s", trigger, result.Changes, result.Planned, len(result.Errors), result.Duration)
+	func() {
+		c.infomu.Lock()
+		defer c.infomu.Unlock()
+		c.lastReconcile = result
+	}()
+
+	if c.ros.DryRunMode() != "off" {
+		printPlan(plan, c.ros.DryRunMode() == "exit")
+	}
+}
+
+// reconcileJitter returns interval with +-10% jitter to avoid synchronizing
+// with other companions
+func reconcileJitter(interval time.Duration) time.Duration {
+	return interval + time.Duration(rand.Int63n(int64(interval)/5+1)) - interval/10
+}
+
+// reconcileLoop periodically re-runs reconcile to repair drift on the RouterBoard
+func (c *RAClient) reconcileLoop(ctx context.Context) {
+	cfg := c.config()
+	for {
+		select {
+		case <-time.After(reconcileJitter(cfg.reconcileInterval)):
+		case <-ctx.Done():
+			return
+		}
+		c.reconcile(ctx, "periodic")
+	}
+}
+
+// Close releases the socket. Work must have returned.
+func (c *RAClient) Close() {
+	if c.extSock != nil {
+		_ = c.extSock.Close()
+	}
+}
+
+// Cleanup removes or disables the default route and addresses owned by the
+// companion (CLEANUP_ON_EXIT) so that the RouterBoard can fall back to
+// another WAN while the companion is stopped.
+// Disabled objects are enabled again by the next reconciliation.
+func (c *RAClient) Cleanup(ctx context.Context) {
+	cfg := c.config()
+	if cfg.mode != "ros" || cfg.cleanupOnExit == "off" {
+		return
+	}
+	c.reconcilemu.Lock()
+	defer c.reconcilemu.Unlock()
+	if c.ros.DryRunMode() != "off" {
+		ralog.Info("Skipping CLEANUP_ON_EXIT in dry-run mode")
+		return
+	}
+	// the objects belong to the active peer. An active instance resigns
+	// before this on shutdown and hands them over to the peer as well.
+	if c.standby() {
+		ralog.Info("Skipping CLEANUP_ON_EXIT since this instance is standby")
+		return
+	}
+
+	objs, err := c.ros.ListOwnedObjects(ctx)
+	if err != nil {
+		ralog.Warning("ros.ListOwnedObjects failed: %s", err)
+		return
+	}
+	for _, obj := range objs {
+		if obj.path != "/ipv6/route" && obj.path != "/ipv6/address" {
+			continue
+		}
+		if cfg.cleanupOnExit == "remove" {
+			ralog.Info("Removing RouterOS object on exit: %s", obj)
+			err = c.ros.RemoveObject(ctx, obj)
+		} else {
+			ralog.Info("Disabling RouterOS object on exit: %s", obj)
+			err = c.ros.DisableObject(ctx, obj)
+		}
+		if err != nil {
+			ralog.Warning("failed to clean up %s: %s", obj, err)
+		}
+	}
+}
+
+func (c *RAClient) setRouterInfo(rinfo *RouterInfo) {
+	c.infomu.Lock()
+	defer c.infomu.Unlock()
+	c.routerInfo = rinfo
+	metricRAInfo.Reset()
+	metricRAInfo.Set(1, rinfo.prefix.String(), rinfo.gateway.String())
+}
+
+// restoreState sets the RouterInfo saved in RA_STATE_FILE as provisional.
+// It returns false when there is no usable state.
+func (c *RAClient) restoreState() bool {
+	cfg := c.config()
+	if cfg.stateFile == "" || (c.ros != nil && c.ros.DryRunMode() == "exit") {
+		return false
+	}
+	rinfo, err := readRouterInfoState(cfg.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			ralog.Debug("No saved RouterInfo in %s", cfg.stateFile)
+		} else {
+			ralog.Warning("Failed to restore RouterInfo: %s", err)
+		}
+		return false
+	}
+	if rinfo.Expired(time.Now()) {
+		ralog.Info("Ignoring the saved RouterInfo since its prefix %s expired at %s", rinfo.prefix.String(), rinfo.received.Add(rinfo.validLifetime).Format(time.RFC3339))
+		return false
+	}
+	ralog.Info("Restored RouterInfo from %s (provisional until a router advertisement is received): prefix=%s gateway=%s received=%s",
+		cfg.stateFile, rinfo.prefix.String(), rinfo.gateway.String(), rinfo.received.Format(time.RFC3339))
+	c.setRouterInfo(rinfo)
+	return true
+}
+
+// saveState writes rinfo to RA_STATE_FILE. Unless changed, the file is only
+// rewritten every stateSaveInterval to refresh the lifetimes.
+func (c *RAClient) saveState(rinfo *RouterInfo, changed bool) {
+	cfg := c.config()
+	if cfg.stateFile == "" || (!changed && time.Since(c.stateSaved) < stateSaveInterval) {
+		return
+	}
+	if err := writeRouterInfoState(cfg.stateFile, rinfo); err != nil {
+		ralog.Warning("Failed to save RouterInfo to %s: %s", cfg.stateFile, err)
+		return
+	}
+	c.stateSaved = time.Now()
+}
+
+// Socket returns the socket receiving RAs (nil before Work opens it)
+func (c *RAClient) Socket() *SocketStatus {
+	c.infomu.RLock()
+	defer c.infomu.RUnlock()
+	if c.extSock == nil {
+		return nil
+	}
+	return &SocketStatus{
+		Role:      "ra",
+		Interface: c.extSock.netif.Name,
+		Valid:     c.extSock.isValid,
+	}
+}
+
+// Resolicit sends a Router Solicitation. The advertisement is handled by the
+// running Work like an unsolicited one.
+func (c *RAClient) Resolicit() error {
+	c.infomu.RLock()
+	s := c.extSock
+	c.infomu.RUnlock()
+	if s == nil || !s.isValid {
+		return fmt.Errorf("the RA socket is not open")
+	}
+	ralog.Info("Sending out Router Solicitation via %s", s.netif.Name)
+	return s.WriteOnce(makeRouterSolicitation(s.LinkLocal(), s.netif.HardwareAddr))
+}
+
+func (c *RAClient) RouterInfo() *RouterInfo {
+	c.infomu.RLock()
+	defer c.infomu.RUnlock()
+	return c.routerInfo
+}
+
+func (c *RAClient) LastReconcile() *ReconcileResult {
+	c.infomu.RLock()
+	defer c.infomu.RUnlock()
+	return c.lastReconcile
+}
+
+// collectGarbage removes objects bearing rosCommentKey which are no longer desired
+func (c *RAClient) collectGarbage(ctx context.Context, desired map[string]bool) {
+	cfg := c.config()
+	objs, err := c.ros.ListOwnedObjects(ctx)
+	if err != nil {
+		ralog.Warning("ros.ListOwnedObjects failed: %s", err)
+		return
+	}
+	for _, obj := range objs {
+		if desired[obj.path+obj.id] {
+			continue
+		}
+		if cfg.rosGC == "dry-run" {
+			ralog.Info("Would remove stale RouterOS object (RA_ROS_GC=dry-run): %s", obj)
+			continue
+		}
+		ralog.Info("Removing stale RouterOS object: %s", obj)
+		if err := c.ros.RemoveObject(ctx, obj); err != nil && !errors.Is(err, ErrROSNotFound) {
+			ralog.Warning("ros.RemoveObject(%s) failed: %s", obj, err)
 		}
 	}
 }
 
 func (c *RAClient) workInternal(ctx context.Context) error {
+	cfg := c.config()
+	// use the saved RouterInfo until an RA arrives
+	restored := c.routerInfo == nil && c.restoreState()
 	// prepare interface
 	if err := c.initSock(); err != nil {
 		return fmt.Errorf("raInitSock failed: %s", err)
 	}
 	// resolve ra
+	solicited := c.routerInfo == nil
 	if err := c.soilicit(ctx); err != nil {
 		return fmt.Errorf("raSolicit failed: %s", err)
 	}
-	c.reconcile()
+	switch {
+	case solicited:
+		c.reconcile(ctx, "solicited")
+	case restored:
+		c.reconcile(ctx, "restored")
+	default:
+		// restarted by Supervisor (e.g. after reloading the configuration)
+		c.reconcile(ctx, "restarted")
+	}
+	if solicited {
+		c.notify("solicited", nil, c.routerInfo)
+	}
+	if c.ros != nil && c.ros.DryRunMode() == "exit" {
+		return errPlanCompleted
+	}
+	if cfg.mode == "ros" && cfg.reconcileInterval > 0 {
+		loopctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go c.reconcileLoop(loopctx)
+	}
 
 	// listen RA
 	for {
@@ -245,38 +691,43 @@ func (c *RAClient) workInternal(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
-		if rinfo.prefix.String() != c.routerInfo.prefix.String() ||
-			!rinfo.gateway.Equal(c.routerInfo.gateway) {
-			llog.Info("RouterInfo changed: prefix=%s gateway=%s", rinfo.prefix.String(), rinfo.gateway.String())
-			func() {
-				c.infomu.Lock()
-				defer c.infomu.Unlock()
-				c.routerInfo = rinfo
-			}()
-			c.reconcile()
+		old := c.routerInfo
+		switch {
+		case rinfo.prefix.String() != old.prefix.String() || !rinfo.gateway.Equal(old.gateway):
+			ralog.Info("RouterInfo changed: prefix=%s gateway=%s", rinfo.prefix.String(), rinfo.gateway.String())
+			c.setRouterInfo(rinfo)
+			metricRAPrefixChanges.Inc()
+			c.reconcile(ctx, "changed")
+			c.notify("changed", old, rinfo)
+			c.saveState(rinfo, true)
+		case old.provisional:
+			ralog.Info("Router advertisement confirmed the restored RouterInfo: prefix=%s gateway=%s", rinfo.prefix.String(), rinfo.gateway.String())
+			c.setRouterInfo(rinfo)
+			c.notify("solicited", nil, rinfo)
+			c.saveState(rinfo, true)
+		default:
+			// refresh the lifetimes
+			c.setRouterInfo(rinfo)
+			c.saveState(rinfo, false)
 		}
 	}
 }
 
+// Work runs until ctx is done or an error occurs. It is restarted by Supervisor
+// keeping the socket and RouterInfo of the previous run.
 func (c *RAClient) Work(ctx context.Context) error {
-	for {
-		err := c.workInternal(ctx)
-		if err != nil {
-			// check ctx
-			select {
-			case <-ctx.Done():
-				return err
-			default:
-			}
-			// holdoff timer
-			llog.Error("Router Advertisement Worker failed: %s", err)
-			llog.Error("Waiting 10s to avoid error bursting")
-		}
-		<-time.After(time.Second * 10)
-	}
+	return c.workInternal(ctx)
 }
 
+// ResolveFIP returns nil when fip can't be resolved (see resolveFIP)
 func (c *RAClient) ResolveFIP(fip FlexibleIP) *net.IPNet {
+	ip, _ := c.resolveFIP(fip)
+	return ip
+}
+
+// resolveFIP fails when there is no RouterInfo yet or the subnet does not
+// fit in the received prefix
+func (c *RAClient) resolveFIP(fip FlexibleIP) (*net.IPNet, error) {
 	ip := make(net.IP, 16)
 	copy([]byte(ip), []byte(fip.ip))
 
@@ -287,12 +738,17 @@ func (c *RAClient) ResolveFIP(fip FlexibleIP) *net.IPNet {
 	}()
 
 	if fip.raPrefix && rinfo == nil {
-		return nil
+		return nil, fmt.Errorf("could not resolve %s (no router info yet)", fip)
 	}
 
 	if fip.raPrefix {
 		maskedIPAssign(ip, rinfo.prefix.IP, rinfo.prefix.Mask)
 	}
+	if fip.subnetLength != 0 {
+		if err := setSubnetIndex(ip, rinfo.prefix.Mask, fip.subnetLength, fip.subnetIndex); err != nil {
+			return nil, fmt.Errorf("could not resolve %s: %s", fip, err)
+		}
+	}
 
 	var mask net.IPMask
 	if fip.cidr == -1 {
@@ -305,5 +761,34 @@ func (c *RAClient) ResolveFIP(fip FlexibleIP) *net.IPNet {
 	return &net.IPNet{
 		IP:   ip,
 		Mask: mask,
+	}, nil
+}
+
+// setSubnetIndex writes index into the bits of ip between the prefix
+// (prefixMask) and subnetLength
+func setSubnetIndex(ip net.IP, prefixMask net.IPMask, subnetLength int, index uint64) error {
+	prefixLength, _ := prefixMask.Size()
+	if subnetLength < prefixLength {
+		return fmt.Errorf("/%d is larger than the received prefix /%d", subnetLength, prefixLength)
+	}
+	if bits := subnetLength - prefixLength; bits < 64 && index >= 1<<bits {
+		return fmt.Errorf("the received prefix /%d has only %d subnet(s) of /%d", prefixLength, uint64(1)<<bits, subnetLength)
+	}
+	for i := 0; i < 64 && index>>i != 0; i++ {
+		pos := subnetLength - 1 - i // bit position from the MSB
+		if index>>i&1 != 0 {
+			ip[pos/8] |= 0x80 >> (pos % 8)
+		}
+	}
+	return nil
+}
+
+// resolveIPAssign resolves the address of a RA_ROS_*_IPS entry. Stable privacy
+// addresses are derived from the current prefix, so they change on renumbering.
+func (c *RAClient) resolveIPAssign(cfg *RAConfig, a ROSIPAssign) (*net.IPNet, error) {
+	ip, err := c.resolveFIP(a.ip)
+	if err != nil || !a.stablePrivacy {
+		return ip, err
 	}
+	return stablePrivacyAddress(ip, a.ifname, cfg.stablePrivacySecret), nil
 }
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/rosapitest"
+)
+
+func TestSubnet(t *testing.T) {
+	rac := NewRAClient(&RAConfig{mode: "ros"}, nil)
+	_, n, _ := net.ParseCIDR("2001:db8:1200::/56")
+	rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+
+	for spec, want := range map[string]string{
+		"ra-prefix/64#0":         "2001:db8:1200::/64",
+		"ra-prefix/64#3":         "2001:db8:1200:3::/64",
+		"ra-prefix/64#255":       "2001:db8:1200:ff::/64",
+		"ra-prefix/60#0x1":       "2001:db8:1200:10::/60",
+		"ra-prefix/56#0":         "2001:db8:1200::/56",
+		"ra-prefix/64#3::1/128":  "2001:db8:1200:3::1/128",
+		"ra-prefix/64#0x10::/64": "2001:db8:1200:10::/64",
+	} {
+		fip, err := ParseFlexibleIP(spec)
+		if err != nil {
+			t.Fatalf("ParseFlexibleIP(%s) failed: %s", spec, err)
+		}
+		ip, err := rac.resolveFIP(fip)
+		if err != nil || ip.String() != want {
+			t.Fatalf("%s resolved to %s (%v), expected %s", spec, ip, err, want)
+		}
+		// String() is parsed back to the same FlexibleIP
+		if again, err := ParseFlexibleIP(fip.String()); err != nil || !reflect.DeepEqual(again, fip) {
+			t.Fatalf("%s is not round-tripped by String(): %s", spec, fip)
+		}
+	}
+
+	// the subnet must fit in the received prefix
+	for _, spec := range []string{"ra-prefix/64#256", "ra-prefix/48#0", "ra-prefix/60#0x10"} {
+		fip, err := ParseFlexibleIP(spec)
+		if err != nil {
+			t.Fatalf("ParseFlexibleIP(%s) failed: %s", spec, err)
+		}
+		if ip, err := rac.resolveFIP(fip); err == nil {
+			t.Fatalf("%s resolved to %s outside the prefix", spec, ip)
+		}
+		if rac.ResolveFIP(fip) != nil {
+			t.Fatalf("ResolveFIP(%s) did not return nil", spec)
+		}
+	}
+
+	// invalid syntax
+	for _, spec := range []string{"ra-prefix/64", "ra-prefix/0#1", "ra-prefix/129#0", "ra-prefix/8#256", "ra-prefix/64#x", "ra-prefix/64#1::1/60", "ra-prefix/80#1:1::/128"} {
+		if _, err := ParseFlexibleIP(spec); err == nil {
+			t.Fatalf("ParseFlexibleIP accepted %s", spec)
+		}
+	}
+
+	// usable in every setting taking a FlexibleIP
+	if _, err := ParseROSIPAssign("ra-prefix/64#3::1/64@bridge-guest", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseROSIPAssign("ra-prefix/64#3@bridge-guest:stable-privacy", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseROSPoolAssign("ra-prefix/60#1@guest-pool/64"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseROSAddressListAssign("ra-prefix/64#3@guests"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseDDNSRecord("ra-prefix/64#3::1@guest.example.com", "example.com."); err != nil {
+		t.Fatal(err)
+	}
+
+	// a subnet outside the prefix fails the reconciliation without touching RouterOS
+	fake := NewFakeRouter()
+	cfg := &RAConfig{mode: "ros", rosGC: "on"}
+	for _, a := range []string{"ra-prefix/64#3::1/64@bridge-guest", "ra-prefix/64#256::1/64@bridge-iot"} {
+		ass, _ := ParseROSIPAssign(a, "")
+		cfg.rosIntIPs = append(cfg.rosIntIPs, ass)
+	}
+	rac = NewRAClient(cfg, fake)
+	rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+	rac.reconcile(context.Background(), "solicited")
+	rows := fake.Rows("/ipv6/address")
+	if len(rows) != 1 || rows[0]["address"] != "2001:db8:1200:3::1/64" {
+		t.Fatalf("unexpected addresses: %+v", rows)
+	}
+	if r := rac.LastReconcile(); len(r.Errors) != 1 {
+		t.Fatalf("unexpected reconcile result: %+v", r)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	setup := func(gc string) (*FakeRouter, *RAClient) {
+		fake := NewFakeRouter()
+		// owned objects no longer desired and objects of the user
+		fake.Insert("/ipv6/address", map[string]string{"interface": "bridge", "address": "2001:db8:9::2/64", "comment": rosCommentKey + " ra-prefix::2/64"})
+		fake.Insert("/ipv6/pool", map[string]string{"name": "old-pool", "prefix": "2001:db8:9::/64", "prefix-length": "64", "comment": rosCommentKey + " ra-prefix"})
+		fake.Insert("/ipv6/firewall/address-list", map[string]string{"list": "servers", "address": "2001:db8:9::10/128", "comment": rosCommentKey + " ra-prefix::10/128"})
+		fake.Insert("/ipv6/address", map[string]string{"interface": "bridge", "address": "2001:db8:ffff::1/64"})
+		fake.Insert("/ipv6/pool", map[string]string{"name": "user-pool", "prefix": "2001:db8:ffff::/64", "prefix-length": "64", "comment": "managed by hand"})
+		cfg := &RAConfig{mode: "ros", rosGC: gc}
+		eip, _ := ParseROSIPAssign("ra-prefix::1/64@bridge", "")
+		cfg.rosIntIPs = append(cfg.rosIntIPs, eip)
+		rac := NewRAClient(cfg, fake)
+		rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+		return fake, rac
+	}
+	count := func(fake *FakeRouter) int {
+		n := 0
+		for _, path := range []string{"/ipv6/address", "/ipv6/pool", "/ipv6/firewall/address-list"} {
+			n += len(fake.Rows(path))
+		}
+		return n
+	}
+
+	// off and dry-run keep the stale objects
+	for _, gc := range []string{"off", "dry-run"} {
+		fake, rac := setup(gc)
+		rac.reconcile(context.Background(), "solicited")
+		if c := count(fake); c != 6 {
+			t.Fatalf("RA_ROS_GC=%s removed objects: %d left", gc, c)
+		}
+		if r := rac.LastReconcile(); r.Changes != 1 {
+			t.Fatalf("unexpected RA_ROS_GC=%s result: %+v", gc, r)
+		}
+	}
+
+	// on removes only the stale owned objects
+	fake, rac := setup("on")
+	rac.reconcile(context.Background(), "solicited")
+	if r := rac.LastReconcile(); r.Changes != 4 || len(r.Errors) != 0 {
+		t.Fatalf("unexpected RA_ROS_GC=on result: %+v", r)
+	}
+	want := map[string]bool{"2001:db8:1::1/64": true, "2001:db8:ffff::1/64": true, "2001:db8:ffff::/64": true}
+	for _, path := range []string{"/ipv6/address", "/ipv6/pool", "/ipv6/firewall/address-list"} {
+		for _, row := range fake.Rows(path) {
+			key := row["address"]
+			if path == "/ipv6/pool" {
+				key = row["prefix"]
+			}
+			if !want[key] {
+				t.Fatalf("%s was not collected: %+v", path, row)
+			}
+		}
+	}
+	if c := count(fake); c != len(want) {
+		t.Fatalf("unexpected objects left after gc: %d", c)
+	}
+
+	// nothing is collected when the reconciliation fails
+	fake, rac = setup("on")
+	iip, _ := ParseROSIPAssign("ra-prefix/64#1::1/64@bridge-guest", "")
+	rac.config().rosIntIPs = append(rac.config().rosIntIPs, iip)
+	rac.reconcile(context.Background(), "solicited")
+	if c := count(fake); c != 6 {
+		t.Fatalf("objects were collected after a failed reconciliation: %d left", c)
+	}
+
+	// against RouterOS: ROS_DRY_RUN plans the removals, otherwise they are issued
+	srv, err := rosapitest.NewServer("fletsv6", "password")
+	if err != nil {
+		t.Fatalf("rosapitest.NewServer failed: %s", err)
+	}
+	defer srv.Close()
+	srv.Insert("/ipv6/address", map[string]string{"interface": "bridge", "address": "2001:db8:9::2/64", "comment": rosCommentKey + " ra-prefix::2/64"})
+	srv.Insert("/ipv6/address", map[string]string{"interface": "bridge", "address": "2001:db8:ffff::1/64"})
+	for _, dryRun := range []string{"observe", "off"} {
+		c, err := NewROSClient(ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", dryRun: dryRun})
+		if err != nil {
+			t.Fatalf("NewROSClient failed: %s", err)
+		}
+		rac := NewRAClient(&RAConfig{mode: "ros", rosGC: "on"}, c)
+		rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+		rac.reconcile(context.Background(), "periodic")
+		c.Close()
+		r := rac.LastReconcile()
+		switch {
+		case dryRun == "observe" && (r.Planned != 1 || srv.CommandCount("/ipv6/address/remove") != 0):
+			t.Fatalf("unexpected ROS_DRY_RUN gc: %+v", r)
+		case dryRun == "off" && (r.Changes != 1 || len(srv.Rows("/ipv6/address")) != 1):
+			t.Fatalf("unexpected gc: %+v %+v", r, srv.Rows("/ipv6/address"))
+		}
+	}
+}
+
+func TestReconcileLoop(t *testing.T) {
+	// +-10% jitter which actually varies
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		d := reconcileJitter(time.Minute)
+		if d < time.Second*54 || d > time.Second*66 {
+			t.Fatalf("jitter out of range: %s", d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 2 {
+		t.Fatalf("no jitter applied")
+	}
+
+	// drift on the RouterBoard is repaired periodically
+	fake := NewFakeRouter()
+	cfg := &RAConfig{mode: "ros", rosExtIf: "vlanflets", rosGC: "off", reconcileInterval: time.Millisecond * 50}
+	eip, _ := ParseROSIPAssign("ra-prefix::1/128@@external", cfg.rosExtIf)
+	cfg.rosExtIPs = append(cfg.rosExtIPs, eip)
+	rac := NewRAClient(cfg, fake)
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+	rac.reconcile(context.Background(), "solicited")
+	route := fake.Rows("/ipv6/route")[0]
+	// someone edits the route and removes the address
+	if err := fake.set("/ipv6/route", route[".id"], map[string]string{"gateway": "fe80::99%vlanflets"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.remove("/ipv6/address", fake.Rows("/ipv6/address")[0][".id"]); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		rac.reconcileLoop(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		if r := rac.LastReconcile(); r.Trigger == "periodic" {
+			if r.Changes != 2 || len(r.Errors) != 0 {
+				t.Fatalf("unexpected periodic reconcile result: %+v", r)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("periodic reconcile did not run")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if rows := fake.Rows("/ipv6/route"); len(rows) != 1 || rows[0]["gateway"] != "fe80::1%vlanflets" {
+		t.Fatalf("route was not repaired: %+v", rows)
+	}
+	if rows := fake.Rows("/ipv6/address"); len(rows) != 1 || rows[0]["address"] != "2001:db8:1::1/128" {
+		t.Fatalf("address was not repaired: %+v", rows)
+	}
+
+	// the loop stops with ctx
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("reconcileLoop did not stop")
+	}
+}
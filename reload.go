@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// how often CONFIG_FILE is checked for modifications
+const configWatchInterval = time.Second * 5
+
+// Reloader applies a new RA/ND configuration to the running workers.
+// Other settings (ROS_*, HOOK_*, DDNS_*, HA_*) require a restart.
+type Reloader struct {
+	racfg *RAConfig
+	ndcfg *NDConfig
+	rac   *RAClient
+	ndc   *NDClient
+	ros   RouterBackend
+	sup   *Supervisor
+}
+
+// Reload reads the configuration again and applies the difference.
+// When the new configuration is invalid the current one is kept.
+func (r *Reloader) Reload(ctx context.Context) (err error) {
+	oldEnv := fileEnv
+	defer func() {
+		if err != nil {
+			fileEnv = oldEnv
+			_ = configureLogging()
+		}
+	}()
+
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	racfg, err := loadRAConfig()
+	if err != nil {
+		return err
+	}
+	ndcfg, ndNeedROS, err := loadNDConfig(racfg)
+	if err != nil {
+		return err
+	}
+	if racfg.mode != r.racfg.mode {
+		return fmt.Errorf("RA_MODE cannot be changed without restarting (%s -> %s)", r.racfg.mode, racfg.mode)
+	}
+	if (ndcfg.mode == "off") != (r.ndcfg.mode == "off") {
+		return fmt.Errorf("NDP_MODE cannot be changed from/to off without restarting (%s -> %s)", r.ndcfg.mode, ndcfg.mode)
+	}
+	if ndNeedROS && r.ros == nil {
+		return fmt.Errorf("the new NDP settings require RouterOS API. Restart to apply them")
+	}
+
+	if !reflect.DeepEqual(r.racfg, racfg) {
+		dumpRAConfig(racfg)
+		if r.rac.Reload(racfg) {
+			r.sup.Restart(raWorkerName)
+		} else if r.rac.RouterInfo() != nil {
+			// otherwise applied on the first solicitation
+			r.rac.reconcile(ctx, "reload")
+		}
+		llog.Info("Applied the new RA configuration")
+	}
+	if r.ndc != nil && r.ndc.Reload(ndcfg) {
+		dumpNDConfig(ndcfg)
+		r.sup.Restart(ndWorkerName)
+		llog.Info("Applied the new ND configuration")
+	}
+	r.racfg, r.ndcfg = racfg, ndcfg
+
+	return nil
+}
+
+// watchConfigFile notifies changed when the modification time or the size
+// of path changes
+func watchConfigFile(ctx context.Context, path string, changed chan<- struct{}) {
+	stat := func() (time.Time, int64) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, -1
+		}
+		return fi.ModTime(), fi.Size()
+	}
+
+	mtime, size := stat()
+	for {
+		select {
+		case <-time.After(configWatchInterval):
+		case <-ctx.Done():
+			return
+		}
+		m, s := stat()
+		if m.Equal(mtime) && s == size {
+			continue
+		}
+		mtime, size = m, s
+		if s < 0 {
+			// being replaced
+			continue
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fletsv6")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/config.yml"
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	os.Setenv("CONFIG_FILE", path)
+	defer os.Unsetenv("CONFIG_FILE")
+	defer func() { fileEnv = map[string]string{} }()
+
+	write(`
+ra:
+  ros:
+    external_interface: vlanflets
+    gc: "on"
+    reconcile_interval: 0
+nd:
+  mode: "off"
+`)
+	if err := loadConfigFile(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	racfg, err := loadRAConfig()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	ndcfg, _, err := loadNDConfig(racfg)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	fake := NewFakeRouter()
+	rac := NewRAClient(racfg, fake)
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+	rac.reconcile(context.Background(), "solicited")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sup := NewSupervisor()
+	var mutex sync.Mutex
+	runs := 0
+	sup.Go(ctx, raWorkerName, func(ctx context.Context) error {
+		mutex.Lock()
+		runs++
+		mutex.Unlock()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	r := &Reloader{racfg: racfg, ndcfg: ndcfg, rac: rac, sup: sup}
+
+	// RouterOS settings are applied by reconciling without a restart
+	write(`
+ra:
+  ros:
+    external_interface: vlanflets
+    external_ips:
+      - address: ra-prefix::1/128
+        interface: "@external"
+    gc: "on"
+    reconcile_interval: 0
+nd:
+  mode: "off"
+`)
+	if err := r.Reload(ctx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if rows := fake.Rows("/ipv6/address"); len(rows) != 1 || rows[0]["address"] != "2001:db8:1::1/128" {
+		t.Fatalf("new address was not assigned: %+v", rows)
+	}
+	if r := rac.LastReconcile(); r.Trigger != "reload" {
+		t.Fatalf("unexpected reconcile result: %+v", r)
+	}
+
+	// invalid configuration is rejected keeping the current one
+	write("ra:\n  mode: bogus\n")
+	if err := r.Reload(ctx); err == nil {
+		t.Fatalf("invalid configuration was accepted")
+	}
+	write("ra:\n  mode: \"off\"\nnd:\n  mode: \"off\"\n")
+	if err := r.Reload(ctx); err == nil {
+		t.Fatalf("RA_MODE change was accepted")
+	}
+	if rac.config() != r.racfg || getenv("RA_ROS_EXTERNAL_IPS") == "" {
+		t.Fatalf("configuration was replaced by a rejected one")
+	}
+
+	// changing the interfaces restarts the worker without backoff
+	write(`
+ra:
+  external_interfaces: [eth1]
+  ros:
+    external_interface: vlanflets
+    gc: "on"
+    reconcile_interval: 0
+nd:
+  mode: "off"
+`)
+	if err := r.Reload(ctx); err != nil {
+		t.Fatalf("%s", err)
+	}
+	time.Sleep(time.Millisecond * 100)
+	mutex.Lock()
+	if runs != 2 {
+		t.Fatalf("worker was not restarted (runs=%d)", runs)
+	}
+	mutex.Unlock()
+	if st := sup.Status()[0]; st.State != "running" || st.Failures != 0 {
+		t.Fatalf("restart was counted as a failure: %+v", st)
+	}
+	if rac.config().extIfs[0] != "eth1" {
+		t.Fatalf("RA configuration was not replaced")
+	}
+
+	cancel()
+	sup.Wait(time.Second)
+}
@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"os"
+	"path"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-routeros/routeros"
@@ -20,159 +28,196 @@ type ROSConnectConfig struct {
 	username string
 	password string
 	useTLS   bool
+	protocol string
+	dryRun   string
+
+	// per-operation timeouts
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	pingTimeout  time.Duration
+	readRetries  int
+
+	// connection pool (api only)
+	poolMin            int
+	poolMax            int
+	poolIdleTimeout    time.Duration
+	poolHealthInterval time.Duration
+
+	tlsCA          string
+	tlsFingerprint string
+	tlsServerName  string
+	tlsCert        string
+	tlsKey         string
+	tlsConfig      *tls.Config
 }
 
-type ROSIPOptions struct {
-	Eui64     bool
-	Advertise bool
-}
-
-type ROSClient struct {
-	cfg  ROSConnectConfig
-	pool *ROSConnectionPool
+func rosTLSConfig(cfg ROSConnectConfig) *tls.Config {
+	if cfg.tlsConfig == nil {
+		return &tls.Config{InsecureSkipVerify: true}
+	}
+	return cfg.tlsConfig.Clone()
 }
 
-// connection pool
-type ROSConnectionPool struct {
-	cfg   ROSConnectConfig
-	cs    []*ROSConnection
-	mutex sync.Mutex
-}
-type ROSConnection struct {
-	*routeros.Client
-}
+// newROSTLSConfig builds the TLS configuration shared by the preflight check,
+// the connection pool and the REST transport.
+// Verification is done by hand since certificates generated on RouterOS
+// usually carry the name only in the CommonName, which crypto/tls ignores.
+func newROSTLSConfig(cfg ROSConnectConfig) (*tls.Config, error) {
+	if cfg.tlsCA == "" && cfg.tlsFingerprint == "" && cfg.tlsCert == "" && cfg.tlsKey == "" {
+		return nil, nil
+	}
+	if cfg.tlsCert != "" && cfg.tlsCA == "" && cfg.tlsFingerprint == "" {
+		// the client certificate would be presented to an unverified peer
+		return nil, fmt.Errorf("ROS_TLS_CERT requires ROS_TLS_CA or ROS_TLS_FINGERPRINT to verify RouterOS")
+	}
 
-func NewROSConnectionPool(cfg ROSConnectConfig) *ROSConnectionPool {
-	pool := &ROSConnectionPool{
-		cfg: cfg,
+	tcfg := &tls.Config{
+		// verified in VerifyPeerCertificate
+		InsecureSkipVerify: true,
 	}
 
-	// start keep alive
-	go func() {
-		for {
-			var err error
-			c, count := func() (*ROSConnection, int) {
-				pool.mutex.Lock()
-				defer pool.mutex.Unlock()
-				// pick a connection
-				count := len(pool.cs)
-				if count == 0 {
-					return nil, 0
-				}
-				c := pool.cs[count-1]
-				pool.cs = pool.cs[:count-1]
-				return c, count
-			}()
-			llog.Trace("Current pooled connections: %d", count)
-			if c != nil {
-				// do keepalive action
-				ch := make(chan *routeros.Reply)
-				go func() {
-					defer close(ch)
-					rep, err := c.RunArgs([]string{
-						"/system/resource/print",
-						"=.proplist=uptime",
-					})
-					if err != nil {
-						rep = nil
-					}
-					select {
-					case ch <- rep:
-					default:
-					}
-				}()
-				select {
-				case rep := <-ch:
-					if rep != nil && len(rep.Re) >= 1 {
-						// keepalive success, push back to pool
-						func() {
-							pool.mutex.Lock()
-							defer pool.mutex.Unlock()
-							pool.cs = append([]*ROSConnection{c}, pool.cs...)
-						}()
-						goto next
-					}
-					llog.Warning("ROS keepalive failed. discarding connection")
-				case <-time.After(time.Second * 5):
-					llog.Warning("ROS keepalive timed out. discarding connection")
-				}
-				// discard action
-				c.Close()
-				c = nil
-				count--
-			}
-			if count == 0 {
-				count = 1
-				c, err = pool.makeConnection()
-				if err != nil {
-					llog.Warning("failed to establish connection to RouterOS API: %s", err)
-				}
-			}
+	serverName := cfg.tlsServerName
+	if serverName == "" {
+		serverName = cfg.host
+	}
+	if net.ParseIP(serverName) == nil {
+		tcfg.ServerName = serverName
+	}
 
-		next:
-			<-time.After(time.Second * 10 / time.Duration(count))
+	var roots *x509.CertPool
+	if cfg.tlsCA != "" {
+		pem, err := os.ReadFile(cfg.tlsCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ROS_TLS_CA: %s", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ROS_TLS_CA contains no PEM certificate")
 		}
-	}()
+	}
 
-	return pool
-}
+	var fingerprint []byte
+	if cfg.tlsFingerprint != "" {
+		var err error
+		fingerprint, err = hex.DecodeString(strings.ReplaceAll(cfg.tlsFingerprint, ":", ""))
+		if err != nil || len(fingerprint) != sha256.Size {
+			return nil, fmt.Errorf("ROS_TLS_FINGERPRINT must be a SHA-256 fingerprint in hex")
+		}
+	}
 
-func (p *ROSConnectionPool) makeConnection() (*ROSConnection, error) {
-	var cl *routeros.Client
-	var err error
+	if cfg.tlsCert != "" || cfg.tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.tlsCert, cfg.tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ROS_TLS_CERT/ROS_TLS_KEY: %s", err)
+		}
+		tcfg.Certificates = []tls.Certificate{cert}
+	}
 
-	cfg := &p.cfg
-	address := fmt.Sprintf("%s:%d", cfg.host, cfg.port)
+	tcfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("RouterOS presented no certificate")
+		}
+		if fingerprint != nil {
+			sum := sha256.Sum256(rawCerts[0])
+			if !bytes.Equal(sum[:], fingerprint) {
+				return fmt.Errorf("certificate fingerprint mismatch: got %X", sum)
+			}
+		}
+		if roots == nil {
+			return nil
+		}
 
-	if cfg.useTLS {
-		cl, err = routeros.DialTLS(address, cfg.username, cfg.password, &tls.Config{InsecureSkipVerify: true})
-	} else {
-		cl, err = routeros.Dial(address, cfg.username, cfg.password)
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		inter := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			inter.AddCert(cert)
+		}
+		if _, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: inter}); err != nil {
+			return err
+		}
+		if err := certs[0].VerifyHostname(serverName); err != nil && certs[0].Subject.CommonName != serverName {
+			return err
+		}
+		return nil
 	}
 
-	if err != nil {
-		return nil, err
-	}
+	return tcfg, nil
+}
 
-	return &ROSConnection{
-		Client: cl,
-	}, nil
+// RouterBackend is the set of router operations used by RAClient and NDClient.
+// ROSClient implements it against a real RouterBoard and FakeRouter in memory.
+type RouterBackend interface {
+	GetInterfaceMAC(ctx context.Context, name string) (net.HardwareAddr, error)
+	LookupNeighbor(ctx context.Context, ip net.IP, timeoutms int, strict bool) (net.HardwareAddr, error)
+	SetIPv6Gateway(ctx context.Context, ifname string, gateway net.IP) (string, error)
+	AssignIPv6(ctx context.Context, ifname string, ip *net.IPNet, key string, options ROSIPOptions) (string, error)
+	ExportIPv6Pool(ctx context.Context, name string, cidr net.IPNet, prefixlen int, key string) (string, error)
+	SetIPv6AddressList(ctx context.Context, list string, ip *net.IPNet, key string) (string, error)
+	ListOwnedObjects(ctx context.Context) ([]ROSObject, error)
+	RemoveObject(ctx context.Context, obj ROSObject) error
+	DisableObject(ctx context.Context, obj ROSObject) error
+	Close()
+	WriteCount() int64
+	DryRunMode() string
+	TakePlan() []ROSPlanChange
 }
 
-func (p *ROSConnectionPool) Get() (*ROSConnection, error) {
-	// try established
-	p.mutex.Lock()
-	if len(p.cs) > 0 {
-		c := p.cs[0]
-		p.cs = p.cs[1:]
-		p.mutex.Unlock()
-		return c, nil
-	}
-	p.mutex.Unlock()
-	// create client
-	llog.Trace("ROSConnectionPool.Get(): Creating a new connection")
-	return p.makeConnection()
+type ROSIPOptions struct {
+	Eui64     bool
+	Advertise bool
 }
 
-func (p *ROSConnectionPool) Put(c *ROSConnection) {
-	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	p.cs = append(p.cs, c)
+type ROSClient struct {
+	cfg       ROSConnectConfig
+	transport rosTransport
+	plan      *ROSPlan
+	writes    int64
+}
+
+// rosTransport runs an API command (e.g. "/ipv6/address/print", "=.proplist=...")
+// and returns the reply in the binary API format regardless of the protocol.
+// The command must be aborted when ctx is done.
+type rosTransport interface {
+	RunArgs(ctx context.Context, args []string) (*routeros.Reply, error)
+	Close()
 }
 
+// used when a timeout of ROSConnectConfig is left zero
+const rosDefaultTimeout = time.Second * 5
+
 func NewROSClient(cfg ROSConnectConfig) (*ROSClient, error) {
+	for _, t := range []*time.Duration{&cfg.readTimeout, &cfg.writeTimeout, &cfg.pingTimeout} {
+		if *t == 0 {
+			*t = rosDefaultTimeout
+		}
+	}
+
 	c := &ROSClient{
-		cfg:  cfg,
-		pool: NewROSConnectionPool(cfg),
+		cfg: cfg,
+	}
+	if cfg.protocol == "rest" {
+		c.transport = NewROSRESTClient(cfg)
+	} else {
+		c.transport = NewROSConnectionPool(cfg)
+	}
+	if cfg.dryRun != "off" {
+		c.plan = &ROSPlan{}
 	}
 
 	// tcp dial test
 	var conn net.Conn
 	var err error
-	llog.Debug("Running preflight connectivity check for RouterOS API")
-	addr := fmt.Sprintf("%s:%d", cfg.host, cfg.port)
+	roslog.Debug("Running preflight connectivity check for RouterOS API")
+	addr := net.JoinHostPort(cfg.host, strconv.Itoa(cfg.port))
 	if cfg.useTLS {
-		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: time.Second * 5}, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: time.Second * 5}, "tcp", addr, rosTLSConfig(cfg))
 	} else {
 		conn, err = net.DialTimeout("tcp", addr, time.Second*5)
 	}
@@ -184,58 +229,80 @@ func NewROSClient(cfg ROSConnectConfig) (*ROSClient, error) {
 	return c, nil
 }
 
-func (c *ROSClient) RunArgs(args []string) (*routeros.Reply, error) {
-	conn, err := c.pool.Get()
-	if err != nil {
-		return nil, err
+func (c *ROSClient) RunArgs(ctx context.Context, args []string) (*routeros.Reply, error) {
+	cmd := args[0]
+	write := isROSWriteCommand(cmd)
+	if write && c.plan != nil {
+		return c.plan.record(ctx, c, args)
+	}
+
+	// select timeout by operation class. only reads are safe to retry
+	timeout, retries := c.cfg.writeTimeout, 0
+	switch {
+	case cmd == "/ping":
+		timeout = c.cfg.pingTimeout
+	case !write && path.Base(cmd) == "print":
+		timeout, retries = c.cfg.readTimeout, c.cfg.readRetries
 	}
-	// with timeout
-	ch := make(chan struct{})
-	var rep *routeros.Reply
-	go func() {
-		rep, err = conn.RunArgs(args)
+
+	wait := time.Millisecond * 200
+	for i := 0; ; i++ {
+		rep, err := c.runOnce(ctx, timeout, args)
+		if err == nil {
+			if write {
+				atomic.AddInt64(&c.writes, 1)
+			}
+			return rep, nil
+		}
+		var rerr *ROSError
+		if errors.As(err, &rerr) || ctx.Err() != nil || i >= retries {
+			return nil, err
+		}
+		roslog.With("command", cmd).Debug("  retrying %s in %s (%d/%d): %s", cmd, wait, i+1, retries, err)
 		select {
-		case ch <- struct{}{}:
-		default:
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, err
 		}
-	}()
-
-	select {
-	case <-ch:
-		c.pool.Put(conn)
-		return rep, err
-	case <-time.After(time.Second * 5):
-		conn.Close()
-		return nil, fmt.Errorf("RouterOS API timed out after 5s")
+		wait *= 2
 	}
 }
 
-func (c *ROSClient) makeClient() (*routeros.Client, error) {
-	cfg := &c.cfg
-	address := fmt.Sprintf("%s:%d", cfg.host, cfg.port)
-
-	if cfg.useTLS {
-		return routeros.DialTLS(address, cfg.username, cfg.password, &tls.Config{InsecureSkipVerify: true})
-	} else {
-		return routeros.Dial(address, cfg.username, cfg.password)
+func (c *ROSClient) runOnce(ctx context.Context, timeout time.Duration, args []string) (*routeros.Reply, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	rep, err := c.transport.RunArgs(ctx, args)
+	metricROSRequests.Inc(args[0])
+	metricROSDuration.ObserveSince(start, args[0])
+	if err == nil {
+		return rep, nil
 	}
-
+	metricROSErrors.Inc(args[0])
+	roslog.With("command", args[0]).Trace("  %s failed: %s", args[0], err)
+	if de, ok := err.(*routeros.DeviceError); ok {
+		return nil, classifyROSTrap(args[0], de)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("RouterOS %s timed out after %s: %w", args[0], timeout, ctx.Err())
+	}
+	return nil, err
 }
 
 func (*ROSClient) dumpResponse(rep *routeros.Reply) {
-	llog.Trace("  raw response:")
+	roslog.Trace("  raw response:")
 	if len(rep.Re) == 0 {
-		llog.Trace("    <empty>")
+		roslog.Trace("    <empty>")
 		return
 	}
 	for i, re := range rep.Re {
-		llog.Trace("  %3d: %+v", i, re.Map)
+		roslog.Trace("  %3d: %+v", i, re.Map)
 	}
 }
 
-func (c *ROSClient) GetInterfaceMAC(name string) (net.HardwareAddr, error) {
-	llog.Trace("GetInterfaceMAC(%s)", name)
-	rep, err := c.RunArgs([]string{
+func (c *ROSClient) GetInterfaceMAC(ctx context.Context, name string) (net.HardwareAddr, error) {
+	roslog.Trace("GetInterfaceMAC(%s)", name)
+	rep, err := c.RunArgs(ctx, []string{
 		"/interface/print",
 		"=.proplist=mac-address",
 		fmt.Sprintf("?name=%s", name),
@@ -250,136 +317,155 @@ func (c *ROSClient) GetInterfaceMAC(name string) (net.HardwareAddr, error) {
 	return net.ParseMAC(rep.Re[0].Map["mac-address"])
 }
 
-func (c *ROSClient) SetIPv6Gateway(ifname string, gateway net.IP) error {
-	llog.Trace("SetIPv6Gateway(%s, %s)", ifname, gateway.String())
+func (c *ROSClient) SetIPv6Gateway(ctx context.Context, ifname string, gateway net.IP) (string, error) {
+	roslog.Trace("SetIPv6Gateway(%s, %s)", ifname, gateway.String())
 
 	// check if route exists
-	llog.Trace("  fetching all IPv6 default routes")
-	rep, err := c.RunArgs([]string{
+	roslog.Trace("  fetching all IPv6 default routes")
+	rep, err := c.RunArgs(ctx, []string{
 		"/ipv6/route/print",
-		"=.proplist=.id,gateway,comment",
+		"=.proplist=.id,gateway,comment,disabled",
 		"?dst-address=::/0",
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 	c.dumpResponse(rep)
 	var modTarget string
 	for _, re := range rep.Re {
 		if re.Map["comment"] == rosCommentKey {
-			llog.Trace("  found a commented route(.id=%s) mark as modification target", re.Map[".id"])
+			roslog.Trace("  found a commented route(.id=%s) mark as modification target", re.Map[".id"])
 			modTarget = re.Map[".id"]
 		}
 		gwparts := strings.Split(re.Map["gateway"], "%")
 		if len(gwparts) != 2 {
-			llog.Trace("  gateway (%s) is unknown format. skipping", re.Map["gateway"])
+			roslog.Trace("  gateway (%s) is unknown format. skipping", re.Map["gateway"])
 			continue
 		}
 		gwip := net.ParseIP(gwparts[0])
 		if gwip == nil {
-			llog.Trace("  ip of gateway (%s) is not parsable. skipping", re.Map["gateway"])
+			roslog.Trace("  ip of gateway (%s) is not parsable. skipping", re.Map["gateway"])
 			continue
 		}
 		if gateway.Equal(gwip) && gwparts[1] == ifname {
-			llog.Trace("  found a desired default route(.id=%s)", re.Map[".id"])
-			return nil // no need to set route
+			if !isROSTrue(re.Map["disabled"]) {
+				roslog.Trace("  found a desired default route(.id=%s)", re.Map[".id"])
+				return re.Map[".id"], nil // no need to set route
+			}
+			roslog.Trace("  found a desired default route(.id=%s) but disabled", re.Map[".id"])
 		}
-		llog.Trace("%s is not a desired gateway. continuing", re.Map["gateway"])
+		roslog.Trace("%s is not a desired gateway. continuing", re.Map["gateway"])
 	}
 
 	gw := fmt.Sprintf("%s%%%s", gateway, ifname)
 	if modTarget != "" {
-		llog.Info("Updating ROS default gateway: dst-address=::/0 gateway=%s", gw)
-		rep, err = c.RunArgs([]string{
+		roslog.Info("Updating ROS default gateway: dst-address=::/0 gateway=%s", gw)
+		rep, err = c.RunArgs(ctx, []string{
 			"/ipv6/route/set",
 			fmt.Sprintf("=.id=%s", modTarget),
 			fmt.Sprintf("=gateway=%s", gw),
+			"=disabled=no",
 		})
 		if err != nil {
-			return err
+			return "", err
 		}
+		return modTarget, nil
 	} else {
-		llog.Info("Adding ROS default gateway: dst-address=::/0 gateway=%s", gw)
-		rep, err = c.RunArgs([]string{
+		roslog.Info("Adding ROS default gateway: dst-address=::/0 gateway=%s", gw)
+		rep, err = c.RunArgs(ctx, []string{
 			"/ipv6/route/add",
 			"=dst-address=::/0",
 			fmt.Sprintf("=gateway=%s", gw),
 			fmt.Sprintf("=comment=%s", rosCommentKey),
 		})
 		if err != nil {
-			return err
+			return "", err
 		}
+		return rep.Done.Map["ret"], nil
 	}
-
-	return err
 }
 
-func (c *ROSClient) ExportIPv6Pool(name string, cidr net.IPNet, prefixlen int) error {
-	llog.Trace("ExportIPv6Pool(name=%s, cidr=%s, prefixlen=%d)", name, cidr, prefixlen)
+func (c *ROSClient) ExportIPv6Pool(ctx context.Context, name string, cidr net.IPNet, prefixlen int, key string) (string, error) {
+	roslog.Trace("ExportIPv6Pool(name=%s, cidr=%s, prefixlen=%d)", name, cidr, prefixlen)
 	// check if exists
-	rep, err := c.RunArgs([]string{
+	rep, err := c.RunArgs(ctx, []string{
 		"/ipv6/pool/print",
 		fmt.Sprintf("?name=%s", name),
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
+	comment := fmt.Sprintf("%s %s", rosCommentKey, key)
 	exists := false
+	tag := false
 	var id string
 	if len(rep.Re) > 0 {
 		exists = true
 		props := rep.Re[0].Map
 		id = props[".id"]
+		// tag a pool created by hand (or before the comment was introduced)
+		// so that it can be garbage collected, but keep a foreign comment
+		tag = props["comment"] != comment && (props["comment"] == "" || isOwnedComment(props["comment"]))
 		if cidrEqual(props["prefix"], cidr) &&
-			props["prefix-length"] == fmt.Sprintf("%d", prefixlen) {
-			llog.Trace("  %s is in desired state", name)
-			return nil
+			props["prefix-length"] == fmt.Sprintf("%d", prefixlen) && !tag {
+			roslog.Trace("  %s is in desired state", name)
+			return id, nil
 		}
 	}
 
 	if exists {
-		_, err = c.RunArgs([]string{
+		roslog.Info("Updating ROS IPv6 pool: name=%s prefix=%s prefix-length=%d", name, cidr.String(), prefixlen)
+		args := []string{
 			"/ipv6/pool/set",
 			fmt.Sprintf("=.id=%s", id),
 			fmt.Sprintf("=prefix=%s", cidr.String()),
 			fmt.Sprintf("=prefix-length=%d", prefixlen),
-		})
+		}
+		if tag {
+			args = append(args, fmt.Sprintf("=comment=%s", comment))
+		}
+		_, err = c.RunArgs(ctx, args)
 	} else {
-		_, err = c.RunArgs([]string{
+		roslog.Info("Adding ROS IPv6 pool: name=%s prefix=%s prefix-length=%d", name, cidr.String(), prefixlen)
+		rep, err = c.RunArgs(ctx, []string{
 			"/ipv6/pool/add",
 			fmt.Sprintf("=name=%s", name),
 			fmt.Sprintf("=prefix=%s", cidr.String()),
 			fmt.Sprintf("=prefix-length=%d", prefixlen),
+			fmt.Sprintf("=comment=%s", comment),
 		})
+		if err == nil {
+			id = rep.Done.Map["ret"]
+		}
 	}
 
-	return err
+	return id, err
 }
 
-func (c *ROSClient) LookupNeighbor(ip net.IP, timeoutms int, strict bool) (net.HardwareAddr, error) {
-	llog.Trace("LookupNeighbor(ip=%s, timeout=%d, strict=%v)", ip, timeoutms, strict)
+func (c *ROSClient) LookupNeighbor(ctx context.Context, ip net.IP, timeoutms int, strict bool) (net.HardwareAddr, error) {
+	roslog.Trace("LookupNeighbor(ip=%s, timeout=%d, strict=%v)", ip, timeoutms, strict)
 
 	// trigger neighbor discovery by pinging
-	llog.Trace("  pinging the client (%s)", ip)
-	ping := func() (*routeros.Reply, error) {
-		return c.RunArgs([]string{
+	roslog.Trace("  pinging the client (%s)", ip)
+	ping := func(ctx context.Context) (*routeros.Reply, error) {
+		return c.RunArgs(ctx, []string{
 			"/ping",
 			fmt.Sprintf("=address=%s", ip.String()),
 			"=count=1",
 			fmt.Sprintf("=interval=00:00:0%d.%03d", timeoutms/1000, timeoutms%1000),
 		})
 	}
-	pingrep, err := ping()
+	pingrep, err := ping(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	pingSuccess := len(pingrep.Re) > 0 && pingrep.Re[0].Map["packet-loss"] == "0"
-	llog.Trace("  ping success: %v", pingSuccess)
+	roslog.Trace("  ping success: %v", pingSuccess)
 
 	// lookup neighbor entry (cached)
-	llog.Trace("  looking up the neighbor entry of %s", ip)
-	rep, err := c.RunArgs([]string{
+	roslog.Trace("  looking up the neighbor entry of %s", ip)
+	rep, err := c.RunArgs(ctx, []string{
 		"/ipv6/neighbor/print",
 		"=.proplist=mac-address,status",
 		fmt.Sprintf("?address=%s", ip.String()),
@@ -391,26 +477,26 @@ func (c *ROSClient) LookupNeighbor(ip net.IP, timeoutms int, strict bool) (net.H
 	if len(rep.Re) > 0 && (rep.Re[0].Map["status"] == "reachable" || rep.Re[0].Map["status"] == "stale") {
 		hwaddr, err := net.ParseMAC(rep.Re[0].Map["mac-address"])
 		// trigger (to update cache)
-		go ping()
+		go ping(context.Background())
 		return hwaddr, err
 	} else if !strict && pingSuccess {
-		llog.Trace("  neighbor entry not found but report success since pinging succeeded")
+		roslog.Trace("  neighbor entry not found but report success since pinging succeeded")
 		return make(net.HardwareAddr, 6), nil
 	}
-	llog.Trace("  neighbor entry not found")
+	roslog.Trace("  neighbor entry not found")
 
 	return nil, nil
 }
 
-func (c *ROSClient) AssignIPv6(ifname string, ip *net.IPNet, key string, options ROSIPOptions) error {
-	llog.Trace("AssignIPv6(ifname=%s, ip=%s, key=%s, options=%+v", ifname, ip, key, options)
+func (c *ROSClient) AssignIPv6(ctx context.Context, ifname string, ip *net.IPNet, key string, options ROSIPOptions) (string, error) {
+	roslog.Trace("AssignIPv6(ifname=%s, ip=%s, key=%s, options=%+v", ifname, ip, key, options)
 	comment := fmt.Sprintf("%s %s", rosCommentKey, key)
 
 	// check ip assignment state
-	llog.Trace("  fetching current ip assignment")
-	rep, err := c.RunArgs([]string{
+	roslog.Trace("  fetching current ip assignment")
+	rep, err := c.RunArgs(ctx, []string{
 		"/ipv6/address/print",
-		"=.proplist=.id,comment,address,advertise,eui-64",
+		"=.proplist=.id,comment,address,advertise,eui-64,disabled",
 		"?comment",
 		"?dynamic=false",
 		"?#&",
@@ -418,7 +504,7 @@ func (c *ROSClient) AssignIPv6(ifname string, ip *net.IPNet, key string, options
 		"?#&",
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 	c.dumpResponse(rep)
 	id := ""
@@ -430,22 +516,26 @@ func (c *ROSClient) AssignIPv6(ifname string, ip *net.IPNet, key string, options
 		id = props[".id"]
 		if cidrEqual(props["address"], *ip) &&
 			props["eui-64"] == strconv.FormatBool(options.Eui64) &&
-			props["advertise"] == strconv.FormatBool(options.Advertise) {
-			return nil
+			props["advertise"] == strconv.FormatBool(options.Advertise) &&
+			!isROSTrue(props["disabled"]) {
+			return id, nil
 		}
 	}
 
 	// assign or update ip if necessarry
 	if id != "" {
-		_, err = c.RunArgs([]string{
+		roslog.Info("Updating ROS IPv6 address: interface=%s address=%s", ifname, ip.String())
+		_, err = c.RunArgs(ctx, []string{
 			"/ipv6/address/set",
 			fmt.Sprintf("=.id=%s", id),
 			fmt.Sprintf("=address=%s", ip.String()),
 			fmt.Sprintf("=advertise=%t", options.Advertise),
 			fmt.Sprintf("=eui-64=%t", options.Eui64),
+			"=disabled=no",
 		})
 	} else {
-		_, err = c.RunArgs([]string{
+		roslog.Info("Adding ROS IPv6 address: interface=%s address=%s", ifname, ip.String())
+		rep, err = c.RunArgs(ctx, []string{
 			"/ipv6/address/add",
 			fmt.Sprintf("=interface=%s", ifname),
 			fmt.Sprintf("=address=%s", ip.String()),
@@ -453,7 +543,250 @@ func (c *ROSClient) AssignIPv6(ifname string, ip *net.IPNet, key string, options
 			fmt.Sprintf("=eui-64=%t", options.Eui64),
 			fmt.Sprintf("=comment=%s", comment),
 		})
+		if err == nil {
+			id = rep.Done.Map["ret"]
+		}
+	}
+
+	return id, err
+}
+
+func (c *ROSClient) SetIPv6AddressList(ctx context.Context, list string, ip *net.IPNet, key string) (string, error) {
+	roslog.Trace("SetIPv6AddressList(list=%s, ip=%s, key=%s)", list, ip, key)
+	comment := fmt.Sprintf("%s %s", rosCommentKey, key)
+
+	// check address list state
+	roslog.Trace("  fetching current address list entries")
+	rep, err := c.RunArgs(ctx, []string{
+		"/ipv6/firewall/address-list/print",
+		"=.proplist=.id,comment,address",
+		fmt.Sprintf("?list=%s", list),
+		"?dynamic=false",
+		"?#&",
+	})
+	if err != nil {
+		return "", err
+	}
+	c.dumpResponse(rep)
+	keep := ""
+	var stale []string
+	for _, s := range rep.Re {
+		props := s.Map
+		if props["comment"] != comment {
+			continue
+		}
+		if keep == "" && cidrEqual(props["address"], *ip) {
+			keep = props[".id"]
+			continue
+		}
+		stale = append(stale, props[".id"])
+	}
+	// reuse one of the stale entries instead of adding a new one
+	id := ""
+	if keep == "" && len(stale) > 0 {
+		id = stale[0]
+		stale = stale[1:]
+	}
+
+	// remove entries accumulated by past renumbering
+	for _, sid := range stale {
+		roslog.Info("Removing stale ROS address list entry: list=%s .id=%s", list, sid)
+		if _, err := c.RunArgs(ctx, []string{
+			"/ipv6/firewall/address-list/remove",
+			fmt.Sprintf("=.id=%s", sid),
+		}); err != nil && !errors.Is(err, ErrROSNotFound) {
+			return "", err
+		}
+	}
+	if keep != "" {
+		roslog.Trace("  %s in %s is in desired state", ip, list)
+		return keep, nil
+	}
+
+	// add or update entry
+	if id != "" {
+		roslog.Info("Updating ROS address list entry: list=%s address=%s", list, ip)
+		_, err = c.RunArgs(ctx, []string{
+			"/ipv6/firewall/address-list/set",
+			fmt.Sprintf("=.id=%s", id),
+			fmt.Sprintf("=address=%s", ip.String()),
+		})
+	} else {
+		roslog.Info("Adding ROS address list entry: list=%s address=%s", list, ip)
+		rep, err = c.RunArgs(ctx, []string{
+			"/ipv6/firewall/address-list/add",
+			fmt.Sprintf("=list=%s", list),
+			fmt.Sprintf("=address=%s", ip.String()),
+			fmt.Sprintf("=comment=%s", comment),
+		})
+		if err == nil {
+			id = rep.Done.Map["ret"]
+		}
 	}
 
+	return id, err
+}
+
+// objects created by the companion (identified by rosCommentKey)
+type ROSObject struct {
+	path  string
+	id    string
+	props map[string]string
+}
+
+func (o ROSObject) String() string {
+	desc := []string{o.path, fmt.Sprintf(".id=%s", o.id)}
+	for _, k := range []string{"interface", "address", "dst-address", "gateway", "name", "prefix", "list", "comment"} {
+		if v, ok := o.props[k]; ok {
+			desc = append(desc, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	return strings.Join(desc, " ")
+}
+
+var rosOwnedPaths = []struct {
+	path     string
+	proplist string
+}{
+	{"/ipv6/address", ".id,comment,interface,address"},
+	{"/ipv6/route", ".id,comment,dst-address,gateway"},
+	{"/ipv6/pool", ".id,comment,name,prefix"},
+	{"/ipv6/firewall/address-list", ".id,comment,list,address"},
+}
+
+func isROSTrue(v string) bool {
+	return v == "true" || v == "yes"
+}
+
+func isOwnedComment(comment string) bool {
+	return comment == rosCommentKey || strings.HasPrefix(comment, rosCommentKey+" ")
+}
+
+func (c *ROSClient) ListOwnedObjects(ctx context.Context) ([]ROSObject, error) {
+	roslog.Trace("ListOwnedObjects()")
+	var objs []ROSObject
+	for _, p := range rosOwnedPaths {
+		rep, err := c.RunArgs(ctx, []string{
+			p.path + "/print",
+			"=.proplist=" + p.proplist,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, re := range rep.Re {
+			if !isOwnedComment(re.Map["comment"]) {
+				continue
+			}
+			objs = append(objs, ROSObject{path: p.path, id: re.Map[".id"], props: re.Map})
+		}
+	}
+	return objs, nil
+}
+
+func (c *ROSClient) RemoveObject(ctx context.Context, obj ROSObject) error {
+	roslog.Trace("RemoveObject(%s)", obj)
+	_, err := c.RunArgs(ctx, []string{
+		obj.path + "/remove",
+		fmt.Sprintf("=.id=%s", obj.id),
+	})
+	return err
+}
+
+func (c *ROSClient) DisableObject(ctx context.Context, obj ROSObject) error {
+	roslog.Trace("DisableObject(%s)", obj)
+	_, err := c.RunArgs(ctx, []string{
+		obj.path + "/set",
+		fmt.Sprintf("=.id=%s", obj.id),
+		"=disabled=yes",
+	})
 	return err
 }
+
+// Close closes the connections to RouterOS
+func (c *ROSClient) Close() {
+	c.transport.Close()
+}
+
+// WriteCount returns the number of add/set/remove commands executed so far.
+// Commands recorded to the plan in dry-run mode are not counted.
+func (c *ROSClient) WriteCount() int64 {
+	return atomic.LoadInt64(&c.writes)
+}
+
+func (c *ROSClient) DryRunMode() string {
+	return c.cfg.dryRun
+}
+
+// TakePlan returns the commands recorded since the last call (dry-run only)
+func (c *ROSClient) TakePlan() []ROSPlanChange {
+	if c.plan == nil {
+		return nil
+	}
+	return c.plan.Take()
+}
+
+// ROSPermissionReport describes the API user (see CheckPermissions)
+type ROSPermissionReport struct {
+	Identity string
+	Version  string
+	User     string
+	Group    string
+	Policies []string
+	Missing  []string
+}
+
+// CheckPermissions connects to RouterOS and reports the group policies
+// the companion needs but the API user lacks
+func (c *ROSClient) CheckPermissions(ctx context.Context) (*ROSPermissionReport, error) {
+	r := &ROSPermissionReport{User: c.cfg.username}
+	first := func(args ...string) (map[string]string, error) {
+		rep, err := c.RunArgs(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(rep.Re) == 0 {
+			return nil, &ROSError{Command: args[0], Message: "no such item", Kind: ErrROSNotFound}
+		}
+		return rep.Re[0].Map, nil
+	}
+
+	identity, err := first("/system/identity/print", "=.proplist=name")
+	if err != nil {
+		return nil, err
+	}
+	r.Identity = identity["name"]
+	resource, err := first("/system/resource/print", "=.proplist=version")
+	if err != nil {
+		return nil, err
+	}
+	r.Version = resource["version"]
+	user, err := first("/user/print", "=.proplist=group", fmt.Sprintf("?name=%s", c.cfg.username))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user %s: %w", c.cfg.username, err)
+	}
+	r.Group = user["group"]
+	group, err := first("/user/group/print", "=.proplist=policy", fmt.Sprintf("?name=%s", r.Group))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up group %s: %w", r.Group, err)
+	}
+	r.Policies = strings.Split(group["policy"], ",")
+
+	required := []string{"read", "write", "test", "api"}
+	if c.cfg.protocol == "rest" {
+		required[3] = "rest-api"
+	}
+	for _, req := range required {
+		found := false
+		for _, p := range r.Policies {
+			if p == req {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.Missing = append(r.Missing, req)
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/rosapitest"
+)
+
+func TestROSStandin(t *testing.T) {
+	srv, err := rosapitest.NewServer("fletsv6", "password")
+	if err != nil {
+		t.Fatalf("rosapitest.NewServer failed: %s", err)
+	}
+	defer srv.Close()
+	srv.SetInterface("vlanflets", "48:A9:8A:22:C8:C8")
+	srv.AddNeighbor("2001:db8:1::1234", "B8:27:EB:F6:0F:8F", "reachable")
+
+	// login
+	bad, err := NewROSClient(ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "wrong", dryRun: "off"})
+	if err != nil {
+		t.Fatalf("NewROSClient failed: %s", err)
+	}
+	if _, err := bad.GetInterfaceMAC(context.Background(), "vlanflets"); err == nil {
+		t.Fatalf("login with a wrong password succeeded")
+	}
+	c, err := NewROSClient(ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", dryRun: "off"})
+	if err != nil {
+		t.Fatalf("NewROSClient failed: %s", err)
+	}
+	mac, err := c.GetInterfaceMAC(context.Background(), "vlanflets")
+	if err != nil || mac.String() != "48:a9:8a:22:c8:c8" {
+		t.Fatalf("GetInterfaceMAC returned %s, %v", mac, err)
+	}
+	mac, err = c.LookupNeighbor(context.Background(), net.ParseIP("2001:db8:1::1234"), 100, true)
+	if err != nil || mac.String() != "b8:27:eb:f6:0f:8f" {
+		t.Fatalf("LookupNeighbor returned %s, %v", mac, err)
+	}
+
+	// reconcile paths
+	cfg := &RAConfig{mode: "ros", rosExtIf: "vlanflets", rosGC: "on"}
+	eip, _ := ParseROSIPAssign("ra-prefix::1/128@@external", cfg.rosExtIf)
+	cfg.rosExtIPs = append(cfg.rosExtIPs, eip)
+	pool, _ := ParseROSPoolAssign("ra-prefix@fletsv6-pool/64")
+	cfg.rosPools = append(cfg.rosPools, pool)
+	rac := NewRAClient(cfg, c)
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+	rac.reconcile(context.Background(), "solicited")
+	if r := rac.LastReconcile(); r.Changes != 3 || len(r.Errors) != 0 {
+		t.Fatalf("unexpected reconcile result: %+v", r)
+	}
+	if rows := srv.Rows("/ipv6/address"); len(rows) != 1 || rows[0]["address"] != "2001:db8:1::1/128" {
+		t.Fatalf("unexpected /ipv6/address: %+v", rows)
+	}
+	rac.reconcile(context.Background(), "periodic")
+	if srv.CommandCount("/ipv6/address/add") != 1 || srv.CommandCount("/ipv6/route/add") != 1 {
+		t.Fatalf("reconcile in desired state issued add commands")
+	}
+
+	// !trap is reported and does not break the connection
+	logins := srv.Logins()
+	srv.InjectTrap("/ipv6/route/print", "failure: simulated", 1)
+	rac.reconcile(context.Background(), "periodic")
+	if r := rac.LastReconcile(); len(r.Errors) != 1 {
+		t.Fatalf("trap was not reported: %+v", r)
+	}
+	if srv.Logins() != logins {
+		t.Fatalf("connection was re-established after !trap")
+	}
+
+	// dropped connections are discarded from the pool
+	srv.DropConnections()
+	for i := 0; ; i++ {
+		_, err := c.GetInterfaceMAC(context.Background(), "vlanflets")
+		if err == nil {
+			break
+		}
+		if i == 3 {
+			t.Fatalf("ROSClient did not recover from dropped connections: %s", err)
+		}
+	}
+
+	// slow replies time out
+	srv.InjectDelay("/ipv6/neighbor/print", time.Second*6, 1)
+	if _, err := c.LookupNeighbor(context.Background(), net.ParseIP("2001:db8:1::1234"), 100, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow reply did not time out: %v", err)
+	}
+
+	// reads are retried, writes are not
+	rc, err := NewROSClient(ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", dryRun: "off",
+		readTimeout: time.Millisecond * 300, writeTimeout: time.Millisecond * 300, readRetries: 2})
+	if err != nil {
+		t.Fatalf("NewROSClient failed: %s", err)
+	}
+	srv.InjectDelay("/interface/print", time.Second, 2)
+	if _, err := rc.GetInterfaceMAC(context.Background(), "vlanflets"); err != nil {
+		t.Fatalf("read was not retried: %s", err)
+	}
+	adds := srv.CommandCount("/ipv6/pool/add")
+	srv.InjectDelay("/ipv6/pool/add", time.Second, 1)
+	if _, err := rc.ExportIPv6Pool(context.Background(), "slow-pool", *n, 64, "ra-prefix"); err == nil {
+		t.Fatalf("slow write did not time out")
+	}
+	if srv.CommandCount("/ipv6/pool/add") != adds+1 {
+		t.Fatalf("write was retried")
+	}
+
+	// canceled context aborts the command
+	srv.InjectDelay("/interface/print", time.Second*3, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetInterfaceMAC(ctx, "vlanflets"); err == nil || time.Since(start) > time.Second {
+		t.Fatalf("canceled command returned %v after %s", err, time.Since(start))
+	}
+
+	// !trap is classified
+	srv.InjectTrap("/ipv6/pool/print", "no such command prefix", 1)
+	if _, err := c.ExportIPv6Pool(context.Background(), "fletsv6-pool", *n, 64, "ra-prefix"); !errors.Is(err, ErrROSUnknownCommand) {
+		t.Fatalf("trap was not classified: %v", err)
+	}
+
+	// concurrent callers share a bounded pool
+	pc, err := NewROSClient(ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", dryRun: "off", poolMax: 2})
+	if err != nil {
+		t.Fatalf("NewROSClient failed: %s", err)
+	}
+	logins = srv.Logins()
+	srv.InjectDelay("/interface/print", time.Millisecond*200, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pc.GetInterfaceMAC(context.Background(), "vlanflets"); err != nil {
+				t.Errorf("GetInterfaceMAC via bounded pool failed: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	stats := pc.transport.(*ROSConnectionPool).Stats()
+	if srv.Logins()-logins > 2 || stats.Open > 2 || stats.Waits == 0 {
+		t.Fatalf("pool exceeded its limit: logins=%d stats=%+v", srv.Logins()-logins, stats)
+	}
+
+	// waiting for a connection honors the context
+	srv.InjectDelay("/interface/print", time.Second, 2)
+	for i := 0; i < 2; i++ {
+		go pc.GetInterfaceMAC(context.Background(), "vlanflets")
+	}
+	time.Sleep(time.Millisecond * 100)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if _, err := pc.GetInterfaceMAC(ctx, "vlanflets"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waiting for a connection returned %v", err)
+	}
+	if stats := pc.transport.(*ROSConnectionPool).Stats(); stats.WaitCanceled != 1 {
+		t.Fatalf("unexpected pool stats: %+v", stats)
+	}
+
+	// ros-test reports the missing policies of the API user
+	srv.Insert("/system/identity", map[string]string{"name": "MikroTik"})
+	srv.Insert("/user", map[string]string{"name": "fletsv6", "group": "fletsv6"})
+	srv.Insert("/user/group", map[string]string{"name": "fletsv6", "policy": "read,write,api,!test"})
+	report, err := c.CheckPermissions(context.Background())
+	if err != nil || report.Identity != "MikroTik" || len(report.Missing) != 1 || report.Missing[0] != "test" {
+		t.Fatalf("CheckPermissions returned %+v, %v", report, err)
+	}
+}
+
+func TestROSTLS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fletsv6-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// RouterOS-style certificates: the name only in the CommonName
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	issue := func(serial int64, cn string, usage x509.ExtKeyUsage) (tls.Certificate, []byte, []byte) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, certPEM, keyPEM
+	}
+	serverCert, _, _ := issue(2, "routerboard", x509.ExtKeyUsageServerAuth)
+	_, clientPEM, clientKeyPEM := issue(3, "fletsv6", x509.ExtKeyUsageClientAuth)
+	writeFile := func(name string, data []byte) string {
+		path := dir + "/" + name
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	caPath := writeFile("ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}))
+	clientPath := writeFile("client.pem", clientPEM)
+	clientKeyPath := writeFile("client-key.pem", clientKeyPEM)
+	sum := sha256.Sum256(serverCert.Certificate[0])
+	fingerprint := strings.ReplaceAll(fmt.Sprintf("% X", sum[:]), " ", ":")
+	wrong := sum
+	wrong[0] ^= 0xff
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	srv, err := rosapitest.NewTLSServer("fletsv6", "password", &tls.Config{Certificates: []tls.Certificate{serverCert}})
+	if err != nil {
+		t.Fatalf("rosapitest.NewTLSServer failed: %s", err)
+	}
+	defer srv.Close()
+	srv.SetInterface("vlanflets", "48:A9:8A:22:C8:C8")
+	certSrv, err := rosapitest.NewTLSServer("fletsv6", "password", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+	})
+	if err != nil {
+		t.Fatalf("rosapitest.NewTLSServer failed: %s", err)
+	}
+	defer certSrv.Close()
+	certSrv.SetInterface("vlanflets", "48:A9:8A:22:C8:C8")
+
+	newConfig := func(srv *rosapitest.Server, modify func(cfg *ROSConnectConfig)) ROSConnectConfig {
+		cfg := ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", dryRun: "off", useTLS: true}
+		modify(&cfg)
+		tcfg, err := newROSTLSConfig(cfg)
+		if err != nil {
+			t.Fatalf("newROSTLSConfig failed: %s", err)
+		}
+		cfg.tlsConfig = tcfg
+		return cfg
+	}
+	accept := func(name string, cfg ROSConnectConfig) {
+		c, err := NewROSClient(cfg)
+		if err != nil {
+			t.Fatalf("%s: NewROSClient failed: %s", name, err)
+		}
+		defer c.Close()
+		if _, err := c.GetInterfaceMAC(context.Background(), "vlanflets"); err != nil {
+			t.Fatalf("%s: GetInterfaceMAC failed: %s", name, err)
+		}
+	}
+	// rejected by both the preflight check and the pooled connections
+	reject := func(name string, cfg ROSConnectConfig) {
+		if _, err := NewROSClient(cfg); err == nil {
+			t.Fatalf("%s: preflight check succeeded", name)
+		}
+		pool := NewROSConnectionPool(cfg)
+		defer pool.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if _, err := pool.RunArgs(ctx, []string{"/interface/print"}); err == nil {
+			t.Fatalf("%s: pooled connection succeeded", name)
+		}
+	}
+
+	accept("CA", newConfig(srv, func(cfg *ROSConnectConfig) {
+		cfg.tlsCA = caPath
+		cfg.tlsServerName = "routerboard"
+	}))
+	reject("CA with a wrong name", newConfig(srv, func(cfg *ROSConnectConfig) {
+		cfg.tlsCA = caPath
+		cfg.tlsServerName = "other"
+	}))
+	accept("fingerprint", newConfig(srv, func(cfg *ROSConnectConfig) {
+		cfg.tlsFingerprint = fingerprint
+	}))
+	reject("fingerprint mismatch", newConfig(srv, func(cfg *ROSConnectConfig) {
+		cfg.tlsFingerprint = fmt.Sprintf("%x", wrong)
+	}))
+	accept("client certificate", newConfig(certSrv, func(cfg *ROSConnectConfig) {
+		cfg.tlsFingerprint = fingerprint
+		cfg.tlsCert = clientPath
+		cfg.tlsKey = clientKeyPath
+	}))
+	noCert, err := NewROSClient(newConfig(certSrv, func(cfg *ROSConnectConfig) {
+		cfg.tlsFingerprint = fingerprint
+	}))
+	if err == nil {
+		// TLS 1.3 reports the missing certificate after the handshake
+		if _, err := noCert.GetInterfaceMAC(context.Background(), "vlanflets"); err == nil {
+			t.Fatalf("login without a client certificate succeeded")
+		}
+		noCert.Close()
+	}
+
+	// a client certificate is never sent to an unverified peer
+	if _, err := newROSTLSConfig(ROSConnectConfig{host: srv.Host(), useTLS: true, tlsCert: clientPath, tlsKey: clientKeyPath}); err == nil {
+		t.Fatalf("ROS_TLS_CERT without ROS_TLS_CA/ROS_TLS_FINGERPRINT was accepted")
+	}
+	os.Setenv("ROS_HOST", srv.Host())
+	os.Setenv("ROS_TLS_CA", caPath)
+	_, err = loadROSConfig()
+	os.Unsetenv("ROS_HOST")
+	os.Unsetenv("ROS_TLS_CA")
+	if err == nil {
+		t.Fatalf("ROS_TLS_CA without ROS_USETLS was accepted")
+	}
+}
+
+func TestROSExportIPv6PoolComment(t *testing.T) {
+	srv, err := rosapitest.NewServer("fletsv6", "password")
+	if err != nil {
+		t.Fatalf("rosapitest.NewServer failed: %s", err)
+	}
+	defer srv.Close()
+	c, err := NewROSClient(ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", dryRun: "off"})
+	if err != nil {
+		t.Fatalf("NewROSClient failed: %s", err)
+	}
+	defer c.Close()
+
+	// created before the comment was introduced / by the user
+	srv.Insert("/ipv6/pool", map[string]string{"name": "untagged", "prefix": "2001:db8:1::/64", "prefix-length": "64"})
+	srv.Insert("/ipv6/pool", map[string]string{"name": "stale", "prefix": "2001:db8:9::/64", "prefix-length": "64"})
+	srv.Insert("/ipv6/pool", map[string]string{"name": "foreign", "prefix": "2001:db8:9::/64", "prefix-length": "64", "comment": "managed by hand"})
+	prefix := mustParseCIDR("2001:db8:1::/64")
+	for _, name := range []string{"untagged", "stale", "foreign"} {
+		if _, err := c.ExportIPv6Pool(context.Background(), name, prefix, 64, "ra-prefix"); err != nil {
+			t.Fatalf("ExportIPv6Pool(%s) failed: %s", name, err)
+		}
+	}
+	want := map[string]string{
+		"untagged": rosCommentKey + " ra-prefix",
+		"stale":    rosCommentKey + " ra-prefix",
+		"foreign":  "managed by hand",
+	}
+	for _, row := range srv.Rows("/ipv6/pool") {
+		if row["comment"] != want[row["name"]] || row["prefix"] != "2001:db8:1::/64" {
+			t.Fatalf("unexpected pool: %+v", row)
+		}
+	}
+	owned, err := c.ListOwnedObjects(context.Background())
+	if err != nil || len(owned) != 2 {
+		t.Fatalf("ListOwnedObjects returned %+v, %v", owned, err)
+	}
+
+	// tagged pools in desired state are left alone
+	sets := srv.CommandCount("/ipv6/pool/set")
+	if _, err := c.ExportIPv6Pool(context.Background(), "untagged", prefix, 64, "ra-prefix"); err != nil || srv.CommandCount("/ipv6/pool/set") != sets {
+		t.Fatalf("ExportIPv6Pool in desired state issued a set: %v", err)
+	}
+}
+
+func TestROSAddressList(t *testing.T) {
+	srv, err := rosapitest.NewServer("fletsv6", "password")
+	if err != nil {
+		t.Fatalf("rosapitest.NewServer failed: %s", err)
+	}
+	defer srv.Close()
+	c, err := NewROSClient(ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", dryRun: "off"})
+	if err != nil {
+		t.Fatalf("NewROSClient failed: %s", err)
+	}
+	defer c.Close()
+
+	// entries of the user and the ones left by past renumbering
+	comment := rosCommentKey + " ra-prefix::10/128"
+	user := srv.Insert("/ipv6/firewall/address-list", map[string]string{"list": "servers", "address": "2001:db8:9::10/128"})
+	stale1 := srv.Insert("/ipv6/firewall/address-list", map[string]string{"list": "servers", "address": "2001:db8:8::10/128", "comment": comment})
+	srv.Insert("/ipv6/firewall/address-list", map[string]string{"list": "servers", "address": "2001:db8:9::10/128", "comment": comment})
+	other := srv.Insert("/ipv6/firewall/address-list", map[string]string{"list": "others", "address": "2001:db8:9::10/128", "comment": comment})
+
+	// a stale entry is renumbered in place and the rest is removed
+	ip := &net.IPNet{IP: net.ParseIP("2001:db8:1::10"), Mask: net.CIDRMask(128, 128)}
+	id, err := c.SetIPv6AddressList(context.Background(), "servers", ip, "ra-prefix::10/128")
+	if err != nil || id != stale1 {
+		t.Fatalf("SetIPv6AddressList returned %s, %v", id, err)
+	}
+	want := map[string]string{
+		user:   "servers 2001:db8:9::10/128",
+		stale1: "servers 2001:db8:1::10/128",
+		other:  "others 2001:db8:9::10/128",
+	}
+	rows := srv.Rows("/ipv6/firewall/address-list")
+	if len(rows) != len(want) {
+		t.Fatalf("unexpected address list: %+v", rows)
+	}
+	for _, row := range rows {
+		if got := row["list"] + " " + row["address"]; got != want[row[".id"]] {
+			t.Fatalf("unexpected address list entry: %+v", row)
+		}
+	}
+	if srv.CommandCount("/ipv6/firewall/address-list/add") != 0 {
+		t.Fatalf("an entry was added instead of renumbering")
+	}
+
+	// nothing to do when in desired state
+	sets := srv.CommandCount("/ipv6/firewall/address-list/set")
+	if _, err := c.SetIPv6AddressList(context.Background(), "servers", ip, "ra-prefix::10/128"); err != nil ||
+		srv.CommandCount("/ipv6/firewall/address-list/set") != sets {
+		t.Fatalf("SetIPv6AddressList in desired state issued a set: %v", err)
+	}
+
+	// a new list gets its own entry
+	id, err = c.SetIPv6AddressList(context.Background(), "web", ip, "ra-prefix::10/128")
+	if err != nil || srv.CommandCount("/ipv6/firewall/address-list/add") != 1 {
+		t.Fatalf("SetIPv6AddressList to a new list returned %s, %v", id, err)
+	}
+
+	// RA_ROS_ADDRESS_LISTS
+	for _, spec := range []string{"ra-prefix::10/128", "ra-prefix::10/128@", "bogus@servers", "ra-prefix::1@a@b"} {
+		if _, err := ParseROSAddressListAssign(spec); err == nil {
+			t.Fatalf("ParseROSAddressListAssign(%s) succeeded", spec)
+		}
+	}
+	t.Setenv("RA_MODE", "ros")
+	t.Setenv("RA_ROS_EXTERNAL_INTERFACE", "vlanflets")
+	t.Setenv("RA_ROS_ADDRESS_LISTS", "ra-prefix::10/128@servers,ra-prefix/64#1::/64@lan")
+	cfg, err := loadRAConfig()
+	if err != nil {
+		t.Fatalf("loadRAConfig failed: %s", err)
+	}
+	if len(cfg.rosLists) != 2 || cfg.rosLists[0].list != "servers" || cfg.rosLists[1].list != "lan" {
+		t.Fatalf("unexpected address lists: %+v", cfg.rosLists)
+	}
+}
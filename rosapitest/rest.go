@@ -0,0 +1,135 @@
+package rosapitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RESTHandler returns an http.Handler serving the same tables over the
+// RouterOS 7 REST API (/rest). Faults injected for a command apply to the
+// corresponding REST request as well (e.g. "/ipv6/address/add" for PUT).
+func (s *Server) RESTHandler() http.Handler {
+	return http.HandlerFunc(s.serveREST)
+}
+
+func (s *Server) serveREST(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != s.Username || pass != s.Password {
+		writeRESTError(w, http.StatusUnauthorized, "Unauthorized", "")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/rest/") {
+		writeRESTError(w, http.StatusNotFound, "Not Found", "")
+		return
+	}
+	target := strings.TrimPrefix(r.URL.Path, "/rest")
+
+	body := make(map[string]interface{})
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeRESTError(w, http.StatusBadRequest, "Bad Request", err.Error())
+			return
+		}
+	}
+	attrs := make(map[string]string)
+	var queries []string
+	for k, v := range body {
+		switch vv := v.(type) {
+		case string:
+			attrs[k] = vv
+		case []interface{}:
+			var items []string
+			for _, item := range vv {
+				items = append(items, fmt.Sprint(item))
+			}
+			if k == ".query" {
+				queries = items
+			} else {
+				attrs[k] = strings.Join(items, ",")
+			}
+		default:
+			attrs[k] = fmt.Sprint(vv)
+		}
+	}
+
+	// map the request back onto an API command
+	var command string
+	switch r.Method {
+	case http.MethodGet:
+		command = target + "/print"
+	case http.MethodPost:
+		command = target
+	case http.MethodPut:
+		command = target + "/add"
+	case http.MethodPatch, http.MethodDelete:
+		idx := strings.LastIndex(target, "/")
+		attrs[".id"] = target[idx+1:]
+		if r.Method == http.MethodPatch {
+			command = target[:idx] + "/set"
+		} else {
+			command = target[:idx] + "/remove"
+		}
+	default:
+		writeRESTError(w, http.StatusMethodNotAllowed, "Method Not Allowed", "")
+		return
+	}
+
+	s.mutex.Lock()
+	s.commands[command]++
+	s.mutex.Unlock()
+	for _, f := range s.takeFaults(command) {
+		switch f.kind {
+		case faultDelay:
+			time.Sleep(f.delay)
+		case faultDrop:
+			panic(http.ErrAbortHandler)
+		case faultTrap:
+			writeRESTError(w, http.StatusBadRequest, "Bad Request", f.message)
+			return
+		}
+	}
+
+	res, done, err := s.execute(command, attrs, queries)
+	if err != nil {
+		status := http.StatusBadRequest
+		if strings.Contains(err.Error(), "no such") {
+			status = http.StatusNotFound
+		}
+		writeRESTError(w, status, http.StatusText(status), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch:
+		id := attrs[".id"]
+		if done != nil {
+			id = done["ret"]
+		}
+		path := command[:strings.LastIndex(command, "/")]
+		s.mutex.Lock()
+		row := copyProps(s.find(path, id))
+		s.mutex.Unlock()
+		_ = json.NewEncoder(w).Encode(row)
+	case http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		if res == nil {
+			res = []map[string]string{}
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}
+}
+
+func writeRESTError(w http.ResponseWriter, status int, message string, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   status,
+		"message": message,
+		"detail":  detail,
+	})
+}
@@ -0,0 +1,568 @@
+// Package rosapitest provides a RouterOS API stand-in server for testing
+// ROSClient without a RouterBoard.
+//
+// It speaks the binary word/sentence protocol of the RouterOS API and
+// implements /login, print/add/set/remove over in-memory tables, /ping and
+// /system/resource/print, with fault injection for slow replies, dropped
+// connections and !trap errors.
+package rosapitest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-routeros/routeros/proto"
+)
+
+type Server struct {
+	Username string
+	Password string
+
+	listener net.Listener
+	tables   map[string][]map[string]string
+	pingable map[string]bool
+	faults   []*fault
+	conns    map[net.Conn]bool
+	seq      int
+	logins   int
+	commands map[string]int
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+}
+
+type faultKind int
+
+const (
+	faultDelay faultKind = iota
+	faultDrop
+	faultTrap
+)
+
+type fault struct {
+	kind    faultKind
+	command string
+	delay   time.Duration
+	message string
+	count   int // remaining count, -1 for unlimited
+}
+
+// NewServer starts a stand-in server listening on a random local port
+func NewServer(username string, password string) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return newServer(l, username, password), nil
+}
+
+// NewTLSServer is NewServer speaking TLS (api-ssl) with config
+func NewTLSServer(username string, password string, config *tls.Config) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return newServer(tls.NewListener(l, config), username, password), nil
+}
+
+func newServer(l net.Listener, username string, password string) *Server {
+	s := &Server{
+		Username: username,
+		Password: password,
+		listener: l,
+		tables:   make(map[string][]map[string]string),
+		pingable: make(map[string]bool),
+		conns:    make(map[net.Conn]bool),
+		commands: make(map[string]int),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+func (s *Server) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops the server and drops all connections
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+// scripting
+
+// Insert adds a row to the table at path (e.g. "/ipv6/address") and returns its .id
+func (s *Server) Insert(path string, props map[string]string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.insert(path, props)
+}
+
+// Rows returns a copy of the table at path
+func (s *Server) Rows(path string) []map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rows := make([]map[string]string, len(s.tables[path]))
+	for i, row := range s.tables[path] {
+		rows[i] = copyProps(row)
+	}
+	return rows
+}
+
+// SetPingable makes /ping to ip succeed
+func (s *Server) SetPingable(ip string, pingable bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pingable[ip] = pingable
+}
+
+// Logins returns the number of successful logins (i.e. established sessions)
+func (s *Server) Logins() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.logins
+}
+
+// Connections returns the number of currently open connections
+func (s *Server) Connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns)
+}
+
+// CommandCount returns how many times command (e.g. "/ipv6/address/add") was received
+func (s *Server) CommandCount(command string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.commands[command]
+}
+
+// fault injection
+
+// InjectDelay delays the next count replies to command ("" matches all, count -1 is unlimited)
+func (s *Server) InjectDelay(command string, delay time.Duration, count int) {
+	s.addFault(&fault{kind: faultDelay, command: command, delay: delay, count: count})
+}
+
+// InjectDrop closes the connection instead of replying to the next count commands
+func (s *Server) InjectDrop(command string, count int) {
+	s.addFault(&fault{kind: faultDrop, command: command, count: count})
+}
+
+// InjectTrap replies !trap with message to the next count commands
+func (s *Server) InjectTrap(command string, message string, count int) {
+	s.addFault(&fault{kind: faultTrap, command: command, message: message, count: count})
+}
+
+// ClearFaults removes all injected faults
+func (s *Server) ClearFaults() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = nil
+}
+
+// DropConnections closes every established connection
+func (s *Server) DropConnections() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+func (s *Server) addFault(f *fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append(s.faults, f)
+}
+
+func (s *Server) takeFaults(command string) []fault {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var fs []fault
+	remain := s.faults[:0]
+	for _, f := range s.faults {
+		if f.command == "" || f.command == command {
+			fs = append(fs, *f)
+			if f.count > 0 {
+				f.count--
+			}
+		}
+		if f.count != 0 {
+			remain = append(remain, f)
+		}
+	}
+	s.faults = remain
+	return fs
+}
+
+// connection handling
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		s.conns[conn] = true
+		s.mutex.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := proto.NewWriter(conn)
+	loggedIn := false
+
+	for {
+		words, err := readSentence(r)
+		if err != nil {
+			return
+		}
+		if len(words) == 0 {
+			continue
+		}
+		command := words[0]
+		attrs, queries, tag := parseWords(words[1:])
+
+		s.mutex.Lock()
+		s.commands[command]++
+		s.mutex.Unlock()
+
+		// faults
+		var trap string
+		for _, f := range s.takeFaults(command) {
+			switch f.kind {
+			case faultDelay:
+				time.Sleep(f.delay)
+			case faultDrop:
+				return
+			case faultTrap:
+				trap = f.message
+			}
+		}
+
+		var res []map[string]string
+		var done map[string]string
+		if trap != "" {
+			err = fmt.Errorf("%s", trap)
+		} else if command == "/login" {
+			if attrs["name"] == s.Username && attrs["password"] == s.Password {
+				loggedIn = true
+				s.mutex.Lock()
+				s.logins++
+				s.mutex.Unlock()
+			} else {
+				err = fmt.Errorf("invalid user name or password (6)")
+			}
+		} else if !loggedIn {
+			err = fmt.Errorf("not logged in")
+		} else {
+			res, done, err = s.execute(command, attrs, queries)
+		}
+
+		for _, re := range res {
+			writeSentence(w, "!re", tag, re)
+		}
+		if err != nil {
+			writeSentence(w, "!trap", tag, map[string]string{"message": err.Error()})
+		}
+		if writeSentence(w, "!done", tag, done) != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) execute(command string, attrs map[string]string, queries []string) ([]map[string]string, map[string]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch command {
+	case "/ping":
+		loss := "100"
+		if s.pingable[attrs["address"]] {
+			loss = "0"
+		}
+		return []map[string]string{{"host": attrs["address"], "packet-loss": loss}}, nil, nil
+	case "/system/resource/print":
+		return []map[string]string{{"uptime": "1d00:00:00"}}, nil, nil
+	}
+
+	idx := strings.LastIndex(command, "/")
+	if idx <= 0 {
+		return nil, nil, fmt.Errorf("no such command prefix")
+	}
+	path, action := command[:idx], command[idx+1:]
+	switch action {
+	case "print":
+		q, err := compileQuery(queries)
+		if err != nil {
+			return nil, nil, err
+		}
+		var proplist []string
+		if pl, ok := attrs[".proplist"]; ok {
+			proplist = strings.Split(pl, ",")
+		}
+		var res []map[string]string
+		for _, row := range s.tables[path] {
+			if !q(row) {
+				continue
+			}
+			if proplist == nil {
+				res = append(res, copyProps(row))
+				continue
+			}
+			props := make(map[string]string)
+			for _, k := range proplist {
+				if v, ok := row[k]; ok {
+					props[k] = v
+				}
+			}
+			res = append(res, props)
+		}
+		return res, nil, nil
+	case "add":
+		if name, ok := attrs["name"]; ok {
+			for _, row := range s.tables[path] {
+				if row["name"] == name {
+					return nil, nil, fmt.Errorf("failure: item with such name already exists")
+				}
+			}
+		}
+		id := s.insert(path, attrs)
+		return nil, map[string]string{"ret": id}, nil
+	case "set":
+		row := s.find(path, attrs[".id"])
+		if row == nil {
+			return nil, nil, fmt.Errorf("no such item")
+		}
+		for k, v := range attrs {
+			if k != ".id" {
+				row[k] = v
+			}
+		}
+		return nil, nil, nil
+	case "remove":
+		rows := s.tables[path]
+		for i, row := range rows {
+			if row[".id"] == attrs[".id"] {
+				s.tables[path] = append(rows[:i:i], rows[i+1:]...)
+				return nil, nil, nil
+			}
+		}
+		return nil, nil, fmt.Errorf("no such item")
+	}
+
+	return nil, nil, fmt.Errorf("no such command")
+}
+
+func (s *Server) insert(path string, props map[string]string) string {
+	s.seq++
+	row := map[string]string{
+		".id":      fmt.Sprintf("*%X", s.seq),
+		"dynamic":  "false",
+		"disabled": "false",
+	}
+	for k, v := range props {
+		if k != ".id" {
+			row[k] = v
+		}
+	}
+	s.tables[path] = append(s.tables[path], row)
+	return row[".id"]
+}
+
+func (s *Server) find(path string, id string) map[string]string {
+	for _, row := range s.tables[path] {
+		if row[".id"] == id {
+			return row
+		}
+	}
+	return nil
+}
+
+// compileQuery evaluates RouterOS query words with the stack semantics
+// described in the API manual (?name=value, ?name, ?-name, ?#&, ?#|, ?#!)
+func compileQuery(queries []string) (func(map[string]string) bool, error) {
+	for _, q := range queries {
+		if strings.HasPrefix(q, "#") {
+			for _, op := range q[1:] {
+				if op != '&' && op != '|' && op != '!' && op != '.' {
+					return nil, fmt.Errorf("unsupported query operation %s", q)
+				}
+			}
+		}
+	}
+	return func(row map[string]string) bool {
+		var stack []bool
+		pop := func() bool {
+			if len(stack) == 0 {
+				return true
+			}
+			v := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			return v
+		}
+		for _, q := range queries {
+			switch {
+			case strings.HasPrefix(q, "#"):
+				for _, op := range q[1:] {
+					switch op {
+					case '!':
+						stack = append(stack, !pop())
+					case '&':
+						a, b := pop(), pop()
+						stack = append(stack, a && b)
+					case '|':
+						a, b := pop(), pop()
+						stack = append(stack, a || b)
+					case '.':
+						v := pop()
+						stack = append(stack, v, v)
+					}
+				}
+			case strings.HasPrefix(q, "-"):
+				_, ok := row[q[1:]]
+				stack = append(stack, !ok)
+			case strings.Contains(q, "="):
+				kv := strings.SplitN(q, "=", 2)
+				stack = append(stack, row[kv[0]] == kv[1])
+			default:
+				_, ok := row[q]
+				stack = append(stack, ok)
+			}
+		}
+		result := true
+		for len(stack) > 0 {
+			result = pop() && result
+		}
+		return result
+	}, nil
+}
+
+// protocol
+
+func parseWords(words []string) (map[string]string, []string, string) {
+	attrs := make(map[string]string)
+	var queries []string
+	tag := ""
+	for _, w := range words {
+		switch {
+		case strings.HasPrefix(w, "="):
+			kv := strings.SplitN(w[1:], "=", 2)
+			if len(kv) == 1 {
+				kv = append(kv, "")
+			}
+			attrs[kv[0]] = kv[1]
+		case strings.HasPrefix(w, "?"):
+			queries = append(queries, w[1:])
+		case strings.HasPrefix(w, ".tag="):
+			tag = w[5:]
+		}
+	}
+	return attrs, queries, tag
+}
+
+func readSentence(r *bufio.Reader) ([]string, error) {
+	var words []string
+	for {
+		w, err := readWord(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(w) == 0 {
+			return words, nil
+		}
+		words = append(words, string(w))
+	}
+}
+
+func readWord(r *bufio.Reader) ([]byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	var length int
+	var extra int
+	switch {
+	case b&0x80 == 0x00:
+		length = int(b)
+	case b&0xC0 == 0x80:
+		length, extra = int(b&0x3F), 1
+	case b&0xE0 == 0xC0:
+		length, extra = int(b&0x1F), 2
+	case b&0xF0 == 0xE0:
+		length, extra = int(b&0x0F), 3
+	case b == 0xF0:
+		length, extra = 0, 4
+	default:
+		return nil, fmt.Errorf("invalid length prefix 0x%02x", b)
+	}
+	for i := 0; i < extra; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length = length<<8 | int(b)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func writeSentence(w proto.Writer, word string, tag string, props map[string]string) error {
+	w.BeginSentence()
+	w.WriteWord(word)
+	if tag != "" {
+		w.WriteWord(".tag=" + tag)
+	}
+	for k, v := range props {
+		w.WriteWord(fmt.Sprintf("=%s=%s", k, v))
+	}
+	return w.EndSentence()
+}
+
+func copyProps(props map[string]string) map[string]string {
+	c := make(map[string]string, len(props))
+	for k, v := range props {
+		c[k] = v
+	}
+	return c
+}
+
+// helpers for common tables
+
+// SetInterface adds an entry to /interface
+func (s *Server) SetInterface(name string, mac string) {
+	s.Insert("/interface", map[string]string{"name": name, "mac-address": mac})
+}
+
+// AddNeighbor adds an entry to /ipv6/neighbor and makes the address pingable
+func (s *Server) AddNeighbor(address string, mac string, status string) {
+	s.Insert("/ipv6/neighbor", map[string]string{"address": address, "mac-address": mac, "status": status})
+	s.SetPingable(address, true)
+}
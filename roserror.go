@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-routeros/routeros"
+)
+
+// kinds of RouterOS !trap errors. use errors.Is to branch on them
+var (
+	ErrROSNotFound         = errors.New("no such item")
+	ErrROSAlreadyExists    = errors.New("item already exists")
+	ErrROSInvalidArgument  = errors.New("invalid argument")
+	ErrROSPermissionDenied = errors.New("permission denied")
+	ErrROSUnknownCommand   = errors.New("unknown command")
+	ErrROSFailure          = errors.New("command failed")
+)
+
+// ROSError is a !trap reply returned by RouterOS for a command
+type ROSError struct {
+	Command  string
+	Category string
+	Message  string
+	Kind     error
+}
+
+func (e *ROSError) Error() string {
+	return fmt.Sprintf("RouterOS %s: %s", e.Command, e.Message)
+}
+
+func (e *ROSError) Unwrap() error {
+	return e.Kind
+}
+
+// classifyROSTrap converts a !trap into a ROSError.
+// RouterOS reports only a message and an optional category (see the API
+// documentation), so the kind is guessed from both.
+func classifyROSTrap(cmd string, de *routeros.DeviceError) *ROSError {
+	e := &ROSError{
+		Command:  cmd,
+		Category: de.Sentence.Map["category"],
+		Message:  de.Sentence.Map["message"],
+	}
+	msg := strings.ToLower(e.Message)
+	switch {
+	case strings.Contains(msg, "no such command"):
+		e.Kind = ErrROSUnknownCommand
+	case strings.Contains(msg, "no such item"), strings.Contains(msg, "not found"):
+		e.Kind = ErrROSNotFound
+	case strings.Contains(msg, "already have"), strings.Contains(msg, "already exists"):
+		e.Kind = ErrROSAlreadyExists
+	case strings.Contains(msg, "permission"):
+		e.Kind = ErrROSPermissionDenied
+	case strings.Contains(msg, "invalid"), strings.Contains(msg, "expected"), e.Category == "1":
+		e.Kind = ErrROSInvalidArgument
+	case e.Category == "0":
+		e.Kind = ErrROSNotFound
+	default:
+		e.Kind = ErrROSFailure
+	}
+	return e
+}
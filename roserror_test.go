@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-routeros/routeros"
+	"github.com/go-routeros/routeros/proto"
+	"github.com/grainrigi/routeros-fletsv6-companion/rosapitest"
+)
+
+func TestClassifyROSTrap(t *testing.T) {
+	for _, tc := range []struct {
+		message  string
+		category string
+		kind     error
+	}{
+		{"no such command prefix", "", ErrROSUnknownCommand},
+		{"no such command or directory (remove)", "", ErrROSUnknownCommand},
+		{"no such item", "", ErrROSNotFound},
+		{"input does not match any value of interface (not found)", "", ErrROSNotFound},
+		{"failure: already have such address", "", ErrROSAlreadyExists},
+		{"failure: item with such name already exists", "", ErrROSAlreadyExists},
+		{"not enough permissions (9)", "", ErrROSPermissionDenied},
+		{"invalid value for argument address", "", ErrROSInvalidArgument},
+		{"expected end of command (line 1 column 5)", "", ErrROSInvalidArgument},
+		{"unknown parameter", "1", ErrROSInvalidArgument},
+		{"missing item", "0", ErrROSNotFound},
+		{"failure: simulated", "", ErrROSFailure},
+		{"", "", ErrROSFailure},
+	} {
+		de := &routeros.DeviceError{Sentence: &proto.Sentence{Word: "!trap", Map: map[string]string{"message": tc.message}}}
+		if tc.category != "" {
+			de.Sentence.Map["category"] = tc.category
+		}
+		e := classifyROSTrap("/ipv6/address/add", de)
+		if !errors.Is(e, tc.kind) {
+			t.Fatalf("'%s' (category %s) was classified as %v, expected %v", tc.message, tc.category, e.Kind, tc.kind)
+		}
+		if e.Command != "/ipv6/address/add" || e.Message != tc.message || e.Category != tc.category {
+			t.Fatalf("unexpected ROSError: %+v", e)
+		}
+		var target *ROSError
+		if !errors.As(error(e), &target) {
+			t.Fatalf("ROSError is not matched by errors.As")
+		}
+	}
+}
+
+func TestROSReadRetry(t *testing.T) {
+	srv, err := rosapitest.NewServer("fletsv6", "password")
+	if err != nil {
+		t.Fatalf("rosapitest.NewServer failed: %s", err)
+	}
+	defer srv.Close()
+	srv.SetInterface("vlanflets", "48:A9:8A:22:C8:C8")
+	c, err := NewROSClient(ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", dryRun: "off",
+		readTimeout: time.Millisecond * 100, writeTimeout: time.Millisecond * 100, readRetries: 2})
+	if err != nil {
+		t.Fatalf("NewROSClient failed: %s", err)
+	}
+	defer c.Close()
+
+	// reads are retried with a doubling backoff (200ms, 400ms)
+	prints := srv.CommandCount("/interface/print")
+	srv.InjectDrop("/interface/print", 2)
+	start := time.Now()
+	if _, err := c.GetInterfaceMAC(context.Background(), "vlanflets"); err != nil {
+		t.Fatalf("read was not retried: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*600 {
+		t.Fatalf("retried without backing off (%s)", elapsed)
+	}
+	if n := srv.CommandCount("/interface/print") - prints; n != 3 {
+		t.Fatalf("read was run %d times", n)
+	}
+
+	// up to ROS_READ_RETRIES times
+	prints = srv.CommandCount("/interface/print")
+	srv.InjectDrop("/interface/print", 3)
+	if _, err := c.GetInterfaceMAC(context.Background(), "vlanflets"); err == nil {
+		t.Fatalf("read succeeded after exhausting the retries")
+	}
+	if n := srv.CommandCount("/interface/print") - prints; n != 3 {
+		t.Fatalf("read was run %d times", n)
+	}
+
+	// !trap is a reply of RouterOS and not retried
+	prints = srv.CommandCount("/interface/print")
+	srv.InjectTrap("/interface/print", "failure: simulated", 1)
+	if _, err := c.GetInterfaceMAC(context.Background(), "vlanflets"); !errors.Is(err, ErrROSFailure) {
+		t.Fatalf("trap was not returned: %v", err)
+	}
+	if n := srv.CommandCount("/interface/print") - prints; n != 1 {
+		t.Fatalf("trap was retried (%d runs)", n)
+	}
+
+	// nor are writes
+	adds := srv.CommandCount("/ipv6/pool/add")
+	srv.InjectDrop("/ipv6/pool/add", 1)
+	if _, err := c.ExportIPv6Pool(context.Background(), "pool", mustParseCIDR("2001:db8:1::/64"), 64, "ra-prefix"); err == nil {
+		t.Fatalf("dropped write succeeded")
+	}
+	if n := srv.CommandCount("/ipv6/pool/add") - adds; n != 1 {
+		t.Fatalf("write was run %d times", n)
+	}
+
+	// canceling ctx stops the backoff
+	srv.InjectDrop("/interface/print", 3)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start = time.Now()
+	if _, err := c.GetInterfaceMAC(ctx, "vlanflets"); err == nil {
+		t.Fatalf("read succeeded after the context was canceled")
+	}
+	if elapsed := time.Since(start); elapsed > time.Millisecond*400 {
+		t.Fatalf("backoff ignored the context (%s)", elapsed)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-routeros/routeros"
+)
+
+// used when the pool settings of ROSConnectConfig are left zero
+const (
+	rosDefaultPoolMax            = 4
+	rosDefaultPoolIdleTimeout    = time.Minute
+	rosDefaultPoolHealthInterval = time.Second * 10
+)
+
+// ROSConnectionPool keeps between poolMin and poolMax API sessions.
+// Callers exceeding poolMax wait for a connection to be returned, so the
+// number of sessions never exceeds the limit configured on RouterOS.
+type ROSConnectionPool struct {
+	cfg     ROSConnectConfig
+	idle    []*ROSConnection // most recently used last
+	open    int              // idle + in use + dialing
+	waiters []chan *ROSConnection
+	stats   ROSPoolStats
+	closed  chan struct{}
+	mutex   sync.Mutex
+}
+
+type ROSConnection struct {
+	*routeros.Client
+	lastUsed    time.Time
+	lastChecked time.Time // by the health check, which is not a use
+}
+
+// ROSPoolStats counts connection churn since startup
+type ROSPoolStats struct {
+	Open                int
+	Idle                int
+	Created             int64
+	Closed              int64
+	DialFailures        int64
+	HealthCheckFailures int64
+	IdleTimeouts        int64
+	Waits               int64
+	WaitCanceled        int64
+}
+
+// run executes a command on the connection.
+// When ctx is done the connection is closed to unblock the command, so it
+// must not be reused in that case.
+func (c *ROSConnection) run(ctx context.Context, args []string) (*routeros.Reply, error) {
+	type result struct {
+		rep *routeros.Reply
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		rep, err := c.RunArgs(args)
+		ch <- result{rep, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.rep, r.err
+	case <-ctx.Done():
+		c.Close()
+		// wait for the command to return
+		<-ch
+		return nil, ctx.Err()
+	}
+}
+
+func NewROSConnectionPool(cfg ROSConnectConfig) *ROSConnectionPool {
+	if cfg.poolMax <= 0 {
+		cfg.poolMax = rosDefaultPoolMax
+	}
+	if cfg.poolMin > cfg.poolMax {
+		cfg.poolMin = cfg.poolMax
+	}
+	if cfg.poolIdleTimeout == 0 {
+		cfg.poolIdleTimeout = rosDefaultPoolIdleTimeout
+	}
+	if cfg.poolHealthInterval == 0 {
+		cfg.poolHealthInterval = rosDefaultPoolHealthInterval
+	}
+	pool := &ROSConnectionPool{
+		cfg:    cfg,
+		closed: make(chan struct{}),
+	}
+
+	go pool.maintain()
+
+	return pool
+}
+
+// maintain health-checks idle connections, closes the ones idle for too long
+// and keeps poolMin connections open
+func (p *ROSConnectionPool) maintain() {
+	for {
+		now := time.Now()
+
+		// pick connections to be checked
+		var expired, check []*ROSConnection
+		func() {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			keep := p.idle[:0]
+			open := p.open
+			for _, c := range p.idle {
+				checked := c.lastChecked
+				if c.lastUsed.After(checked) {
+					checked = c.lastUsed
+				}
+				switch {
+				case now.Sub(c.lastUsed) >= p.cfg.poolIdleTimeout && open > p.cfg.poolMin:
+					expired = append(expired, c)
+					open--
+				case now.Sub(checked) >= p.cfg.poolHealthInterval:
+					check = append(check, c)
+				default:
+					keep = append(keep, c)
+				}
+			}
+			p.idle = keep
+		}()
+
+		for _, c := range expired {
+			roslog.Trace("closing RouterOS API connection idle for %s", now.Sub(c.lastUsed))
+			p.discard(c, &p.stats.IdleTimeouts)
+		}
+		for _, c := range check {
+			ctx, cancel := context.WithTimeout(context.Background(), p.cfg.readTimeout)
+			rep, err := c.run(ctx, []string{
+				"/system/resource/print",
+				"=.proplist=uptime",
+			})
+			cancel()
+			if err != nil || len(rep.Re) == 0 {
+				roslog.Warning("ROS health check failed. discarding connection: %v", err)
+				p.discard(c, &p.stats.HealthCheckFailures)
+				continue
+			}
+			c.lastChecked = time.Now()
+			p.put(c, false)
+		}
+
+		// fill up to poolMin
+		for {
+			if !p.reserve() {
+				break
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), p.cfg.readTimeout)
+			c, err := p.dial(ctx)
+			cancel()
+			if err != nil {
+				roslog.Warning("failed to establish connection to RouterOS API: %s", err)
+				break
+			}
+			p.Put(c)
+		}
+
+		stats := p.Stats()
+		roslog.Trace("RouterOS API pool: open=%d idle=%d created=%d closed=%d waits=%d",
+			stats.Open, stats.Idle, stats.Created, stats.Closed, stats.Waits)
+
+		select {
+		case <-time.After(p.cfg.poolHealthInterval):
+		case <-p.closed:
+			return
+		}
+	}
+}
+
+// Close stops the maintenance and closes idle connections.
+// Connections in use are closed when they are returned.
+func (p *ROSConnectionPool) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	select {
+	case <-p.closed:
+		return
+	default:
+	}
+	close(p.closed)
+	for _, c := range p.idle {
+		c.Close()
+		p.stats.Closed++
+		p.open--
+	}
+	p.idle = nil
+}
+
+// reserve counts a new connection towards open if the pool is below poolMin
+func (p *ROSConnectionPool) reserve() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.open >= p.cfg.poolMin {
+		return false
+	}
+	p.open++
+	return true
+}
+
+// dial establishes a connection which has already been counted in open.
+// on failure the slot is released.
+func (p *ROSConnectionPool) dial(ctx context.Context) (*ROSConnection, error) {
+	c, err := p.makeConnection(ctx)
+	if err != nil {
+		p.discard(nil, &p.stats.DialFailures)
+		return nil, err
+	}
+	p.mutex.Lock()
+	p.stats.Created++
+	p.mutex.Unlock()
+	return c, nil
+}
+
+func (p *ROSConnectionPool) makeConnection(ctx context.Context) (*ROSConnection, error) {
+	cfg := &p.cfg
+	address := net.JoinHostPort(cfg.host, strconv.Itoa(cfg.port))
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.useTLS {
+		tconn := tls.Client(conn, rosTLSConfig(*cfg))
+		if err := tconn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tconn
+	}
+
+	// bound the login by ctx as well
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	cl, err := routeros.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := cl.Login(cfg.username, cfg.password); err != nil {
+		cl.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	return &ROSConnection{
+		Client:   cl,
+		lastUsed: time.Now(),
+	}, nil
+}
+
+// Get returns an idle connection, dials a new one or waits until one is
+// returned to the pool
+func (p *ROSConnectionPool) Get(ctx context.Context) (*ROSConnection, error) {
+	p.mutex.Lock()
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mutex.Unlock()
+		return c, nil
+	}
+	if p.open < p.cfg.poolMax {
+		p.open++
+		p.mutex.Unlock()
+		roslog.Trace("ROSConnectionPool.Get(): Creating a new connection")
+		return p.dial(ctx)
+	}
+
+	// wait for a connection (or a free slot indicated by nil)
+	ch := make(chan *ROSConnection, 1)
+	p.waiters = append(p.waiters, ch)
+	p.stats.Waits++
+	p.mutex.Unlock()
+	roslog.Trace("ROSConnectionPool.Get(): Waiting for a connection (max %d)", p.cfg.poolMax)
+
+	select {
+	case c := <-ch:
+		if c == nil {
+			return p.dial(ctx)
+		}
+		return c, nil
+	case <-ctx.Done():
+		p.mutex.Lock()
+		p.stats.WaitCanceled++
+		for i, w := range p.waiters {
+			if w == ch {
+				p.waiters = append(p.waiters[:i:i], p.waiters[i+1:]...)
+				p.mutex.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		p.mutex.Unlock()
+		// handed over concurrently, pass it on
+		if c := <-ch; c != nil {
+			p.Put(c)
+		} else {
+			p.discard(nil, nil)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Put returns a healthy connection to the pool
+func (p *ROSConnectionPool) Put(c *ROSConnection) {
+	p.put(c, true)
+}
+
+// put returns c to the pool. used is false for the health check so that
+// the idle timeout keeps counting.
+func (p *ROSConnectionPool) put(c *ROSConnection, used bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if used {
+		c.lastUsed = time.Now()
+	}
+	select {
+	case <-p.closed:
+		c.Close()
+		p.stats.Closed++
+		p.open--
+		return
+	default:
+	}
+	if len(p.waiters) > 0 {
+		ch := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		ch <- c
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+// discard closes c (if any) and releases its slot. counter is incremented
+// to record why the connection was lost.
+func (p *ROSConnectionPool) discard(c *ROSConnection, counter *int64) {
+	if c != nil {
+		c.Close()
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if c != nil {
+		p.stats.Closed++
+	}
+	if counter != nil {
+		*counter++
+	}
+	if len(p.waiters) > 0 {
+		// let the first waiter dial using the slot
+		ch := p.waiters[0]
+		p.waiters = p.waiters[1:]
+		ch <- nil
+		return
+	}
+	p.open--
+}
+
+func (p *ROSConnectionPool) Stats() ROSPoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := p.stats
+	stats.Open = p.open
+	stats.Idle = len(p.idle)
+	return stats
+}
+
+func (p *ROSConnectionPool) RunArgs(ctx context.Context, args []string) (*routeros.Reply, error) {
+	conn, err := p.Get(ctx)
+	if err != nil {
+		// not a !trap of the command even when the login is rejected
+		return nil, fmt.Errorf("failed to connect to RouterOS API: %w", err)
+	}
+	rep, err := conn.run(ctx, args)
+	if _, isTrap := err.(*routeros.DeviceError); err != nil && !isTrap {
+		// the connection is broken, do not return it to the pool
+		p.discard(conn, nil)
+	} else {
+		p.Put(conn)
+	}
+	return rep, err
+}
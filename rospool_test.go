@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/rosapitest"
+)
+
+func TestROSConnectionPool(t *testing.T) {
+	srv, err := rosapitest.NewServer("fletsv6", "password")
+	if err != nil {
+		t.Fatalf("rosapitest.NewServer failed: %s", err)
+	}
+	defer srv.Close()
+	newPool := func(modify func(cfg *ROSConnectConfig)) *ROSConnectionPool {
+		cfg := ROSConnectConfig{host: srv.Host(), port: srv.Port(), username: "fletsv6", password: "password", readTimeout: time.Second}
+		modify(&cfg)
+		return NewROSConnectionPool(cfg)
+	}
+	waitFor := func(what string, p *ROSConnectionPool, cond func(stats ROSPoolStats) bool) {
+		deadline := time.Now().Add(time.Second * 2)
+		for !cond(p.Stats()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s: %+v", what, p.Stats())
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+
+	// callers beyond ROS_POOL_MAX wait in FIFO order
+	p := newPool(func(cfg *ROSConnectConfig) { cfg.poolMax = 1 })
+	logins := srv.Logins()
+	c, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	var mutex sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := p.Get(context.Background())
+			if err != nil {
+				t.Errorf("waiting Get failed: %s", err)
+				return
+			}
+			mutex.Lock()
+			order = append(order, i)
+			mutex.Unlock()
+			p.Put(c)
+		}(i)
+		waitFor("the waiter to be queued", p, func(stats ROSPoolStats) bool { return stats.Waits == int64(i+1) })
+	}
+	p.Put(c)
+	wg.Wait()
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Fatalf("waiters were not served in order: %v", order)
+	}
+	if stats := p.Stats(); srv.Logins()-logins != 1 || stats.Open != 1 || stats.Created != 1 {
+		t.Fatalf("pool exceeded ROS_POOL_MAX: logins=%d stats=%+v", srv.Logins()-logins, stats)
+	}
+
+	// connections returned after Close are closed
+	c, _ = p.Get(context.Background())
+	p.Close()
+	p.Put(c)
+	if stats := p.Stats(); stats.Open != 0 || stats.Idle != 0 || stats.Closed != 1 {
+		t.Fatalf("unexpected stats after Close: %+v", stats)
+	}
+
+	// idle connections are health-checked and closed after ROS_POOL_IDLE_TIMEOUT
+	p = newPool(func(cfg *ROSConnectConfig) {
+		cfg.poolIdleTimeout = time.Millisecond * 300
+		cfg.poolHealthInterval = time.Millisecond * 50
+	})
+	defer p.Close()
+	checks := srv.CommandCount("/system/resource/print")
+	c, err = p.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	p.Put(c)
+	waitFor("the idle timeout", p, func(stats ROSPoolStats) bool { return stats.IdleTimeouts == 1 })
+	if stats := p.Stats(); stats.Open != 0 || stats.HealthCheckFailures != 0 {
+		t.Fatalf("unexpected stats after the idle timeout: %+v", stats)
+	}
+	if srv.CommandCount("/system/resource/print") == checks {
+		t.Fatalf("idle connection was not health-checked")
+	}
+
+	// broken idle connections are discarded
+	p = newPool(func(cfg *ROSConnectConfig) { cfg.poolHealthInterval = time.Millisecond * 50 })
+	defer p.Close()
+	srv.InjectDrop("/system/resource/print", 1)
+	c, err = p.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	p.Put(c)
+	waitFor("the failed health check", p, func(stats ROSPoolStats) bool { return stats.HealthCheckFailures == 1 })
+	if stats := p.Stats(); stats.Open != 0 || stats.Closed != 1 {
+		t.Fatalf("unexpected stats after the failed health check: %+v", stats)
+	}
+
+	// ROS_POOL_MIN connections are kept open
+	logins = srv.Logins()
+	p = newPool(func(cfg *ROSConnectConfig) {
+		cfg.poolMin = 2
+		cfg.poolIdleTimeout = time.Millisecond * 50
+		cfg.poolHealthInterval = time.Millisecond * 50
+	})
+	defer p.Close()
+	waitFor("ROS_POOL_MIN connections", p, func(stats ROSPoolStats) bool { return stats.Idle == 2 })
+	time.Sleep(time.Millisecond * 200)
+	if stats := p.Stats(); stats.Open != 2 || stats.IdleTimeouts != 0 || srv.Logins()-logins != 2 {
+		t.Fatalf("ROS_POOL_MIN connections were not kept: %+v", stats)
+	}
+}
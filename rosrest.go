@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-routeros/routeros"
+	"github.com/go-routeros/routeros/proto"
+)
+
+// ROSRESTClient is a rosTransport for the RouterOS 7 REST API (/rest).
+// API commands are mapped onto REST verbs as follows:
+//
+//	<path>/print   POST   /rest/<path>/print  {".proplist": [...], ".query": [...]}
+//	<path>/add     PUT    /rest/<path>        {attributes}
+//	<path>/set     PATCH  /rest/<path>/<id>   {attributes}
+//	<path>/remove  DELETE /rest/<path>/<id>
+//	<command>      POST   /rest/<command>     {attributes}
+type ROSRESTClient struct {
+	cfg     ROSConnectConfig
+	baseURL string
+	client  *http.Client
+}
+
+type rosRESTError struct {
+	Error   int    `json:"error"`
+	Message string `json:"message"`
+	Detail  string `json:"detail"`
+}
+
+func NewROSRESTClient(cfg ROSConnectConfig) *ROSRESTClient {
+	scheme := "http"
+	if cfg.useTLS {
+		scheme = "https"
+	}
+	return &ROSRESTClient{
+		cfg:     cfg,
+		baseURL: fmt.Sprintf("%s://%s/rest", scheme, net.JoinHostPort(cfg.host, strconv.Itoa(cfg.port))),
+		// timeouts are given by the context
+		client: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:     rosTLSConfig(cfg),
+				MaxIdleConnsPerHost: 4,
+				IdleConnTimeout:     time.Minute,
+			},
+		},
+	}
+}
+
+func (c *ROSRESTClient) RunArgs(ctx context.Context, args []string) (*routeros.Reply, error) {
+	cmd := args[0]
+	attrs := make(map[string]string)
+	var queries []string
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "?") {
+			queries = append(queries, arg[1:])
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(arg, "="), "=", 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		attrs[kv[0]] = kv[1]
+	}
+
+	var method, target string
+	var body interface{}
+	switch path.Base(cmd) {
+	case "print":
+		method, target = http.MethodPost, cmd
+		req := map[string]interface{}{}
+		if pl, ok := attrs[".proplist"]; ok {
+			req[".proplist"] = strings.Split(pl, ",")
+		}
+		if len(queries) > 0 {
+			req[".query"] = queries
+		}
+		body = req
+	case "add":
+		method, target, body = http.MethodPut, path.Dir(cmd), attrs
+	case "set":
+		id := attrs[".id"]
+		delete(attrs, ".id")
+		method, target, body = http.MethodPatch, path.Dir(cmd)+"/"+id, attrs
+	case "remove":
+		method, target = http.MethodDelete, path.Dir(cmd)+"/"+attrs[".id"]
+	default:
+		method, target, body = http.MethodPost, cmd, attrs
+	}
+
+	res, err := c.do(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// convert to the binary API reply format
+	rep := &routeros.Reply{Done: &proto.Sentence{Word: "!done", Map: map[string]string{}}}
+	switch v := res.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if obj, ok := item.(map[string]interface{}); ok {
+				rep.Re = append(rep.Re, restSentence(obj))
+			}
+		}
+	case map[string]interface{}:
+		if path.Base(cmd) == "add" {
+			rep.Done.Map["ret"] = restSentence(v).Map[".id"]
+		} else if len(v) > 0 && path.Base(cmd) != "set" {
+			rep.Re = append(rep.Re, restSentence(v))
+		}
+	}
+
+	return rep, nil
+}
+
+func (c *ROSRESTClient) do(ctx context.Context, method string, target string, body interface{}) (interface{}, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	roslog.Trace("  REST %s %s", method, target)
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+target, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.cfg.username, c.cfg.password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 400 {
+		var rerr rosRESTError
+		if json.Unmarshal(data, &rerr) != nil || rerr.Message == "" {
+			rerr.Message = res.Status
+		}
+		if res.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("RouterOS REST API: %s", rerr.Message)
+		}
+		// map to the same error type as !trap of the binary API
+		msg := rerr.Detail
+		if msg == "" {
+			msg = rerr.Message
+		}
+		return nil, &routeros.DeviceError{Sentence: &proto.Sentence{
+			Word: "!trap",
+			Map:  map[string]string{"message": msg},
+		}}
+	}
+
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("RouterOS REST API returned malformed JSON: %s", err)
+	}
+	return v, nil
+}
+
+func (c *ROSRESTClient) Close() {
+	c.client.CloseIdleConnections()
+}
+
+func restSentence(obj map[string]interface{}) *proto.Sentence {
+	sen := proto.NewSentence()
+	sen.Word = "!re"
+	for k, v := range obj {
+		var s string
+		switch vv := v.(type) {
+		case string:
+			s = vv
+		case nil:
+			s = ""
+		default:
+			s = fmt.Sprint(vv)
+		}
+		sen.List = append(sen.List, proto.Pair{Key: k, Value: s})
+		sen.Map[k] = s
+	}
+	return sen
+}
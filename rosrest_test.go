@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grainrigi/routeros-fletsv6-companion/rosapitest"
+)
+
+func TestROSRESTStandin(t *testing.T) {
+	srv, err := rosapitest.NewServer("fletsv6", "password")
+	if err != nil {
+		t.Fatalf("rosapitest.NewServer failed: %s", err)
+	}
+	defer srv.Close()
+	hs := httptest.NewServer(srv.RESTHandler())
+	defer hs.Close()
+	addr := hs.Listener.Addr().(*net.TCPAddr)
+	srv.SetInterface("vlanflets", "48:A9:8A:22:C8:C8")
+	srv.AddNeighbor("2001:db8:1::1234", "B8:27:EB:F6:0F:8F", "reachable")
+
+	c, err := NewROSClient(ROSConnectConfig{host: addr.IP.String(), port: addr.Port, username: "fletsv6", password: "password", protocol: "rest", dryRun: "off"})
+	if err != nil {
+		t.Fatalf("NewROSClient failed: %s", err)
+	}
+	mac, err := c.GetInterfaceMAC(context.Background(), "vlanflets")
+	if err != nil || mac.String() != "48:a9:8a:22:c8:c8" {
+		t.Fatalf("GetInterfaceMAC returned %s, %v", mac, err)
+	}
+	mac, err = c.LookupNeighbor(context.Background(), net.ParseIP("2001:db8:1::1234"), 100, true)
+	if err != nil || mac.String() != "b8:27:eb:f6:0f:8f" {
+		t.Fatalf("LookupNeighbor returned %s, %v", mac, err)
+	}
+
+	// same reconcile result as the binary API
+	cfg := &RAConfig{mode: "ros", rosExtIf: "vlanflets", rosGC: "on"}
+	eip, _ := ParseROSIPAssign("ra-prefix::1/128@@external", cfg.rosExtIf)
+	cfg.rosExtIPs = append(cfg.rosExtIPs, eip)
+	rac := NewRAClient(cfg, c)
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+	rac.reconcile(context.Background(), "solicited")
+	_, n, _ = net.ParseCIDR("2001:db8:2::/64")
+	rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+	rac.reconcile(context.Background(), "changed")
+	if r := rac.LastReconcile(); r.Changes != 1 || len(r.Errors) != 0 {
+		t.Fatalf("unexpected reconcile result: %+v", r)
+	}
+	if rows := srv.Rows("/ipv6/address"); len(rows) != 1 || rows[0]["address"] != "2001:db8:2::1/128" {
+		t.Fatalf("unexpected /ipv6/address: %+v", rows)
+	}
+
+	// errors are mapped like !trap
+	srv.InjectTrap("/ipv6/route/print", "failure: simulated", 1)
+	if _, err := c.SetIPv6Gateway(context.Background(), "vlanflets", net.ParseIP("fe80::1")); err == nil || !strings.Contains(err.Error(), "failure: simulated") {
+		t.Fatalf("REST error was not mapped: %v", err)
+	}
+	if err := c.RemoveObject(context.Background(), ROSObject{path: "/ipv6/address", id: "*FFFF"}); !errors.Is(err, ErrROSNotFound) {
+		t.Fatalf("removing a missing item returned %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -80,6 +81,44 @@ func (s *Socket) ReadOnce(timeout *time.Duration) ([]byte, error) {
 	return s.readImmediate()
 }
 
+// socketPollInterval bounds how long ReadContext waits before checking ctx
+const socketPollInterval = time.Second
+
+// ReadContext is ReadOnce which returns when ctx is done
+func (s *Socket) ReadContext(ctx context.Context, timeout *time.Duration) ([]byte, error) {
+	var deadline time.Time
+	if timeout != nil {
+		deadline = time.Now().Add(*timeout)
+	}
+	for {
+		wait := socketPollInterval
+		if timeout != nil {
+			if remain := time.Until(deadline); remain < wait {
+				wait = remain
+			}
+			if wait < 0 {
+				wait = 0
+			}
+		}
+		s2, err := epollOnce([]*Socket{s}, &wait)
+		if err != nil {
+			return nil, err
+		}
+		if !s.isValid {
+			return nil, fmt.Errorf("the socket has been closed")
+		}
+		if s2 != nil {
+			return s.readImmediate()
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("canceled by context")
+		}
+		if timeout != nil && !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("Read timed out")
+		}
+	}
+}
+
 func (s *Socket) LinkLocal() net.IP {
 	ips, err := s.netif.Addrs()
 	if err != nil {
@@ -203,6 +242,10 @@ func epollOnce(socks []*Socket, timeout *time.Duration) (*Socket, error) {
 	remainsock := len(socks)
 retry:
 	nevents, err := syscall.EpollWait(epfd, events[:], timeoutMs)
+	if err == syscall.EINTR {
+		// interrupted by a signal (e.g. SIGTERM)
+		goto retry
+	}
 	if err != nil {
 		return nil, fmt.Errorf("EpollWait failed: %s", err)
 	}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"net"
+)
+
+// stablePrivacyAddress fills the host bits of prefix with a stable, semantically
+// opaque interface identifier (RFC 7217):
+//
+//	RID = F(Prefix, Net_Iface, DAD_Counter, secret_key)
+//
+// where F is HMAC-SHA256 keyed with secret. The same prefix and interface
+// always give the same address while a new prefix gives an unrelated one.
+// DAD_Counter is increased only to skip the reserved IIDs (RFC 5453).
+func stablePrivacyAddress(prefix *net.IPNet, ifname string, secret string) *net.IPNet {
+	network := prefix.IP.Mask(prefix.Mask).To16()
+	ip := make(net.IP, 16)
+	for counter := 0; ; counter++ {
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write(network)
+		h.Write([]byte(ifname))
+		h.Write([]byte{byte(counter)})
+		rid := h.Sum(nil)
+		for i := range ip {
+			ip[i] = network[i] | (rid[i] &^ prefix.Mask[i])
+		}
+		if !isReservedIID(ip, prefix.Mask) || counter == 255 {
+			break
+		}
+	}
+	return &net.IPNet{IP: ip, Mask: prefix.Mask}
+}
+
+// isReservedIID reports whether ip has an interface identifier that must not
+// be used for a unicast address: the Subnet-Router anycast (all zeros) and the
+// IANA reserved identifiers of RFC 5453
+func isReservedIID(ip net.IP, mask net.IPMask) bool {
+	zero := true
+	for i := range ip {
+		if ip[i]&^mask[i] != 0 {
+			zero = false
+			break
+		}
+	}
+	if zero {
+		return true
+	}
+	if ones, _ := mask.Size(); ones > 64 {
+		return false
+	}
+	iid := ip[8:]
+	// 0200:5EFF:FE00:0000 - 0200:5EFF:FEFF:FFFF
+	if bytes.Equal(iid[:5], []byte{0x02, 0x00, 0x5e, 0xff, 0xfe}) {
+		return true
+	}
+	// FDFF:FFFF:FFFF:FF80 - FDFF:FFFF:FFFF:FFFF (subnet anycast)
+	return bytes.Equal(iid[:7], []byte{0xfd, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) && iid[7] >= 0x80
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestStablePrivacy(t *testing.T) {
+	for _, bad := range []string{
+		"ra-prefix::1/64@bridge:stable-privacy",
+		"ra-prefix::1@bridge:stable-privacy",
+		"ra-prefix::/64@bridge:eui-64:stable-privacy",
+	} {
+		if _, err := ParseROSIPAssign(bad, ""); err == nil {
+			t.Fatalf("ParseROSIPAssign accepted %s", bad)
+		}
+	}
+
+	fake := NewFakeRouter()
+	cfg := &RAConfig{mode: "ros", rosGC: "on", stablePrivacySecret: "secret"}
+	for _, a := range []string{"ra-prefix::/64@bridge:stable-privacy", "ra-prefix::/64@bridge-guest:stable-privacy", "ra-prefix::/64@bridge"} {
+		ass, err := ParseROSIPAssign(a, "")
+		if err != nil {
+			t.Fatalf("ParseROSIPAssign failed: %s", err)
+		}
+		cfg.rosIntIPs = append(cfg.rosIntIPs, ass)
+	}
+	rac := NewRAClient(cfg, fake)
+	setPrefix := func(prefix string) {
+		_, n, _ := net.ParseCIDR(prefix)
+		rac.routerInfo = &RouterInfo{prefix: *n, gateway: net.ParseIP("fe80::1")}
+	}
+	addresses := func() map[string]string {
+		m := make(map[string]string)
+		for _, row := range fake.Rows("/ipv6/address") {
+			m[row["interface"]+" "+row["comment"]] = row["address"]
+		}
+		return m
+	}
+
+	setPrefix("2001:db8:1::/64")
+	rac.reconcile(context.Background(), "solicited")
+	first := addresses()
+	if len(first) != 3 {
+		t.Fatalf("expected 3 addresses, got %+v", first)
+	}
+	seen := make(map[string]bool)
+	for _, addr := range first {
+		_, n, _ := net.ParseCIDR(addr)
+		if n.String() != "2001:db8:1::/64" || seen[addr] {
+			t.Fatalf("unexpected addresses: %+v", first)
+		}
+		seen[addr] = true
+	}
+
+	// stable for the same prefix
+	rac.reconcile(context.Background(), "periodic")
+	if r := rac.LastReconcile(); r.Changes != 0 {
+		t.Fatalf("reconcile in desired state made %d changes", r.Changes)
+	}
+
+	// recomputed on renumbering, updating the objects in place
+	setPrefix("2001:db8:2::/64")
+	rac.reconcile(context.Background(), "changed")
+	second := addresses()
+	if len(second) != 3 {
+		t.Fatalf("expected 3 addresses, got %+v", second)
+	}
+	for k, addr := range second {
+		old := strings.Replace(first[k], "2001:db8:1:", "2001:db8:2:", 1)
+		if !strings.HasPrefix(addr, "2001:db8:2:") || (strings.Contains(k, "stable-privacy") && addr == old) {
+			t.Fatalf("%s was not recomputed: %s -> %s", k, first[k], addr)
+		}
+	}
+
+	// deterministic for the secret
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	a := stablePrivacyAddress(n, "bridge", "secret")
+	if b := stablePrivacyAddress(n, "bridge", "secret"); !a.IP.Equal(b.IP) {
+		t.Fatalf("stablePrivacyAddress is not stable: %s %s", a, b)
+	}
+	if b := stablePrivacyAddress(n, "bridge", "other"); a.IP.Equal(b.IP) {
+		t.Fatalf("stablePrivacyAddress ignores the secret")
+	}
+	if !isReservedIID(net.ParseIP("2001:db8::fdff:ffff:ffff:ff80"), n.Mask) || !isReservedIID(net.ParseIP("2001:db8::"), n.Mask) ||
+		isReservedIID(net.ParseIP("2001:db8::1"), n.Mask) {
+		t.Fatalf("isReservedIID is broken")
+	}
+}
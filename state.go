@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lifetime 0xffffffff in RAs
+const infiniteLifetime = time.Duration(math.MaxInt64)
+
+// how often the state file is rewritten while RouterInfo stays the same
+const stateSaveInterval = time.Hour
+
+// RouterInfoState is the content of RA_STATE_FILE
+type RouterInfoState struct {
+	Prefix            string    `json:"prefix"`
+	Gateway           string    `json:"gateway"`
+	Received          time.Time `json:"received"`
+	ValidLifetime     int64     `json:"valid_lifetime"` // seconds. -1 if infinite
+	PreferredLifetime int64     `json:"preferred_lifetime"`
+	RouterLifetime    int64     `json:"router_lifetime"`
+}
+
+func lifetimeToSeconds(d time.Duration) int64 {
+	if d == infiniteLifetime {
+		return -1
+	}
+	return int64(d / time.Second)
+}
+
+func secondsToLifetime(s int64) time.Duration {
+	if s < 0 {
+		return infiniteLifetime
+	}
+	return time.Duration(s) * time.Second
+}
+
+// lifetimeFromRA converts a lifetime field of an RA
+func lifetimeFromRA(s uint32) time.Duration {
+	if s == 0xffffffff {
+		return infiniteLifetime
+	}
+	return time.Duration(s) * time.Second
+}
+
+// Expired reports whether the valid lifetime of the prefix has passed at now
+func (r *RouterInfo) Expired(now time.Time) bool {
+	if r.validLifetime == infiniteLifetime || r.received.IsZero() {
+		return false
+	}
+	return now.After(r.received.Add(r.validLifetime))
+}
+
+// writeRouterInfoState writes rinfo to path atomically
+func writeRouterInfoState(path string, rinfo *RouterInfo) error {
+	state := RouterInfoState{
+		Prefix:            rinfo.prefix.String(),
+		Gateway:           rinfo.gateway.String(),
+		Received:          rinfo.received,
+		ValidLifetime:     lifetimeToSeconds(rinfo.validLifetime),
+		PreferredLifetime: lifetimeToSeconds(rinfo.preferredLifetime),
+		RouterLifetime:    lifetimeToSeconds(rinfo.routerLifetime),
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".fletsv6-state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readRouterInfoState reads RouterInfo written by writeRouterInfoState.
+// It is marked as provisional.
+func readRouterInfoState(path string) (*RouterInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state RouterInfoState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	_, prefix, err := net.ParseCIDR(state.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prefix in %s: %s", path, state.Prefix)
+	}
+	gateway := net.ParseIP(state.Gateway)
+	if gateway == nil {
+		return nil, fmt.Errorf("invalid gateway in %s: %s", path, state.Gateway)
+	}
+	return &RouterInfo{
+		prefix:            *prefix,
+		gateway:           gateway,
+		received:          state.Received,
+		validLifetime:     secondsToLifetime(state.ValidLifetime),
+		preferredLifetime: secondsToLifetime(state.PreferredLifetime),
+		routerLifetime:    secondsToLifetime(state.RouterLifetime),
+		provisional:       true,
+	}, nil
+}
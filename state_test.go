@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	dir, err := os.MkdirTemp("", "fletsv6-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/state.json"
+
+	_, n, _ := net.ParseCIDR("2001:db8:1::/64")
+	saved := &RouterInfo{
+		prefix:            *n,
+		gateway:           net.ParseIP("fe80::1"),
+		received:          time.Now().Add(-time.Hour),
+		validLifetime:     time.Hour * 24,
+		preferredLifetime: infiniteLifetime,
+		routerLifetime:    time.Minute * 30,
+	}
+	if err := writeRouterInfoState(path, saved); err != nil {
+		t.Fatalf("writeRouterInfoState failed: %s", err)
+	}
+
+	// restored as provisional and usable for ra-prefix
+	rac := NewRAClient(&RAConfig{mode: "ros", stateFile: path}, nil)
+	if !rac.restoreState() {
+		t.Fatalf("restoreState failed")
+	}
+	rinfo := rac.RouterInfo()
+	if !rinfo.provisional || rinfo.preferredLifetime != infiniteLifetime || !rinfo.received.Equal(saved.received.Round(0)) {
+		t.Fatalf("unexpected RouterInfo: %+v", rinfo)
+	}
+	fip, _ := ParseFlexibleIP("ra-prefix::1/128")
+	if ip := rac.ResolveFIP(fip); ip == nil || ip.IP.String() != "2001:db8:1::1" {
+		t.Fatalf("ResolveFIP returned %s", ip)
+	}
+
+	// the valid lifetime has passed
+	saved.received = time.Now().Add(-time.Hour * 25)
+	if err := writeRouterInfoState(path, saved); err != nil {
+		t.Fatal(err)
+	}
+	rac = NewRAClient(&RAConfig{mode: "ros", stateFile: path}, nil)
+	if rac.restoreState() || rac.RouterInfo() != nil {
+		t.Fatalf("restored an expired RouterInfo")
+	}
+
+	// broken or missing files are ignored
+	_ = os.WriteFile(path, []byte("{"), 0644)
+	if rac.restoreState() {
+		t.Fatalf("restored a broken state file")
+	}
+	os.Remove(path)
+	if rac.restoreState() {
+		t.Fatalf("restored a missing state file")
+	}
+}
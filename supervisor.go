@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// backoff between restarts of a failed worker
+const (
+	supervisorBackoffMin = time.Second
+	supervisorBackoffMax = time.Minute * 5
+	// a worker running longer than this is considered recovered
+	supervisorStableAfter = time.Minute
+	// consecutive failures to report a worker as crash-looping
+	supervisorCrashLoop = 5
+)
+
+// Supervisor runs each worker independently and restarts it with
+// exponential backoff when it fails or panics
+type Supervisor struct {
+	workers  []*WorkerStatus
+	restarts map[string]chan struct{}
+	done     chan error
+	wg       sync.WaitGroup
+	mutex    sync.Mutex
+}
+
+type WorkerStatus struct {
+	Name      string    `json:"name"`
+	State     string    `json:"state"` // running, backoff, stopped
+	Restarts  int       `json:"restarts"`
+	Failures  int       `json:"consecutive_failures"`
+	CrashLoop bool      `json:"crash_loop"`
+	LastError string    `json:"last_error,omitempty"`
+	LastStart time.Time `json:"last_start"`
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		restarts: make(map[string]chan struct{}),
+		done:     make(chan error, 1),
+	}
+}
+
+// Go starts f under supervision. f is restarted until ctx is done.
+// errPlanCompleted is not a failure and stops the whole process (see Done).
+func (s *Supervisor) Go(ctx context.Context, name string, f func(context.Context) error) {
+	st := &WorkerStatus{Name: name}
+	restart := make(chan struct{}, 1)
+	s.mutex.Lock()
+	s.workers = append(s.workers, st)
+	s.restarts[name] = restart
+	s.mutex.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			start := time.Now()
+			s.update(st, func() {
+				st.State = "running"
+				st.LastStart = start
+			})
+			restarted, err := runRestartable(ctx, f, restart)
+			if ctx.Err() != nil {
+				s.update(st, func() { st.State = "stopped" })
+				return
+			}
+			if restarted {
+				llog.Info("Restarted %s", name)
+				continue
+			}
+			if err == errPlanCompleted {
+				s.update(st, func() { st.State = "stopped" })
+				select {
+				case s.done <- err:
+				default:
+				}
+				return
+			}
+			if err == nil {
+				err = fmt.Errorf("worker exited unexpectedly")
+			}
+
+			var wait time.Duration
+			var failures int
+			var crashLoop bool
+			s.update(st, func() {
+				if time.Since(start) >= supervisorStableAfter {
+					st.Failures = 0
+				}
+				st.Failures++
+				st.Restarts++
+				st.LastError = err.Error()
+				st.CrashLoop = st.Failures >= supervisorCrashLoop
+				st.State = "backoff"
+				wait = supervisorBackoff(st.Failures)
+				failures, crashLoop = st.Failures, st.CrashLoop
+			})
+			llog.Error("%s failed: %s", name, err)
+			if crashLoop {
+				llog.Error("%s is crash-looping (%d consecutive failures). Restarting in %s", name, failures, wait.Round(time.Millisecond))
+			} else {
+				llog.Warning("Restarting %s in %s", name, wait.Round(time.Millisecond))
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-restart:
+				// a new configuration may fix the failure
+			case <-ctx.Done():
+				s.update(st, func() { st.State = "stopped" })
+				return
+			}
+		}
+	}()
+}
+
+func (s *Supervisor) update(st *WorkerStatus, f func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f()
+}
+
+// supervisorBackoff returns min*2^(failures-1) capped at max with +-20% jitter
+func supervisorBackoff(failures int) time.Duration {
+	wait := supervisorBackoffMin
+	for i := 1; i < failures && wait < supervisorBackoffMax; i++ {
+		wait *= 2
+	}
+	if wait > supervisorBackoffMax {
+		wait = supervisorBackoffMax
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)*2/5+1)) - wait/5
+}
+
+// runRestartable runs f until it returns or a restart is requested, in which
+// case the context of f is canceled and restarted is true
+func runRestartable(ctx context.Context, f func(context.Context) error, restart <-chan struct{}) (bool, error) {
+	runctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	requested := make(chan bool, 1)
+	go func() {
+		select {
+		case <-restart:
+			cancel()
+			requested <- true
+		case <-runctx.Done():
+			requested <- false
+		}
+	}()
+	err := runProtected(runctx, f)
+	cancel()
+	return <-requested, err
+}
+
+// runProtected runs f converting a panic into an error
+func runProtected(ctx context.Context, f func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			llog.Error("panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return f(ctx)
+}
+
+// Restart cancels the running worker and starts it again without backoff,
+// e.g. to apply a new configuration. It does nothing if name is unknown.
+func (s *Supervisor) Restart(name string) {
+	s.mutex.Lock()
+	restart, ok := s.restarts[name]
+	s.mutex.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case restart <- struct{}{}:
+	default:
+		// already requested
+	}
+}
+
+// Status returns a snapshot of all workers
+func (s *Supervisor) Status() []WorkerStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	status := make([]WorkerStatus, len(s.workers))
+	for i, st := range s.workers {
+		status[i] = *st
+	}
+	return status
+}
+
+// Done receives an error when a worker requests the process to exit
+func (s *Supervisor) Done() <-chan error {
+	return s.done
+}
+
+// Wait waits for all workers to stop until timeout
+func (s *Supervisor) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
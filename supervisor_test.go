@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSupervisor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sup := NewSupervisor()
+
+	// a panicking worker is restarted without affecting the others
+	var mutex sync.Mutex
+	runs := 0
+	sup.Go(ctx, "panicking", func(ctx context.Context) error {
+		mutex.Lock()
+		runs++
+		n := runs
+		mutex.Unlock()
+		if n <= 2 {
+			panic("simulated")
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	sup.Go(ctx, "healthy", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	time.Sleep(time.Second * 4)
+	for _, st := range sup.Status() {
+		switch st.Name {
+		case "panicking":
+			if st.State != "running" || st.Restarts != 2 || st.Failures != 2 || st.LastError != "panic: simulated" {
+				t.Fatalf("unexpected status: %+v", st)
+			}
+		case "healthy":
+			if st.State != "running" || st.Restarts != 0 {
+				t.Fatalf("unexpected status: %+v", st)
+			}
+		}
+	}
+
+	// backoff grows exponentially and is capped
+	if b := supervisorBackoff(3); b < time.Millisecond*3200 || b > time.Millisecond*4800 {
+		t.Fatalf("unexpected backoff: %s", b)
+	}
+	if b := supervisorBackoff(100); b > supervisorBackoffMax*6/5 {
+		t.Fatalf("backoff was not capped: %s", b)
+	}
+
+	cancel()
+	if !sup.Wait(time.Second) {
+		t.Fatalf("workers did not stop")
+	}
+}